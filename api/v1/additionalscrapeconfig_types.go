@@ -17,23 +17,334 @@ limitations under the License.
 package v1
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // AdditionalScrapeConfigSpec defines the desired state of AdditionalScrapeConfig
 type AdditionalScrapeConfigSpec struct {
-	SecretName                 string            `json:"secretName"`
-	SecretNamespace            string            `json:"secretNamespace"`
-	SecretKey                  string            `json:"secretKey"`
+	SecretName      string `json:"secretName"`
+	SecretNamespace string `json:"secretNamespace"`
+	SecretKey       string `json:"secretKey"`
+	// Labels a ScrapeJob must have to be included. An empty set selects every
+	// ScrapeJob in the namespaces matched by ScrapeJobNamespaceSelector.
 	ScrapeJobLabels            map[string]string `json:"scrapeJobLabels,omitempty"`
 	ScrapeJobNamespaceSelector NamespaceSelector `json:"scrapeJobNamespaceSelector,omitempty"`
+	// Allows writing into an already existing secret that is not managed by the
+	// operator. Without it the config refuses to touch secrets it didn't create.
+	AdoptExistingSecret bool `json:"adoptExistingSecret,omitempty"`
+	// Delays the reconciliation after a ScrapeJob change by this long, so all
+	// the changes made within the window result in a single secret write.
+	// Changes are applied immediately if unset or zero.
+	DebounceWindow *metav1.Duration `json:"debounceWindow,omitempty"`
+	// The number of rendered outputs kept as revisions in ConfigMaps next to
+	// the config. Defaults to DefaultRevisionHistoryLimit, 0 disables the
+	// revision history.
+	//+kubebuilder:validation:Minimum=0
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// Writes the given revision from the history instead of the rendered
+	// ScrapeJobs, until cleared. New revisions are not recorded while pinned.
+	PinnedRevision *int64 `json:"pinnedRevision,omitempty"`
+	// Stops writing the output secret and changing the selected ScrapeJobs:
+	// expired jobs are not deleted and their status is not updated. The
+	// status of the config is still updated. The config can also be paused
+	// with the PausedAnnotation.
+	Paused bool `json:"paused,omitempty"`
+	// Calls the reload endpoint of a Prometheus server after the output
+	// changes. Only needed for servers not managed by Prometheus Operator.
+	Reload *ReloadSpec `json:"reload,omitempty"`
+	// Workloads mounting the secret, restarted when its content changes by
+	// stamping the ChecksumAnnotation onto their pod template. For scrapers
+	// that don't watch their config files. Pods can only mount secrets from
+	// their own namespace, so the workloads have to be in the namespace of the
+	// secret.
+	RolloutWorkloads []WorkloadReference `json:"rolloutWorkloads,omitempty"`
+	// The Prometheus Operator Prometheus or PrometheusAgent object to wire the
+	// secret into, by setting its additionalScrapeConfigs. The Prometheus
+	// Operator only reads secrets from the namespace of the Prometheus object,
+	// so it has to be in the namespace of the secret.
+	PrometheusRef *PrometheusReference `json:"prometheusRef,omitempty"`
+	// Polls the targets API of the Prometheus server scraping the output, and
+	// records the health of the targets in the status of the ScrapeJobs.
+	TargetHealth *TargetHealthSpec `json:"targetHealth,omitempty"`
+}
+
+//+kubebuilder:validation:XValidation:rule="has(self.url) != has(self.service)",message="exactly one of url and service is required"
+
+// TargetHealthSpec configures the polling of the target health.
+type TargetHealthSpec struct {
+	PrometheusEndpoint `json:",inline"`
+	// The time between two polls. Defaults to DefaultTargetHealthInterval.
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// DefaultTargetHealthInterval is the target health polling interval if not
+// set.
+const DefaultTargetHealthInterval = time.Minute
+
+// GetInterval returns the time between two polls.
+func (r *TargetHealthSpec) GetInterval() time.Duration {
+	if nil == r.Interval {
+		return DefaultTargetHealthInterval
+	}
+
+	return r.Interval.Duration
+}
+
+// PrometheusReference identifies a Prometheus Operator Prometheus or
+// PrometheusAgent object in the namespace of the secret.
+type PrometheusReference struct {
+	//+kubebuilder:validation:Enum=Prometheus;PrometheusAgent
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// WiredPrometheus identifies the Prometheus Operator object the secret was
+// wired into.
+type WiredPrometheus struct {
+	Kind      string `json:"kind"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// ChecksumAnnotation is set on the pod template of the rollout workloads to
+// the checksum of the secret data.
+const ChecksumAnnotation = "prometheus-static-target.kube-stager.io/checksum"
+
+// WorkloadReference identifies a workload in the namespace of the secret.
+type WorkloadReference struct {
+	//+kubebuilder:validation:Enum=Deployment;StatefulSet;DaemonSet
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+//+kubebuilder:validation:XValidation:rule="has(self.url) != has(self.service)",message="exactly one of url and service is required"
+
+// ReloadSpec configures the reload of a Prometheus server.
+type ReloadSpec struct {
+	PrometheusEndpoint `json:",inline"`
+	// The time to wait after the output changes before the first reload, so
+	// the kubelet can update the mounted secret. The controller can't see when
+	// the kubelet synced the volume, so this is an approximation: it should
+	// exceed the kubelet sync period plus its cache TTL, and be raised if
+	// reloads pick up the previous output. Defaults to DefaultReloadDelay.
+	Delay *metav1.Duration `json:"delay,omitempty"`
+	// The number of reload attempts with a backoff. After the last attempt the
+	// reload is retried every 10 minutes until it succeeds. Defaults to
+	// DefaultReloadMaxAttempts.
+	//+kubebuilder:validation:Minimum=1
+	MaxAttempts *int32 `json:"maxAttempts,omitempty"`
+}
+
+const (
+	// DefaultReloadDelay is the reload delay if not set. It covers the
+	// default kubelet sync period of one minute and the secret cache TTL.
+	DefaultReloadDelay = 90 * time.Second
+	// DefaultReloadMaxAttempts is the number of reload attempts if not set.
+	DefaultReloadMaxAttempts = 5
+)
+
+// GetDelay returns the time to wait before the first reload.
+func (r *ReloadSpec) GetDelay() time.Duration {
+	if nil == r.Delay {
+		return DefaultReloadDelay
+	}
+
+	return r.Delay.Duration
+}
+
+// GetMaxAttempts returns the number of reload attempts.
+func (r *ReloadSpec) GetMaxAttempts() int32 {
+	if nil == r.MaxAttempts {
+		return DefaultReloadMaxAttempts
+	}
+
+	return *r.MaxAttempts
+}
+
+// PausedAnnotation pauses the config when set to "true", like spec.paused.
+const PausedAnnotation = "prometheus-static-target.kube-stager.io/paused"
+
+// DefaultRevisionHistoryLimit is the number of revisions kept if the limit is
+// not set.
+const DefaultRevisionHistoryLimit = 5
+
+// GetRevisionHistoryLimit returns the number of revisions to keep.
+func (r *AdditionalScrapeConfigSpec) GetRevisionHistoryLimit() int {
+	if nil == r.RevisionHistoryLimit {
+		return DefaultRevisionHistoryLimit
+	}
+
+	return int(*r.RevisionHistoryLimit)
+}
+
+// GetDebounceWindow returns the debounce window, or 0 if it's not set.
+func (r *AdditionalScrapeConfigSpec) GetDebounceWindow() time.Duration {
+	if nil == r.DebounceWindow {
+		return 0
+	}
+
+	return r.DebounceWindow.Duration
+}
+
+// OutputLocation returns the secret key the spec currently renders into.
+func (r *AdditionalScrapeConfigSpec) OutputLocation() *OutputLocation {
+	return &OutputLocation{
+		SecretName:      r.SecretName,
+		SecretNamespace: r.SecretNamespace,
+		SecretKey:       r.SecretKey,
+	}
+}
+
+// ReloadStatus describes the reload of the Prometheus server.
+type ReloadStatus struct {
+	// Whether a reload is due for the last output change.
+	Pending bool `json:"pending,omitempty"`
+	// When the output was last changed.
+	ChangeTime *metav1.Time `json:"changeTime,omitempty"`
+	// The number of reload attempts made since the last output change.
+	Attempts int32 `json:"attempts,omitempty"`
+	// When the last reload attempt was made.
+	LastAttemptTime *metav1.Time `json:"lastAttemptTime,omitempty"`
+	// When the last successful reload was made.
+	LastSuccessTime *metav1.Time `json:"lastSuccessTime,omitempty"`
+	// The error of the last failed attempt.
+	LastError string `json:"lastError,omitempty"`
 }
 
 // AdditionalScrapeConfigStatus defines the observed state of AdditionalScrapeConfig
 type AdditionalScrapeConfigStatus struct {
 	DiscoveredScrapeJobs []string `json:"discoveredScrapeJobs"`
+	// The selected ScrapeJobs excluded from the output by spec.suspend.
+	SuspendedScrapeJobs []string `json:"suspendedScrapeJobs,omitempty"`
+	// The selected ScrapeJobs excluded from the output, because they are
+	// outside their active window.
+	InactiveScrapeJobs []string `json:"inactiveScrapeJobs,omitempty"`
+	// The selected ScrapeJobs excluded from the output, because they can't be
+	// rendered. The other jobs are still written.
+	ExcludedScrapeJobs []ExcludedScrapeJob `json:"excludedScrapeJobs,omitempty"`
+	// The secret key the rendered scrape configs were last written to. Used to
+	// clean up the previous output when the secret name, namespace or key
+	// change.
+	LastOutput *OutputLocation `json:"lastOutput,omitempty"`
+	// The last change written to the rendered scrape configs.
+	LastChange *ConfigChange `json:"lastChange,omitempty"`
+	// The revisions in the history, oldest first.
+	Revisions []Revision `json:"revisions,omitempty"`
+	// The revision currently written to the secret. 0 if the revision history
+	// is disabled.
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+	// The state of the Prometheus reload, if configured.
+	Reload *ReloadStatus `json:"reload,omitempty"`
+	// The Prometheus Operator object the secret was wired into. Its
+	// additionalScrapeConfigs are removed when the prometheusRef changes or the
+	// config is deleted.
+	WiredPrometheus *WiredPrometheus `json:"wiredPrometheus,omitempty"`
+	// Conditions describing the state of the output.
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+const (
+	// ConditionTypeSecretConflict is true when the output can't be written,
+	// because the secret or the key is owned by someone else, or the secret is
+	// outside of the namespaces the controller is restricted to.
+	ConditionTypeSecretConflict = "SecretConflict"
+
+	// ReasonNoConflict is used when the output is owned by the config.
+	ReasonNoConflict = "NoConflict"
+	// ReasonUnmanagedSecret is used when the secret already exists and is not
+	// managed by the operator.
+	ReasonUnmanagedSecret = "UnmanagedSecret"
+	// ReasonKeyOwnedByOtherConfig is used when the secret key is written by a
+	// different AdditionalScrapeConfig.
+	ReasonKeyOwnedByOtherConfig = "KeyOwnedByOtherConfig"
+	// ReasonSecretNamespaceNotWatched is used when the secret namespace is not
+	// one of the namespaces the controller is restricted to.
+	ReasonSecretNamespaceNotWatched = "SecretNamespaceNotWatched"
+
+	// ConditionTypePinned is true when the output is pinned to a revision.
+	ConditionTypePinned = "Pinned"
+
+	// ReasonNotPinned is used when the rendered ScrapeJobs are written.
+	ReasonNotPinned = "NotPinned"
+	// ReasonRevisionPinned is used when the pinned revision is written.
+	ReasonRevisionPinned = "RevisionPinned"
+	// ReasonPinnedRevisionNotFound is used when the pinned revision is not in
+	// the history. The output is left unchanged.
+	ReasonPinnedRevisionNotFound = "PinnedRevisionNotFound"
+	// ReasonInvalidPinnedRevision is used when the output stored in the pinned
+	// revision can't be parsed. The output is left unchanged.
+	ReasonInvalidPinnedRevision = "InvalidPinnedRevision"
+
+	// ConditionTypePaused is true when the output secret is not written,
+	// because the config is paused.
+	ConditionTypePaused = "Paused"
+
+	// ReasonNotPaused is used when the config is not paused.
+	ReasonNotPaused = "NotPaused"
+	// ReasonPausedBySpec is used when spec.paused is set.
+	ReasonPausedBySpec = "PausedBySpec"
+	// ReasonPausedByAnnotation is used when the PausedAnnotation is set.
+	ReasonPausedByAnnotation = "PausedByAnnotation"
+
+	// ConditionTypeOutputValid is true when the rendered scrape configs are
+	// accepted by the Prometheus config parser.
+	ConditionTypeOutputValid = "OutputValid"
+
+	// ReasonOutputValid is used when the rendered scrape configs are valid.
+	ReasonOutputValid = "Valid"
+	// ReasonInvalidScrapeConfig is used when the rendered scrape configs are
+	// rejected by the Prometheus config parser. The last valid output is kept.
+	ReasonInvalidScrapeConfig = "InvalidScrapeConfig"
+
+	// ConditionTypeReloaded is true when Prometheus was reloaded after the
+	// last output change.
+	ConditionTypeReloaded = "Reloaded"
+
+	// ReasonReloadSucceeded is used when the last reload succeeded.
+	ReasonReloadSucceeded = "ReloadSucceeded"
+	// ReasonReloadPending is used while the reload is waiting for the delay
+	// or a retry.
+	ReasonReloadPending = "ReloadPending"
+	// ReasonReloadFailed is used when every reload attempt failed, while the
+	// reload is retried on an interval.
+	ReasonReloadFailed = "ReloadFailed"
+
+	// ConditionTypeWorkloadsUpdated is true when the rollout workloads have
+	// the checksum of the current secret data.
+	ConditionTypeWorkloadsUpdated = "WorkloadsUpdated"
+
+	// ReasonWorkloadsUpdated is used when every workload is up to date.
+	ReasonWorkloadsUpdated = "WorkloadsUpdated"
+	// ReasonWorkloadNotFound is used when a rollout workload doesn't exist.
+	ReasonWorkloadNotFound = "WorkloadNotFound"
+
+	// ConditionTypePrometheusWired is true when the referenced Prometheus
+	// Operator object reads its additional scrape configs from the secret.
+	ConditionTypePrometheusWired = "PrometheusWired"
+
+	// ReasonPrometheusWired is used when the object reads the secret.
+	ReasonPrometheusWired = "PrometheusWired"
+	// ReasonPrometheusNotFound is used when the object, or its CRD, doesn't
+	// exist.
+	ReasonPrometheusNotFound = "PrometheusNotFound"
+	// ReasonAdditionalScrapeConfigsInUse is used when the object already reads
+	// its additional scrape configs from a different secret or key.
+	ReasonAdditionalScrapeConfigsInUse = "AdditionalScrapeConfigsInUse"
+
+	// ConditionTypeTargetHealthAvailable is true when the last poll of the
+	// target health succeeded.
+	ConditionTypeTargetHealthAvailable = "TargetHealthAvailable"
+
+	// ReasonTargetsPolled is used when the targets were polled.
+	ReasonTargetsPolled = "TargetsPolled"
+	// ReasonTargetsPollFailed is used when the targets API call failed.
+	ReasonTargetsPollFailed = "TargetsPollFailed"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 
@@ -46,6 +357,19 @@ type AdditionalScrapeConfig struct {
 	Status AdditionalScrapeConfigStatus `json:"status,omitempty"`
 }
 
+// GetPausedReason returns the reason the config is paused for, or an empty
+// string if it's not paused.
+func (r *AdditionalScrapeConfig) GetPausedReason() string {
+	if r.Spec.Paused {
+		return ReasonPausedBySpec
+	}
+	if r.Annotations[PausedAnnotation] == "true" {
+		return ReasonPausedByAnnotation
+	}
+
+	return ""
+}
+
 //+kubebuilder:object:root=true
 
 // AdditionalScrapeConfigList contains a list of AdditionalScrapeConfig
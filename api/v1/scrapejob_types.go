@@ -17,13 +17,39 @@ limitations under the License.
 package v1
 
 import (
+	"time"
+
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+//+kubebuilder:validation:XValidation:rule="!has(self.probe) || !has(self.staticConfigs) || size(self.staticConfigs) == 0",message="staticConfigs and probe are mutually exclusive"
+//+kubebuilder:validation:XValidation:rule="!has(self.multiTargetExporter) || ((!has(self.staticConfigs) || size(self.staticConfigs) == 0) && !has(self.probe))",message="multiTargetExporter is mutually exclusive with staticConfigs and probe"
+
 // ScrapeJobSpec defines the desired state of ScrapeJob
 type ScrapeJobSpec struct {
 	JobName       string                  `json:"jobName"`
-	StaticConfigs []ScrapeJobStaticConfig `json:"staticConfigs"`
+	StaticConfigs []ScrapeJobStaticConfig `json:"staticConfigs,omitempty"`
+	// Probes the targets through a blackbox exporter, instead of scraping the
+	// static configs.
+	Probe *ScrapeJobProbe `json:"probe,omitempty"`
+	// Scrapes the targets through a multi-target exporter, like the
+	// snmp_exporter, instead of scraping the static configs.
+	MultiTargetExporter *ScrapeJobMultiTargetExporter `json:"multiTargetExporter,omitempty"`
+	// The template the unset scrape settings are taken from.
+	TemplateRef *ScrapeJobTemplateReference `json:"templateRef,omitempty"`
+	// The settings of the rendered jobs, merged over the ones of the
+	// template.
+	ScrapeSettings `json:",inline"`
+	// Excludes the job from the rendered output of every config without
+	// deleting it.
+	Suspend bool `json:"suspend,omitempty"`
+	// The job is not rendered before this time.
+	ActiveFrom *metav1.Time `json:"activeFrom,omitempty"`
+	// The job is not rendered from this time on.
+	ActiveUntil *metav1.Time `json:"activeUntil,omitempty"`
+	// The job is not rendered once it's older than this. Combined with
+	// ActiveUntil, the earlier of the two expires the job.
+	TTL *metav1.Duration `json:"ttl,omitempty"`
 }
 
 type ScrapeJobStaticConfig struct {
@@ -31,6 +57,140 @@ type ScrapeJobStaticConfig struct {
 	Labels  map[string]string `json:"labels"`
 }
 
+// ScrapeJobProbe configures a blackbox exporter probe job. The rendered job
+// scrapes the prober with each target passed in the target parameter, and
+// keeps the target as the instance label.
+type ScrapeJobProbe struct {
+	// The host:port address of the blackbox exporter.
+	ProberAddress string `json:"proberAddress"`
+	// The blackbox exporter module to probe the targets with.
+	Module string `json:"module"`
+	// The probe path of the exporter. Defaults to DefaultProbePath.
+	Path string `json:"path,omitempty"`
+	// The probed targets, like URLs for the http prober or host:port for the
+	// tcp prober.
+	Targets []string `json:"targets"`
+	// Labels added to every probed target.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// DefaultProbePath is the probe path of the blackbox exporter.
+const DefaultProbePath = "/probe"
+
+// GetPath returns the probe path of the exporter.
+func (r *ScrapeJobProbe) GetPath() string {
+	if r.Path == "" {
+		return DefaultProbePath
+	}
+
+	return r.Path
+}
+
+// ScrapeJobMultiTargetExporter configures jobs scraping the targets through a
+// multi-target exporter. The rendered jobs scrape the exporter with each
+// target passed in the target parameter, and keep the target as the instance
+// label.
+type ScrapeJobMultiTargetExporter struct {
+	// The host:port address of the exporter.
+	Address string `json:"address"`
+	// The path the exporter serves the metrics of the targets on, like /snmp.
+	Path string `json:"path"`
+	// Parameters passed to the exporter, like auth for the snmp_exporter.
+	Params map[string][]string `json:"params,omitempty"`
+	// Each module is rendered into a separate job named <jobName>_<module>,
+	// with the module passed in the module parameter. A single job named
+	// jobName is rendered if empty.
+	Modules []string `json:"modules,omitempty"`
+	// The scraped targets.
+	Targets []string `json:"targets"`
+	// Labels added to every target.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// GetJobNames returns the names of the jobs the exporter is rendered into.
+func (r *ScrapeJobMultiTargetExporter) GetJobNames(jobName string) []string {
+	if len(r.Modules) == 0 {
+		return []string{jobName}
+	}
+
+	jobNames := make([]string, 0, len(r.Modules))
+	for _, module := range r.Modules {
+		jobNames = append(jobNames, jobName+"_"+module)
+	}
+
+	return jobNames
+}
+
+// ScrapeJobStatus defines the observed state of ScrapeJob
+type ScrapeJobStatus struct {
+	// The health of the targets of the job, as reported by the Prometheus
+	// servers of the configs with targetHealth set.
+	Targets []TargetHealth `json:"targets,omitempty"`
+	// The configs leaving the job out of their output, because it can't be
+	// rendered. Every config selecting the job keeps its own entry.
+	Exclusions []ScrapeJobExclusion `json:"exclusions,omitempty"`
+	// Conditions describing the state of the job.
+	//+listType=map
+	//+listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// TargetHealth is the health of a target, as reported by Prometheus.
+type TargetHealth struct {
+	// The AdditionalScrapeConfig whose Prometheus server reported the target,
+	// in namespace/name format.
+	Config    string `json:"config"`
+	ScrapeURL string `json:"scrapeUrl"`
+	// up, down or unknown.
+	Health     string       `json:"health"`
+	LastError  string       `json:"lastError,omitempty"`
+	LastScrape *metav1.Time `json:"lastScrape,omitempty"`
+}
+
+// ScrapeJobExclusion describes why a config left the job out of its output.
+type ScrapeJobExclusion struct {
+	// The AdditionalScrapeConfig excluding the job, in namespace/name format.
+	Config  string `json:"config"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+const (
+	// ConditionTypeSuspended is true when the job is excluded from the
+	// rendered output by spec.suspend.
+	ConditionTypeSuspended = "Suspended"
+
+	// ReasonSuspended is used when spec.suspend is set.
+	ReasonSuspended = "Suspended"
+	// ReasonNotSuspended is used when the job is rendered again after being
+	// suspended.
+	ReasonNotSuspended = "NotSuspended"
+
+	// ConditionTypeExcluded is true while any config leaves the job out of
+	// its output, because it can't be rendered. The configs are listed in
+	// status.exclusions. ScrapeJobs and their templates don't reference any
+	// credentials, so there is no reason for failed credential lookups.
+	ConditionTypeExcluded = "Excluded"
+
+	// ReasonRendered is used when no config excludes the job any more.
+	ReasonRendered = "Rendered"
+	// ReasonInvalidScrapeJob is used when the spec of the job is invalid.
+	ReasonInvalidScrapeJob = "InvalidScrapeJob"
+	// ReasonDuplicateJobName is used when an other ScrapeJob rendered by the
+	// same config has the same job name.
+	ReasonDuplicateJobName = "DuplicateJobName"
+	// ReasonRejectedByPrometheus is used when the rendered job is rejected by
+	// the Prometheus config parser.
+	ReasonRejectedByPrometheus = "RejectedByPrometheus"
+	// ReasonTemplateNotFound is used when the referenced template doesn't
+	// exist.
+	ReasonTemplateNotFound = "TemplateNotFound"
+	// ReasonClusterTemplateUnavailable is used when the job references a
+	// ClusterScrapeJobTemplate while the controller is restricted to
+	// namespaces, and can't read cluster scoped resources.
+	ReasonClusterTemplateUnavailable = "ClusterTemplateUnavailable"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 
@@ -39,7 +199,52 @@ type ScrapeJob struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec ScrapeJobSpec `json:"spec,omitempty"`
+	Spec   ScrapeJobSpec   `json:"spec,omitempty"`
+	Status ScrapeJobStatus `json:"status,omitempty"`
+}
+
+// GetJobNames returns the names of the Prometheus jobs the ScrapeJob is
+// rendered into.
+func (r *ScrapeJob) GetJobNames() []string {
+	if nil != r.Spec.MultiTargetExporter {
+		return r.Spec.MultiTargetExporter.GetJobNames(r.Spec.JobName)
+	}
+
+	return []string{r.Spec.JobName}
+}
+
+// GetExpiryTime returns the time the job expires at, and false if it never
+// expires.
+func (r *ScrapeJob) GetExpiryTime() (time.Time, bool) {
+	var expiry time.Time
+	if nil != r.Spec.ActiveUntil {
+		expiry = r.Spec.ActiveUntil.Time
+	}
+	if nil != r.Spec.TTL {
+		ttlExpiry := r.CreationTimestamp.Add(r.Spec.TTL.Duration)
+		if expiry.IsZero() || ttlExpiry.Before(expiry) {
+			expiry = ttlExpiry
+		}
+	}
+
+	return expiry, !expiry.IsZero()
+}
+
+// IsExpired returns true if the job is expired at the given time.
+func (r *ScrapeJob) IsExpired(now time.Time) bool {
+	expiry, ok := r.GetExpiryTime()
+
+	return ok && !now.Before(expiry)
+}
+
+// IsActive returns true if the job is within its active window at the given
+// time.
+func (r *ScrapeJob) IsActive(now time.Time) bool {
+	if nil != r.Spec.ActiveFrom && now.Before(r.Spec.ActiveFrom.Time) {
+		return false
+	}
+
+	return !r.IsExpired(now)
 }
 
 //+kubebuilder:object:root=true
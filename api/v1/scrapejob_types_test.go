@@ -0,0 +1,85 @@
+package v1
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Scrape job active window", func() {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(offset time.Duration) *metav1.Time {
+		t := metav1.NewTime(now.Add(offset))
+		return &t
+	}
+
+	Context("When no window is set", func() {
+		sut := ScrapeJob{}
+		It("Should be active", func() {
+			Expect(sut.IsActive(now)).Should(BeTrue())
+		})
+		It("Should not expire", func() {
+			_, ok := sut.GetExpiryTime()
+			Expect(ok).Should(BeFalse())
+		})
+	})
+
+	Context("When activeFrom is in the future", func() {
+		sut := ScrapeJob{Spec: ScrapeJobSpec{ActiveFrom: at(time.Hour)}}
+		It("Should not be active", func() {
+			Expect(sut.IsActive(now)).Should(BeFalse())
+		})
+		It("Should not be expired", func() {
+			Expect(sut.IsExpired(now)).Should(BeFalse())
+		})
+	})
+
+	Context("When activeUntil has passed", func() {
+		sut := ScrapeJob{Spec: ScrapeJobSpec{ActiveUntil: at(0)}}
+		It("Should be expired at the boundary", func() {
+			Expect(sut.IsExpired(now)).Should(BeTrue())
+			Expect(sut.IsActive(now)).Should(BeFalse())
+		})
+	})
+
+	Context("When both the ttl and activeUntil are set", func() {
+		sut := ScrapeJob{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: *at(-time.Hour)},
+			Spec: ScrapeJobSpec{
+				ActiveUntil: at(time.Hour),
+				TTL:         &metav1.Duration{Duration: 90 * time.Minute},
+			},
+		}
+		It("Should expire at the earlier of the two", func() {
+			expiry, ok := sut.GetExpiryTime()
+			Expect(ok).Should(BeTrue())
+			Expect(expiry).Should(Equal(now.Add(30 * time.Minute)))
+			Expect(sut.IsActive(now)).Should(BeTrue())
+		})
+	})
+})
+
+var _ = Describe("ScrapeJob job names", func() {
+	Context("When the job has static configs", func() {
+		sut := ScrapeJob{Spec: ScrapeJobSpec{JobName: "static"}}
+		It("Should render into a single job", func() {
+			Expect(sut.GetJobNames()).Should(Equal([]string{"static"}))
+		})
+	})
+
+	Context("When the multi-target exporter has no modules", func() {
+		sut := ScrapeJob{Spec: ScrapeJobSpec{JobName: "json", MultiTargetExporter: &ScrapeJobMultiTargetExporter{}}}
+		It("Should render into a single job", func() {
+			Expect(sut.GetJobNames()).Should(Equal([]string{"json"}))
+		})
+	})
+
+	Context("When the multi-target exporter has modules", func() {
+		sut := ScrapeJob{Spec: ScrapeJobSpec{JobName: "snmp", MultiTargetExporter: &ScrapeJobMultiTargetExporter{Modules: []string{"if_mib", "ip_mib"}}}}
+		It("Should render into a job per module", func() {
+			Expect(sut.GetJobNames()).Should(Equal([]string{"snmp_if_mib", "snmp_ip_mib"}))
+		})
+	})
+})
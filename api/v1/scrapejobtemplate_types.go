@@ -0,0 +1,99 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ScrapeJobTemplateSpec defines the settings shared by the ScrapeJobs
+// referencing the template
+type ScrapeJobTemplateSpec struct {
+	ScrapeSettings `json:",inline"`
+}
+
+//+kubebuilder:object:root=true
+
+// ScrapeJobTemplate is the Schema for the scrapejobtemplates API. It can be
+// referenced by the ScrapeJobs in its namespace.
+type ScrapeJobTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ScrapeJobTemplateSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ScrapeJobTemplateList contains a list of ScrapeJobTemplate
+type ScrapeJobTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ScrapeJobTemplate `json:"items"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// ClusterScrapeJobTemplate is the Schema for the clusterscrapejobtemplates
+// API. It can be referenced by the ScrapeJobs in any namespace.
+type ClusterScrapeJobTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ScrapeJobTemplateSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterScrapeJobTemplateList contains a list of ClusterScrapeJobTemplate
+type ClusterScrapeJobTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterScrapeJobTemplate `json:"items"`
+}
+
+const (
+	// ScrapeJobTemplateKind is the kind of the namespaced templates.
+	ScrapeJobTemplateKind = "ScrapeJobTemplate"
+	// ClusterScrapeJobTemplateKind is the kind of the cluster scoped
+	// templates.
+	ClusterScrapeJobTemplateKind = "ClusterScrapeJobTemplate"
+)
+
+// ScrapeJobTemplateReference references the template of a ScrapeJob.
+type ScrapeJobTemplateReference struct {
+	// Defaults to ScrapeJobTemplate, which is looked up in the namespace of
+	// the ScrapeJob. ClusterScrapeJobTemplates can't be used if the controller
+	// is restricted to namespaces.
+	//+kubebuilder:validation:Enum=ScrapeJobTemplate;ClusterScrapeJobTemplate
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name"`
+}
+
+// GetKind returns the kind of the referenced template.
+func (r *ScrapeJobTemplateReference) GetKind() string {
+	if r.Kind == "" {
+		return ScrapeJobTemplateKind
+	}
+
+	return r.Kind
+}
+
+func init() {
+	SchemeBuilder.Register(&ScrapeJobTemplate{}, &ScrapeJobTemplateList{}, &ClusterScrapeJobTemplate{}, &ClusterScrapeJobTemplateList{})
+}
@@ -1,6 +1,13 @@
 package v1
 
-import "github.com/szeber/kube-stager-prometheus-static-target/internal/helper"
+import (
+	"fmt"
+	"strings"
+
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/helper"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
 
 type NamespaceSelector struct {
 	// Boolean describing whether all namespaces are selected in contrast to a
@@ -23,3 +30,252 @@ func (r *NamespaceSelector) Matches(namespace string, ownNamespace string) bool
 
 	return res
 }
+
+// OutputLocation identifies the secret key an AdditionalScrapeConfig renders
+// its scrape configs into.
+type OutputLocation struct {
+	SecretName      string `json:"secretName"`
+	SecretNamespace string `json:"secretNamespace"`
+	SecretKey       string `json:"secretKey"`
+	// Whether the secret was created by the operator, rather than being an
+	// already existing secret that the key was added to.
+	SecretCreated bool `json:"secretCreated,omitempty"`
+}
+
+// SameSecret returns true if both locations point at the same secret,
+// regardless of the key.
+func (r *OutputLocation) SameSecret(other *OutputLocation) bool {
+	return r.SecretNamespace == other.SecretNamespace && r.SecretName == other.SecretName
+}
+
+// SameLocation returns true if both locations point at the same secret key.
+func (r *OutputLocation) SameLocation(other *OutputLocation) bool {
+	return r.SameSecret(other) && r.SecretKey == other.SecretKey
+}
+
+// ConfigChange describes a change written to the rendered scrape configs.
+type ConfigChange struct {
+	// When the change was written.
+	Time metav1.Time `json:"time"`
+	// One line summary of the change, like "1 job added, 2 jobs changed".
+	Summary string `json:"summary"`
+	// The jobs and targets added or removed, and the labels changed. Only the
+	// first MaxConfigChangeDetails entries are listed.
+	//+kubebuilder:validation:MaxItems=20
+	Details []string `json:"details,omitempty"`
+	// The number of entries left out of Details because of the limit.
+	OmittedDetails int `json:"omittedDetails,omitempty"`
+}
+
+// MaxConfigChangeDetails is the maximum number of entries in
+// ConfigChange.Details.
+const MaxConfigChangeDetails = 20
+
+// NewConfigChange returns a change with the given details, truncated to
+// MaxConfigChangeDetails entries.
+func NewConfigChange(changeTime metav1.Time, summary string, details []string) *ConfigChange {
+	change := &ConfigChange{
+		Time:    changeTime,
+		Summary: summary,
+		Details: details,
+	}
+	if len(details) > MaxConfigChangeDetails {
+		change.Details = details[:MaxConfigChangeDetails]
+		change.OmittedDetails = len(details) - MaxConfigChangeDetails
+	}
+
+	return change
+}
+
+// Revision is a rendered output kept in the revision history.
+type Revision struct {
+	// The revision number, increased with every new rendered output.
+	Revision int64 `json:"revision"`
+	// Hash of the rendered output.
+	Hash string `json:"hash"`
+	// Name of the ConfigMap holding the rendered output.
+	ConfigMapName string `json:"configMapName"`
+	// When the revision was recorded.
+	CreationTime metav1.Time `json:"creationTime"`
+}
+
+// ExcludedScrapeJob is a selected ScrapeJob left out of the rendered output,
+// because it can't be rendered.
+type ExcludedScrapeJob struct {
+	// The namespace/name of the ScrapeJob.
+	Name string `json:"name"`
+	// Why the job is excluded, one of the exclusion reasons.
+	Reason string `json:"reason"`
+	// Details of the problem.
+	Message string `json:"message"`
+}
+
+// PrometheusEndpoint locates the HTTP API of a Prometheus server. Exactly one
+// of URL and Service has to be set.
+type PrometheusEndpoint struct {
+	// The base URL of the server, like http://prometheus.monitoring:9090.
+	URL string `json:"url,omitempty"`
+	// The Service in front of the server.
+	Service *ServiceReference `json:"service,omitempty"`
+	// Basic auth credentials sent with the requests.
+	BasicAuth *BasicAuth `json:"basicAuth,omitempty"`
+	// Secret key holding a bearer token sent with the requests. The secret has
+	// to be in the namespace of the config.
+	BearerTokenSecret *corev1.SecretKeySelector `json:"bearerTokenSecret,omitempty"`
+}
+
+// GetURL returns the URL of the path on the server. The namespace of the
+// Service defaults to the given one.
+func (r *PrometheusEndpoint) GetURL(defaultNamespace string, path string) string {
+	if nil == r.Service {
+		return strings.TrimSuffix(r.URL, "/") + path
+	}
+
+	namespace := r.Service.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	scheme := r.Service.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	port := r.Service.Port
+	if port == 0 {
+		port = DefaultPrometheusPort
+	}
+
+	return fmt.Sprintf("%s://%s.%s.svc:%d%s", scheme, r.Service.Name, namespace, port, path)
+}
+
+// DefaultPrometheusPort is the port of a ServiceReference if not set.
+const DefaultPrometheusPort = 9090
+
+// ServiceReference identifies a port of a Service.
+type ServiceReference struct {
+	Name string `json:"name"`
+	// Defaults to the namespace of the config.
+	Namespace string `json:"namespace,omitempty"`
+	// Defaults to DefaultPrometheusPort.
+	Port int32 `json:"port,omitempty"`
+	// Defaults to http.
+	//+kubebuilder:validation:Enum=http;https
+	Scheme string `json:"scheme,omitempty"`
+}
+
+// BasicAuth references the secret keys holding basic auth credentials. The
+// secrets have to be in the namespace of the config.
+type BasicAuth struct {
+	Username corev1.SecretKeySelector `json:"username"`
+	Password corev1.SecretKeySelector `json:"password"`
+}
+
+// GetSecretNames returns the names of the secrets holding the credentials.
+func (r *PrometheusEndpoint) GetSecretNames() []string {
+	var names []string
+	if nil != r.BasicAuth {
+		names = append(names, r.BasicAuth.Username.Name, r.BasicAuth.Password.Name)
+	}
+	if nil != r.BearerTokenSecret {
+		names = append(names, r.BearerTokenSecret.Name)
+	}
+
+	return names
+}
+
+// ScrapeSettings are the settings of the rendered Prometheus jobs, shared by
+// the ScrapeJobs and the templates they reference.
+type ScrapeSettings struct {
+	// How often the targets are scraped, like 30s. Defaults to the global
+	// scrape interval of Prometheus.
+	ScrapeInterval string `json:"scrapeInterval,omitempty"`
+	// The timeout of the scrapes, like 10s. Defaults to the global scrape
+	// timeout of Prometheus.
+	ScrapeTimeout string `json:"scrapeTimeout,omitempty"`
+	//+kubebuilder:validation:Enum=http;https
+	Scheme string `json:"scheme,omitempty"`
+	// The path the metrics are scraped from. Not used by probe and
+	// multiTargetExporter jobs, they scrape the path of the exporter.
+	MetricsPath   string               `json:"metricsPath,omitempty"`
+	TLSConfig     *ScrapeTLSConfig     `json:"tlsConfig,omitempty"`
+	BasicAuth     *ScrapeBasicAuth     `json:"basicAuth,omitempty"`
+	Authorization *ScrapeAuthorization `json:"authorization,omitempty"`
+	// Relabeling applied to the targets before the scrape.
+	RelabelConfigs []RelabelConfig `json:"relabelConfigs,omitempty"`
+	// Relabeling applied to the scraped samples.
+	MetricRelabelConfigs []RelabelConfig `json:"metricRelabelConfigs,omitempty"`
+}
+
+// ScrapeTLSConfig configures the TLS connection to the targets. The files are
+// read by Prometheus, so they have to be mounted into its pods.
+type ScrapeTLSConfig struct {
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// ScrapeBasicAuth configures basic auth for the scrapes. The password file is
+// read by Prometheus, so it has to be mounted into its pods.
+type ScrapeBasicAuth struct {
+	Username     string `json:"username"`
+	PasswordFile string `json:"passwordFile"`
+}
+
+// ScrapeAuthorization configures the authorization header of the scrapes. The
+// credentials file is read by Prometheus, so it has to be mounted into its
+// pods.
+type ScrapeAuthorization struct {
+	// Defaults to Bearer.
+	Type            string `json:"type,omitempty"`
+	CredentialsFile string `json:"credentialsFile"`
+}
+
+// RelabelConfig is a Prometheus relabeling rule.
+type RelabelConfig struct {
+	SourceLabels []string `json:"sourceLabels,omitempty"`
+	Separator    string   `json:"separator,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	Modulus      int64    `json:"modulus,omitempty"`
+	TargetLabel  string   `json:"targetLabel,omitempty"`
+	Replacement  string   `json:"replacement,omitempty"`
+	//+kubebuilder:validation:Enum=replace;keep;drop;keepequal;dropequal;hashmod;labelmap;labeldrop;labelkeep;lowercase;uppercase
+	Action string `json:"action,omitempty"`
+}
+
+// Merge returns the settings with the unset fields taken from the template.
+// Structured settings, like the TLS config, are taken as a whole. The relabel
+// configs are concatenated, the rules of the template run first.
+func (r *ScrapeSettings) Merge(template *ScrapeSettings) ScrapeSettings {
+	merged := *r.DeepCopy()
+	if nil == template {
+		return merged
+	}
+	template = template.DeepCopy()
+
+	if merged.ScrapeInterval == "" {
+		merged.ScrapeInterval = template.ScrapeInterval
+	}
+	if merged.ScrapeTimeout == "" {
+		merged.ScrapeTimeout = template.ScrapeTimeout
+	}
+	if merged.Scheme == "" {
+		merged.Scheme = template.Scheme
+	}
+	if merged.MetricsPath == "" {
+		merged.MetricsPath = template.MetricsPath
+	}
+	if nil == merged.TLSConfig {
+		merged.TLSConfig = template.TLSConfig
+	}
+	// Basic auth and the authorization header are mutually exclusive, the job
+	// setting either replaces both of the template
+	if nil == merged.BasicAuth && nil == merged.Authorization {
+		merged.BasicAuth = template.BasicAuth
+		merged.Authorization = template.Authorization
+	}
+	merged.RelabelConfigs = append(template.RelabelConfigs, merged.RelabelConfigs...)
+	merged.MetricRelabelConfigs = append(template.MetricRelabelConfigs, merged.MetricRelabelConfigs...)
+
+	return merged
+}
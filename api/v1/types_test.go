@@ -3,6 +3,7 @@ package v1
 import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var _ = Describe("Namespace selector", func() {
@@ -60,3 +61,69 @@ var _ = Describe("Namespace selector", func() {
 		})
 	})
 })
+
+var _ = Describe("Config change", func() {
+	It("Should keep all the details under the limit", func() {
+		change := NewConfigChange(metav1.Now(), "summary", []string{"a", "b"})
+		Expect(change.Details).Should(Equal([]string{"a", "b"}))
+		Expect(change.OmittedDetails).Should(BeZero())
+	})
+	It("Should truncate the details over the limit", func() {
+		details := make([]string, MaxConfigChangeDetails+5)
+		change := NewConfigChange(metav1.Now(), "summary", details)
+		Expect(change.Details).Should(HaveLen(MaxConfigChangeDetails))
+		Expect(change.OmittedDetails).Should(Equal(5))
+	})
+})
+
+var _ = Describe("Prometheus endpoint", func() {
+	Context("When using a URL", func() {
+		sut := PrometheusEndpoint{URL: "http://prometheus:9090/"}
+		It("Should append the path", func() {
+			Expect(sut.GetURL("default", "/-/reload")).Should(Equal("http://prometheus:9090/-/reload"))
+		})
+	})
+
+	Context("When using a Service reference", func() {
+		It("Should default the namespace, port and scheme", func() {
+			sut := PrometheusEndpoint{Service: &ServiceReference{Name: "prometheus"}}
+			Expect(sut.GetURL("monitoring", "/-/reload")).Should(Equal("http://prometheus.monitoring.svc:9090/-/reload"))
+		})
+		It("Should use the set namespace, port and scheme", func() {
+			sut := PrometheusEndpoint{Service: &ServiceReference{Name: "prometheus", Namespace: "other", Port: 443, Scheme: "https"}}
+			Expect(sut.GetURL("monitoring", "/-/reload")).Should(Equal("https://prometheus.other.svc:443/-/reload"))
+		})
+	})
+})
+
+var _ = Describe("Scrape settings", func() {
+	template := &ScrapeSettings{
+		ScrapeInterval: "5m",
+		ScrapeTimeout:  "1m",
+		BasicAuth:      &ScrapeBasicAuth{Username: "prometheus", PasswordFile: "/etc/prometheus/password"},
+		RelabelConfigs: []RelabelConfig{{TargetLabel: "team", Replacement: "platform"}},
+	}
+
+	It("Should prefer the settings of the job", func() {
+		sut := ScrapeSettings{ScrapeInterval: "10s"}
+		merged := sut.Merge(template)
+		Expect(merged.ScrapeInterval).Should(Equal("10s"))
+		Expect(merged.ScrapeTimeout).Should(Equal("1m"))
+		Expect(merged.BasicAuth).Should(Equal(template.BasicAuth))
+	})
+	It("Should not combine the authentication methods", func() {
+		sut := ScrapeSettings{Authorization: &ScrapeAuthorization{CredentialsFile: "/etc/prometheus/token"}}
+		merged := sut.Merge(template)
+		Expect(merged.BasicAuth).Should(BeNil())
+		Expect(merged.Authorization).Should(Equal(sut.Authorization))
+	})
+	It("Should apply the relabel rules of the template first", func() {
+		sut := ScrapeSettings{RelabelConfigs: []RelabelConfig{{TargetLabel: "env", Replacement: "prod"}}}
+		merged := sut.Merge(template)
+		Expect(merged.RelabelConfigs).Should(Equal([]RelabelConfig{template.RelabelConfigs[0], sut.RelabelConfigs[0]}))
+	})
+	It("Should keep the settings without a template", func() {
+		sut := ScrapeSettings{ScrapeInterval: "10s"}
+		Expect(sut.Merge(nil)).Should(Equal(sut))
+	})
+})
@@ -21,6 +21,8 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -94,6 +96,41 @@ func (in *AdditionalScrapeConfigSpec) DeepCopyInto(out *AdditionalScrapeConfigSp
 		}
 	}
 	in.ScrapeJobNamespaceSelector.DeepCopyInto(&out.ScrapeJobNamespaceSelector)
+	if in.DebounceWindow != nil {
+		in, out := &in.DebounceWindow, &out.DebounceWindow
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RevisionHistoryLimit != nil {
+		in, out := &in.RevisionHistoryLimit, &out.RevisionHistoryLimit
+		*out = new(int32)
+		**out = **in
+	}
+	if in.PinnedRevision != nil {
+		in, out := &in.PinnedRevision, &out.PinnedRevision
+		*out = new(int64)
+		**out = **in
+	}
+	if in.Reload != nil {
+		in, out := &in.Reload, &out.Reload
+		*out = new(ReloadSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RolloutWorkloads != nil {
+		in, out := &in.RolloutWorkloads, &out.RolloutWorkloads
+		*out = make([]WorkloadReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.PrometheusRef != nil {
+		in, out := &in.PrometheusRef, &out.PrometheusRef
+		*out = new(PrometheusReference)
+		**out = **in
+	}
+	if in.TargetHealth != nil {
+		in, out := &in.TargetHealth, &out.TargetHealth
+		*out = new(TargetHealthSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdditionalScrapeConfigSpec.
@@ -114,6 +151,55 @@ func (in *AdditionalScrapeConfigStatus) DeepCopyInto(out *AdditionalScrapeConfig
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.SuspendedScrapeJobs != nil {
+		in, out := &in.SuspendedScrapeJobs, &out.SuspendedScrapeJobs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.InactiveScrapeJobs != nil {
+		in, out := &in.InactiveScrapeJobs, &out.InactiveScrapeJobs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExcludedScrapeJobs != nil {
+		in, out := &in.ExcludedScrapeJobs, &out.ExcludedScrapeJobs
+		*out = make([]ExcludedScrapeJob, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastOutput != nil {
+		in, out := &in.LastOutput, &out.LastOutput
+		*out = new(OutputLocation)
+		**out = **in
+	}
+	if in.LastChange != nil {
+		in, out := &in.LastChange, &out.LastChange
+		*out = new(ConfigChange)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Revisions != nil {
+		in, out := &in.Revisions, &out.Revisions
+		*out = make([]Revision, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Reload != nil {
+		in, out := &in.Reload, &out.Reload
+		*out = new(ReloadStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WiredPrometheus != nil {
+		in, out := &in.WiredPrometheus, &out.WiredPrometheus
+		*out = new(WiredPrometheus)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AdditionalScrapeConfigStatus.
@@ -126,6 +212,117 @@ func (in *AdditionalScrapeConfigStatus) DeepCopy() *AdditionalScrapeConfigStatus
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BasicAuth) DeepCopyInto(out *BasicAuth) {
+	*out = *in
+	in.Username.DeepCopyInto(&out.Username)
+	in.Password.DeepCopyInto(&out.Password)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BasicAuth.
+func (in *BasicAuth) DeepCopy() *BasicAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(BasicAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterScrapeJobTemplate) DeepCopyInto(out *ClusterScrapeJobTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterScrapeJobTemplate.
+func (in *ClusterScrapeJobTemplate) DeepCopy() *ClusterScrapeJobTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterScrapeJobTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterScrapeJobTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterScrapeJobTemplateList) DeepCopyInto(out *ClusterScrapeJobTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterScrapeJobTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterScrapeJobTemplateList.
+func (in *ClusterScrapeJobTemplateList) DeepCopy() *ClusterScrapeJobTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterScrapeJobTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterScrapeJobTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigChange) DeepCopyInto(out *ConfigChange) {
+	*out = *in
+	in.Time.DeepCopyInto(&out.Time)
+	if in.Details != nil {
+		in, out := &in.Details, &out.Details
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigChange.
+func (in *ConfigChange) DeepCopy() *ConfigChange {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigChange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExcludedScrapeJob) DeepCopyInto(out *ExcludedScrapeJob) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExcludedScrapeJob.
+func (in *ExcludedScrapeJob) DeepCopy() *ExcludedScrapeJob {
+	if in == nil {
+		return nil
+	}
+	out := new(ExcludedScrapeJob)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NamespaceSelector) DeepCopyInto(out *NamespaceSelector) {
 	*out = *in
@@ -146,12 +343,192 @@ func (in *NamespaceSelector) DeepCopy() *NamespaceSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OutputLocation) DeepCopyInto(out *OutputLocation) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OutputLocation.
+func (in *OutputLocation) DeepCopy() *OutputLocation {
+	if in == nil {
+		return nil
+	}
+	out := new(OutputLocation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusEndpoint) DeepCopyInto(out *PrometheusEndpoint) {
+	*out = *in
+	if in.Service != nil {
+		in, out := &in.Service, &out.Service
+		*out = new(ServiceReference)
+		**out = **in
+	}
+	if in.BasicAuth != nil {
+		in, out := &in.BasicAuth, &out.BasicAuth
+		*out = new(BasicAuth)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BearerTokenSecret != nil {
+		in, out := &in.BearerTokenSecret, &out.BearerTokenSecret
+		*out = new(corev1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusEndpoint.
+func (in *PrometheusEndpoint) DeepCopy() *PrometheusEndpoint {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusEndpoint)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusReference) DeepCopyInto(out *PrometheusReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusReference.
+func (in *PrometheusReference) DeepCopy() *PrometheusReference {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RelabelConfig) DeepCopyInto(out *RelabelConfig) {
+	*out = *in
+	if in.SourceLabels != nil {
+		in, out := &in.SourceLabels, &out.SourceLabels
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RelabelConfig.
+func (in *RelabelConfig) DeepCopy() *RelabelConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(RelabelConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReloadSpec) DeepCopyInto(out *ReloadSpec) {
+	*out = *in
+	in.PrometheusEndpoint.DeepCopyInto(&out.PrometheusEndpoint)
+	if in.Delay != nil {
+		in, out := &in.Delay, &out.Delay
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.MaxAttempts != nil {
+		in, out := &in.MaxAttempts, &out.MaxAttempts
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReloadSpec.
+func (in *ReloadSpec) DeepCopy() *ReloadSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ReloadSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ReloadStatus) DeepCopyInto(out *ReloadStatus) {
+	*out = *in
+	if in.ChangeTime != nil {
+		in, out := &in.ChangeTime, &out.ChangeTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastAttemptTime != nil {
+		in, out := &in.LastAttemptTime, &out.LastAttemptTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessTime != nil {
+		in, out := &in.LastSuccessTime, &out.LastSuccessTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ReloadStatus.
+func (in *ReloadStatus) DeepCopy() *ReloadStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ReloadStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Revision) DeepCopyInto(out *Revision) {
+	*out = *in
+	in.CreationTime.DeepCopyInto(&out.CreationTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Revision.
+func (in *Revision) DeepCopy() *Revision {
+	if in == nil {
+		return nil
+	}
+	out := new(Revision)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeAuthorization) DeepCopyInto(out *ScrapeAuthorization) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeAuthorization.
+func (in *ScrapeAuthorization) DeepCopy() *ScrapeAuthorization {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeAuthorization)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeBasicAuth) DeepCopyInto(out *ScrapeBasicAuth) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeBasicAuth.
+func (in *ScrapeBasicAuth) DeepCopy() *ScrapeBasicAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeBasicAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ScrapeJob) DeepCopyInto(out *ScrapeJob) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJob.
@@ -172,6 +549,21 @@ func (in *ScrapeJob) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeJobExclusion) DeepCopyInto(out *ScrapeJobExclusion) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJobExclusion.
+func (in *ScrapeJobExclusion) DeepCopy() *ScrapeJobExclusion {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeJobExclusion)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ScrapeJobList) DeepCopyInto(out *ScrapeJobList) {
 	*out = *in
@@ -204,6 +596,81 @@ func (in *ScrapeJobList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeJobMultiTargetExporter) DeepCopyInto(out *ScrapeJobMultiTargetExporter) {
+	*out = *in
+	if in.Params != nil {
+		in, out := &in.Params, &out.Params
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				inVal := (*in)[key]
+				in, out := &inVal, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.Modules != nil {
+		in, out := &in.Modules, &out.Modules
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJobMultiTargetExporter.
+func (in *ScrapeJobMultiTargetExporter) DeepCopy() *ScrapeJobMultiTargetExporter {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeJobMultiTargetExporter)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeJobProbe) DeepCopyInto(out *ScrapeJobProbe) {
+	*out = *in
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJobProbe.
+func (in *ScrapeJobProbe) DeepCopy() *ScrapeJobProbe {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeJobProbe)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ScrapeJobSpec) DeepCopyInto(out *ScrapeJobSpec) {
 	*out = *in
@@ -214,6 +681,35 @@ func (in *ScrapeJobSpec) DeepCopyInto(out *ScrapeJobSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Probe != nil {
+		in, out := &in.Probe, &out.Probe
+		*out = new(ScrapeJobProbe)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.MultiTargetExporter != nil {
+		in, out := &in.MultiTargetExporter, &out.MultiTargetExporter
+		*out = new(ScrapeJobMultiTargetExporter)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TemplateRef != nil {
+		in, out := &in.TemplateRef, &out.TemplateRef
+		*out = new(ScrapeJobTemplateReference)
+		**out = **in
+	}
+	in.ScrapeSettings.DeepCopyInto(&out.ScrapeSettings)
+	if in.ActiveFrom != nil {
+		in, out := &in.ActiveFrom, &out.ActiveFrom
+		*out = (*in).DeepCopy()
+	}
+	if in.ActiveUntil != nil {
+		in, out := &in.ActiveUntil, &out.ActiveUntil
+		*out = (*in).DeepCopy()
+	}
+	if in.TTL != nil {
+		in, out := &in.TTL, &out.TTL
+		*out = new(metav1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJobSpec.
@@ -252,3 +748,270 @@ func (in *ScrapeJobStaticConfig) DeepCopy() *ScrapeJobStaticConfig {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeJobStatus) DeepCopyInto(out *ScrapeJobStatus) {
+	*out = *in
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]TargetHealth, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Exclusions != nil {
+		in, out := &in.Exclusions, &out.Exclusions
+		*out = make([]ScrapeJobExclusion, len(*in))
+		copy(*out, *in)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJobStatus.
+func (in *ScrapeJobStatus) DeepCopy() *ScrapeJobStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeJobStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeJobTemplate) DeepCopyInto(out *ScrapeJobTemplate) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJobTemplate.
+func (in *ScrapeJobTemplate) DeepCopy() *ScrapeJobTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeJobTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScrapeJobTemplate) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeJobTemplateList) DeepCopyInto(out *ScrapeJobTemplateList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ScrapeJobTemplate, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJobTemplateList.
+func (in *ScrapeJobTemplateList) DeepCopy() *ScrapeJobTemplateList {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeJobTemplateList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ScrapeJobTemplateList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeJobTemplateReference) DeepCopyInto(out *ScrapeJobTemplateReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJobTemplateReference.
+func (in *ScrapeJobTemplateReference) DeepCopy() *ScrapeJobTemplateReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeJobTemplateReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeJobTemplateSpec) DeepCopyInto(out *ScrapeJobTemplateSpec) {
+	*out = *in
+	in.ScrapeSettings.DeepCopyInto(&out.ScrapeSettings)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeJobTemplateSpec.
+func (in *ScrapeJobTemplateSpec) DeepCopy() *ScrapeJobTemplateSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeJobTemplateSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeSettings) DeepCopyInto(out *ScrapeSettings) {
+	*out = *in
+	if in.TLSConfig != nil {
+		in, out := &in.TLSConfig, &out.TLSConfig
+		*out = new(ScrapeTLSConfig)
+		**out = **in
+	}
+	if in.BasicAuth != nil {
+		in, out := &in.BasicAuth, &out.BasicAuth
+		*out = new(ScrapeBasicAuth)
+		**out = **in
+	}
+	if in.Authorization != nil {
+		in, out := &in.Authorization, &out.Authorization
+		*out = new(ScrapeAuthorization)
+		**out = **in
+	}
+	if in.RelabelConfigs != nil {
+		in, out := &in.RelabelConfigs, &out.RelabelConfigs
+		*out = make([]RelabelConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.MetricRelabelConfigs != nil {
+		in, out := &in.MetricRelabelConfigs, &out.MetricRelabelConfigs
+		*out = make([]RelabelConfig, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeSettings.
+func (in *ScrapeSettings) DeepCopy() *ScrapeSettings {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeSettings)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ScrapeTLSConfig) DeepCopyInto(out *ScrapeTLSConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ScrapeTLSConfig.
+func (in *ScrapeTLSConfig) DeepCopy() *ScrapeTLSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ScrapeTLSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceReference) DeepCopyInto(out *ServiceReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceReference.
+func (in *ServiceReference) DeepCopy() *ServiceReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetHealth) DeepCopyInto(out *TargetHealth) {
+	*out = *in
+	if in.LastScrape != nil {
+		in, out := &in.LastScrape, &out.LastScrape
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetHealth.
+func (in *TargetHealth) DeepCopy() *TargetHealth {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetHealth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetHealthSpec) DeepCopyInto(out *TargetHealthSpec) {
+	*out = *in
+	in.PrometheusEndpoint.DeepCopyInto(&out.PrometheusEndpoint)
+	if in.Interval != nil {
+		in, out := &in.Interval, &out.Interval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetHealthSpec.
+func (in *TargetHealthSpec) DeepCopy() *TargetHealthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetHealthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WiredPrometheus) DeepCopyInto(out *WiredPrometheus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WiredPrometheus.
+func (in *WiredPrometheus) DeepCopy() *WiredPrometheus {
+	if in == nil {
+		return nil
+	}
+	out := new(WiredPrometheus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WorkloadReference) DeepCopyInto(out *WorkloadReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WorkloadReference.
+func (in *WorkloadReference) DeepCopy() *WorkloadReference {
+	if in == nil {
+		return nil
+	}
+	out := new(WorkloadReference)
+	in.DeepCopyInto(out)
+	return out
+}
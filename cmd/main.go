@@ -19,6 +19,7 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,12 +29,14 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
 	"github.com/szeber/kube-stager-prometheus-static-target/internal/controller"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/helper"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -53,11 +56,34 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var watchNamespaces string
+	var maxConcurrentReconciles int
+	var rateLimiterBaseDelay time.Duration
+	var rateLimiterMaxDelay time.Duration
+	var rateLimiterQPS float64
+	var rateLimiterBurst int
+	var deleteExpiredScrapeJobs bool
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma separated list of namespaces to watch. All namespaces are watched if empty. "+
+			"The output secrets have to be in one of the watched namespaces too. "+
+			"ScrapeJobs referencing a ClusterScrapeJobTemplate are excluded if set.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of AdditionalScrapeConfigs reconciled in parallel.")
+	flag.DurationVar(&rateLimiterBaseDelay, "rate-limiter-base-delay", 5*time.Millisecond,
+		"The initial requeue delay of a failing AdditionalScrapeConfig, doubled on each failure.")
+	flag.DurationVar(&rateLimiterMaxDelay, "rate-limiter-max-delay", 1000*time.Second,
+		"The maximum requeue delay of a failing AdditionalScrapeConfig.")
+	flag.Float64Var(&rateLimiterQPS, "rate-limiter-qps", 10,
+		"The overall number of requeues allowed per second.")
+	flag.IntVar(&rateLimiterBurst, "rate-limiter-burst", 100,
+		"The number of requeues allowed in a burst above the rate-limiter-qps.")
+	flag.BoolVar(&deleteExpiredScrapeJobs, "delete-expired-scrape-jobs", false,
+		"Delete the ScrapeJobs past their activeUntil or ttl instead of only leaving them out of the output.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -66,8 +92,19 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	namespaces := helper.SplitAndTrim(watchNamespaces, ",")
+	cacheOptions := controller.CacheOptions()
+	if len(namespaces) > 0 {
+		setupLog.Info("restricting the manager to namespaces", "namespaces", namespaces)
+		cacheOptions.DefaultNamespaces = make(map[string]cache.Config)
+		for _, namespace := range namespaces {
+			cacheOptions.DefaultNamespaces[namespace] = cache.Config{}
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
+		Cache:                  cacheOptions,
 		Metrics:                server.Options{BindAddress: metricsAddr},
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
@@ -90,8 +127,12 @@ func main() {
 	}
 
 	if err = (&controller.AdditionalScrapeConfigReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		WatchNamespaces:         namespaces,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		RateLimiter:             controller.NewRateLimiter(rateLimiterBaseDelay, rateLimiterMaxDelay, rateLimiterQPS, rateLimiterBurst),
+		DeleteExpiredScrapeJobs: deleteExpiredScrapeJobs,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "AdditionalScrapeConfig")
 		os.Exit(1)
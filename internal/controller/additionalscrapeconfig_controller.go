@@ -18,20 +18,32 @@ package controller
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/go-logr/logr"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/helper"
 	"github.com/szeber/kube-stager-prometheus-static-target/internal/kubernetes"
 	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	"golang.org/x/time/rate"
 	"gopkg.in/yaml.v2"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/client-go/util/workqueue"
 	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	crcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sort"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -41,24 +53,72 @@ import (
 	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
 )
 
-const metricsFinalizerName = "prometheus-static-target.kube-stager.io/metrics-cleanup"
+const (
+	// metricsFinalizerName holds back the deletion of a config until it is
+	// unwired from the Prometheus object, its exclusions are removed from the
+	// ScrapeJobs and its metrics are deleted.
+	metricsFinalizerName = "prometheus-static-target.kube-stager.io/metrics-cleanup"
+
+	// unmanagedSecretRequeueInterval is the interval the config is rechecked at
+	// while its output secret exists but is not managed by the operator.
+	unmanagedSecretRequeueInterval = time.Minute
+)
 
 type AdditionalScrapeConfigReconciler struct {
 	client.Client
 	Scheme     *runtime.Scheme
 	KubeClient kubernetes.ClientInterface
+	// WatchNamespaces restricts the ScrapeJobs and the output secrets to the
+	// listed namespaces, even if a config selects any namespace. All
+	// namespaces are allowed if empty. ClusterScrapeJobTemplates can't be used
+	// while it is set.
+	WatchNamespaces []string
+
+	// MaxConcurrentReconciles is the number of configs reconciled in
+	// parallel. Defaults to 1 if not set.
+	MaxConcurrentReconciles int
+	// RateLimiter limits the requeues of the configs. The controller-runtime
+	// default is used if not set.
+	RateLimiter workqueue.TypedRateLimiter[reconcile.Request]
+	// Recorder emits the events about the reconciled objects.
+	Recorder events.EventRecorder
+	// PrometheusClient calls the HTTP API of the Prometheus servers.
+	PrometheusClient prometheus.APIClient
+	// DeleteExpiredScrapeJobs deletes the selected ScrapeJobs once they are
+	// past activeUntil or their ttl. Expired jobs are only left out of the
+	// output if not set.
+	DeleteExpiredScrapeJobs bool
+
+	configIndex   *configSelectorIndex
+	pendingEvents pendingEvents
+	secretLocks   secretLocks
+	// targetHealthPolls holds the last target health poll of the configs.
+	targetHealthPolls targetHealthPolls
 }
 
 //+kubebuilder:rbac:groups=prometheus-static-target.kube-stager.io,resources=additionalscrapeconfigs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=prometheus-static-target.kube-stager.io,resources=additionalscrapeconfigs/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=prometheus-static-target.kube-stager.io,resources=additionalscrapeconfigs/finalizers,verbs=update
-//+kubebuilder:rbac:groups=prometheus-static-target.kube-stager.io,resources=scrapejobs,verbs=get;list;watch
-//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update
+//+kubebuilder:rbac:groups=prometheus-static-target.kube-stager.io,resources=scrapejobs,verbs=get;list;watch;delete
+//+kubebuilder:rbac:groups=prometheus-static-target.kube-stager.io,resources=scrapejobs/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=prometheus-static-target.kube-stager.io,resources=scrapejobtemplates;clusterscrapejobtemplates,verbs=get;list;watch
+// Secrets are written with server-side apply, which needs create for missing
+// secrets, and cleaned up with merge patches and deletes. They are read by name
+// through the API reader, but the managed secrets and the metadata of the
+// credential secrets are watched through label selected informers, and label
+// selectors can't be expressed in RBAC, so list and watch are granted on every
+// secret.
+//+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;patch;delete
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;create;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;patch
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=prometheuses;prometheusagents,verbs=get;patch
+//+kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
 func (r *AdditionalScrapeConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
 	logger.Info(fmt.Sprintf("Reconciling %s/%s", req.Namespace, req.Name))
+	r.pendingEvents.done(req.NamespacedName)
 
 	configYaml, err := r.KubeClient.GetAdditionalScrapeConfig(ctx, req.Namespace, req.Name)
 	if nil != err {
@@ -66,11 +126,23 @@ func (r *AdditionalScrapeConfigReconciler) Reconcile(ctx context.Context, req ct
 	}
 
 	// Gauge vectors retain stale label sets after CR deletion; the finalizer ensures cleanup.
+	// It also removes the secret from the wired Prometheus object and the exclusions and target health of the config from the ScrapeJobs.
 	if !configYaml.DeletionTimestamp.IsZero() {
 		if controllerutil.ContainsFinalizer(configYaml, metricsFinalizerName) {
+			if nil != configYaml.Status.WiredPrometheus {
+				if err := r.unwirePrometheus(ctx, logger, configYaml, configYaml.Status.WiredPrometheus); nil != err {
+					return ctrl.Result{}, err
+				}
+			}
+			if err := r.removeScrapeJobEntries(ctx, configYaml); nil != err {
+				return ctrl.Result{}, err
+			}
 			discoveredJobsGauge.DeleteLabelValues(configYaml.Name, configYaml.Namespace)
 			filteredJobsGauge.DeleteLabelValues(configYaml.Name, configYaml.Namespace)
+			excludedJobsGauge.DeleteLabelValues(configYaml.Name, configYaml.Namespace)
 			scrapeJobsLoadedGauge.DeleteLabelValues(configYaml.Name, configYaml.Namespace)
+			secretConflictGauge.DeleteLabelValues(configYaml.Name, configYaml.Namespace)
+			r.targetHealthPolls.forget(req.NamespacedName)
 			controllerutil.RemoveFinalizer(configYaml, metricsFinalizerName)
 			if err := r.Update(ctx, configYaml); err != nil {
 				return ctrl.Result{}, err
@@ -89,18 +161,112 @@ func (r *AdditionalScrapeConfigReconciler) Reconcile(ctx context.Context, req ct
 
 	targetList, err := r.loadTargets(ctx, logger, configYaml)
 	if nil != err {
+		r.recordEvent(configYaml, nil, corev1.EventTypeWarning, eventReasonLoadFailed, eventActionLoad, "Failed to load the ScrapeJobs: %s", err)
+		return ctrl.Result{}, err
+	}
+
+	templates, err := r.loadTemplates(ctx, targetList)
+	if nil != err {
+		r.recordEvent(configYaml, nil, corev1.EventTypeWarning, eventReasonLoadFailed, eventActionLoad, "Failed to load the ScrapeJob templates: %s", err)
+		return ctrl.Result{}, err
+	}
+
+	now := time.Now()
+	var targets selectedTargets
+	var jobs []prometheus.Job
+	targets.discovered, jobs, targets.excluded = r.processTargets(configYaml, targetList, templates, now)
+	targets.suspended = r.getSuspendedScrapeJobs(configYaml, targetList)
+	targets.inactive = r.getInactiveScrapeJobs(configYaml, targetList, now)
+
+	targetHealthRequeue, err := r.updateScrapeJobs(ctx, logger, configYaml, targetList, targets, now)
+	if nil != err {
+		return ctrl.Result{}, err
+	}
+
+	if err = r.updateStatusIfNeeded(ctx, targets, configYaml); nil != err {
+		return ctrl.Result{}, err
+	}
+
+	// Jobs becoming active or expiring don't generate any watch events
+	result := ctrl.Result{RequeueAfter: getEarlierRequeue(r.getNextScheduleBoundary(configYaml, targetList, now), targetHealthRequeue)}
+
+	if configYaml.GetPausedReason() != "" {
+		logger.Info("Config is paused, leaving the ScrapeJobs and the output unchanged")
+		return result, nil
+	}
+
+	previousStatus := configYaml.Status.DeepCopy()
+
+	// Pinned revisions are not validated again, they were valid when recorded
+	if nil == configYaml.Spec.PinnedRevision {
+		if err = r.validateOutput(configYaml, jobs); nil != err {
+			logger.Info(fmt.Sprintf("Not writing the invalid output: %s", err))
+			return result, r.updateStatusIfChanged(ctx, previousStatus, configYaml)
+		}
+	}
+
+	revisions, err := r.loadRevisions(ctx, configYaml)
+	if nil != err {
+		return ctrl.Result{}, err
+	}
+
+	jobs, err = r.getOutputJobs(configYaml, revisions, jobs)
+	if errors.Is(err, errPinnedRevisionNotFound) || errors.Is(err, errInvalidPinnedRevision) {
+		logger.Info(fmt.Sprintf("Leaving the output unchanged, revision %d can't be written: %s", *configYaml.Spec.PinnedRevision, err))
+		return result, r.updateStatusIfChanged(ctx, previousStatus, configYaml)
+	}
+	if nil != err {
+		return ctrl.Result{}, err
+	}
+
+	secretUpdate, err := r.updateSecret(ctx, logger, configYaml, jobs)
+	var conflictErr *secretConflictError
+	if errors.As(err, &conflictErr) {
+		logger.Info(conflictErr.Error())
+		r.recordEvent(configYaml, nil, corev1.EventTypeWarning, eventReasonSecretConflict, eventActionWrite, "%s", conflictErr)
+		secretConflictGauge.WithLabelValues(configYaml.Name, configYaml.Namespace).Set(1)
+		// Managed secrets are watched, so the config is requeued once the owning config releases the key.
+		// Unmanaged secrets are not in the cache, their changes have to be picked up by polling.
+		if conflictErr.reason == prometheusv1.ReasonUnmanagedSecret {
+			result.RequeueAfter = getEarlierRequeue(result.RequeueAfter, unmanagedSecretRequeueInterval)
+		}
+		return result, r.updateConflictStatusIfNeeded(ctx, conflictErr, previousStatus, configYaml)
+	}
+	if nil != err {
+		return ctrl.Result{}, err
+	}
+
+	secretConflictGauge.WithLabelValues(configYaml.Name, configYaml.Namespace).Set(0)
+
+	// Only outputs that reached the secret are recorded in the history
+	if err = r.updateRevisions(ctx, logger, configYaml, revisions, jobs); nil != err {
 		return ctrl.Result{}, err
 	}
 
-	discoveredTargets, jobs := r.processTargets(configYaml, targetList)
+	output := r.getOutputLocation(configYaml, secretUpdate.created)
 
-	if err = r.updateStatusIfNeeded(ctx, discoveredTargets, configYaml); nil != err {
+	if err = r.cleanupStaleOutput(ctx, logger, getConfigOwnerName(configYaml), configYaml.Status.LastOutput, output); nil != err {
 		return ctrl.Result{}, err
 	}
 
-	err = r.updateSecret(ctx, logger, configYaml, jobs)
+	if err = r.updateWorkloads(ctx, logger, configYaml, secretUpdate.checksum); nil != err {
+		return ctrl.Result{}, err
+	}
 
-	return ctrl.Result{}, err
+	if err = r.updatePrometheusRef(ctx, logger, configYaml); nil != err {
+		return ctrl.Result{}, err
+	}
+
+	var change *prometheusv1.ConfigChange
+	if nil != secretUpdate.diff && !secretUpdate.diff.IsEmpty() {
+		change = prometheusv1.NewConfigChange(metav1.Now(), secretUpdate.diff.Summary(), secretUpdate.diff.Lines())
+	}
+
+	result.RequeueAfter = getEarlierRequeue(result.RequeueAfter, r.updateReload(ctx, logger, configYaml, nil != change, time.Now()))
+
+	err = r.updateOutputStatusIfNeeded(ctx, output, change, previousStatus, configYaml)
+
+	return result, err
 }
 
 func (r *AdditionalScrapeConfigReconciler) loadTargets(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig) (*prometheusv1.ScrapeJobList, error) {
@@ -117,85 +283,427 @@ func (r *AdditionalScrapeConfigReconciler) loadTargets(ctx context.Context, logg
 	return targetList, err
 }
 
-func (r *AdditionalScrapeConfigReconciler) processTargets(config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList) ([]string, []prometheus.Job) {
+// updateScrapeJobs deletes the expired ScrapeJobs if enabled, and updates the
+// conditions and target health of the jobs selected by the config, or
+// selected by it before. Paused
+// configs leave the ScrapeJobs unchanged. Returns the time until the next
+// target health poll, or 0 if there is none.
+func (r *AdditionalScrapeConfigReconciler) updateScrapeJobs(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList, targets selectedTargets, now time.Time) (time.Duration, error) {
+	if config.GetPausedReason() != "" {
+		return 0, nil
+	}
+
+	if err := r.updateSuspendedConditions(ctx, config, targetList); nil != err {
+		return 0, err
+	}
+
+	if r.DeleteExpiredScrapeJobs {
+		if err := r.deleteExpiredScrapeJobs(ctx, logger, config, targetList, now); nil != err {
+			return 0, err
+		}
+	}
+
+	deselected, err := r.loadDeselectedScrapeJobs(ctx, config, targetList)
+	if nil != err {
+		return 0, err
+	}
+
+	if err = r.updateExcludedConditions(ctx, config, targetList, deselected, targets.excluded, now); nil != err {
+		return 0, err
+	}
+
+	return r.updateTargetHealth(ctx, logger, config, targetList, deselected, targets.discovered, now)
+}
+
+// processTargets renders the ScrapeJobs selected by the config. Every job is
+// rendered on its own, the ones that can't be rendered are excluded from the
+// output and returned with the reason, so they don't block the healthy ones.
+func (r *AdditionalScrapeConfigReconciler) processTargets(config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList, templates scrapeJobTemplates, now time.Time) ([]string, []prometheus.Job, []prometheusv1.ExcludedScrapeJob) {
 	var discoveredJobs []string
 	var jobs []prometheus.Job
+	var excludedJobs []prometheusv1.ExcludedScrapeJob
 	var filteredCount int
-	for _, target := range targetList.Items {
-		if !config.Spec.ScrapeJobNamespaceSelector.Matches(target.Namespace, config.Namespace) {
+	jobsByName := make(map[string]*prometheusv1.ScrapeJob)
+	for _, target := range getSortedScrapeJobs(targetList) {
+		if !r.selectsNamespace(config, target.Namespace) {
 			filteredCount++
 			continue
 		}
-		discoveredJobs = append(discoveredJobs, fmt.Sprintf("%s/%s", target.Namespace, target.Name))
-		job := prometheus.Job{
-			JobName:       target.Spec.JobName,
-			StaticConfigs: []prometheus.StaticConfig{},
+		if target.Spec.Suspend || !target.IsActive(now) {
+			continue
+		}
+		if jobName, other := findUsedJobName(jobsByName, target); nil != other {
+			message := fmt.Sprintf("job name %s is already used by ScrapeJob %s", jobName, getScrapeJobName(other))
+			r.recordScrapeJobWarning(config, target, eventReasonDuplicateJobName, "%s", message)
+			r.recordScrapeJobWarning(config, other, eventReasonDuplicateJobName, "job name %s is also used by ScrapeJob %s", jobName, getScrapeJobName(target))
+			excludedJobs = append(excludedJobs, newExcludedScrapeJob(target, prometheusv1.ReasonDuplicateJobName, message))
+			continue
+		}
+
+		if isClusterTemplateRef(target) && !r.clusterTemplatesAvailable() {
+			message := fmt.Sprintf("%s %s can't be used while the controller is restricted to namespaces", prometheusv1.ClusterScrapeJobTemplateKind, target.Spec.TemplateRef.Name)
+			r.recordScrapeJobWarning(config, target, eventReasonClusterTemplateUnavailable, "%s", message)
+			excludedJobs = append(excludedJobs, newExcludedScrapeJob(target, prometheusv1.ReasonClusterTemplateUnavailable, message))
+			continue
+		}
+		settings, err := templates.getSettings(target)
+		if nil != err {
+			r.recordScrapeJobWarning(config, target, eventReasonTemplateNotFound, "%s", err)
+			excludedJobs = append(excludedJobs, newExcludedScrapeJob(target, prometheusv1.ReasonTemplateNotFound, err.Error()))
+			continue
 		}
-		for _, staticConfig := range target.Spec.StaticConfigs {
-			job.StaticConfigs = append(job.StaticConfigs, prometheus.StaticConfig{
-				Targets: staticConfig.Targets,
-				Labels:  staticConfig.Labels,
-			})
+
+		renderedJobs, reason, err := renderScrapeJob(target, &settings)
+		if nil != err {
+			r.recordScrapeJobWarning(config, target, eventReasonInvalidScrapeJob, "%s", err)
+			excludedJobs = append(excludedJobs, newExcludedScrapeJob(target, reason, err.Error()))
+			continue
 		}
 
-		jobs = append(jobs, job)
+		for _, job := range renderedJobs {
+			jobsByName[job.JobName] = target
+		}
+		discoveredJobs = append(discoveredJobs, getScrapeJobName(target))
+		jobs = append(jobs, renderedJobs...)
 	}
 
 	sort.Strings(discoveredJobs)
 
-	discoveredJobsGauge.WithLabelValues(config.Name, config.Namespace).Set(float64(len(jobs)))
+	discoveredJobsGauge.WithLabelValues(config.Name, config.Namespace).Set(float64(len(discoveredJobs)))
 	filteredJobsGauge.WithLabelValues(config.Name, config.Namespace).Set(float64(filteredCount))
+	excludedJobsGauge.WithLabelValues(config.Name, config.Namespace).Set(float64(len(excludedJobs)))
 
-	return discoveredJobs, jobs
+	return discoveredJobs, jobs, excludedJobs
 }
 
-func (r *AdditionalScrapeConfigReconciler) updateStatusIfNeeded(ctx context.Context, discoveredTargets []string, config *prometheusv1.AdditionalScrapeConfig) error {
-	if len(discoveredTargets) == 0 && len(config.Status.DiscoveredScrapeJobs) == 0 {
-		return nil
+// findUsedJobName returns the first job name of the target already used by a
+// ScrapeJob in jobsByName, and the ScrapeJob using it.
+func findUsedJobName(jobsByName map[string]*prometheusv1.ScrapeJob, target *prometheusv1.ScrapeJob) (string, *prometheusv1.ScrapeJob) {
+	for _, jobName := range target.GetJobNames() {
+		if other, ok := jobsByName[jobName]; ok {
+			return jobName, other
+		}
+	}
+
+	return "", nil
+}
+
+// applyScrapeSettings sets the scrape settings on the rendered job. The metrics
+// path is only set if the job doesn't scrape an exporter path already, and the
+// relabel configs run after the ones of the job.
+func applyScrapeSettings(job *prometheus.Job, settings *prometheusv1.ScrapeSettings) {
+	job.ScrapeInterval = settings.ScrapeInterval
+	job.ScrapeTimeout = settings.ScrapeTimeout
+	job.Scheme = settings.Scheme
+	if job.MetricsPath == "" {
+		job.MetricsPath = settings.MetricsPath
+	}
+	if tls := settings.TLSConfig; nil != tls {
+		job.TLSConfig = &prometheus.TLSConfig{
+			CAFile:             tls.CAFile,
+			CertFile:           tls.CertFile,
+			KeyFile:            tls.KeyFile,
+			ServerName:         tls.ServerName,
+			InsecureSkipVerify: tls.InsecureSkipVerify,
+		}
 	}
-	if !reflect.DeepEqual(discoveredTargets, config.Status.DiscoveredScrapeJobs) {
-		config.Status.DiscoveredScrapeJobs = discoveredTargets
-		return r.Status().Update(ctx, config)
+	if basicAuth := settings.BasicAuth; nil != basicAuth {
+		job.BasicAuth = &prometheus.BasicAuth{Username: basicAuth.Username, PasswordFile: basicAuth.PasswordFile}
+	}
+	if authorization := settings.Authorization; nil != authorization {
+		job.Authorization = &prometheus.Authorization{Type: authorization.Type, CredentialsFile: authorization.CredentialsFile}
+	}
+	job.RelabelConfigs = append(job.RelabelConfigs, convertRelabelConfigs(settings.RelabelConfigs)...)
+	job.MetricRelabelConfigs = convertRelabelConfigs(settings.MetricRelabelConfigs)
+}
+
+func convertRelabelConfigs(relabelConfigs []prometheusv1.RelabelConfig) []prometheus.RelabelConfig {
+	var converted []prometheus.RelabelConfig
+	for _, relabelConfig := range relabelConfigs {
+		converted = append(converted, prometheus.RelabelConfig{
+			SourceLabels: relabelConfig.SourceLabels,
+			Separator:    relabelConfig.Separator,
+			Regex:        relabelConfig.Regex,
+			Modulus:      relabelConfig.Modulus,
+			TargetLabel:  relabelConfig.TargetLabel,
+			Replacement:  relabelConfig.Replacement,
+			Action:       relabelConfig.Action,
+		})
 	}
 
-	return nil
+	return converted
 }
 
-func (r *AdditionalScrapeConfigReconciler) updateSecret(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, jobs []prometheus.Job) error {
+// getSortedScrapeJobs returns the jobs in the list sorted by namespace and
+// name, so duplicate job names are always resolved the same way.
+func getSortedScrapeJobs(targetList *prometheusv1.ScrapeJobList) []*prometheusv1.ScrapeJob {
+	targets := make([]*prometheusv1.ScrapeJob, 0, len(targetList.Items))
+	for i := range targetList.Items {
+		targets = append(targets, &targetList.Items[i])
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return getScrapeJobName(targets[i]) < getScrapeJobName(targets[j])
+	})
+
+	return targets
+}
+
+func newExcludedScrapeJob(target *prometheusv1.ScrapeJob, reason string, message string) prometheusv1.ExcludedScrapeJob {
+	return prometheusv1.ExcludedScrapeJob{
+		Name:    getScrapeJobName(target),
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+func (r *AdditionalScrapeConfigReconciler) isWatchedNamespace(namespace string) bool {
+	return len(r.WatchNamespaces) == 0 || helper.StringInStringSlice(namespace, r.WatchNamespaces)
+}
+
+// selectsNamespace returns true if the config may render ScrapeJobs from the
+// namespace.
+func (r *AdditionalScrapeConfigReconciler) selectsNamespace(config *prometheusv1.AdditionalScrapeConfig, namespace string) bool {
+	return r.isWatchedNamespace(namespace) && config.Spec.ScrapeJobNamespaceSelector.Matches(namespace, config.Namespace)
+}
+
+// selectedTargets holds the ScrapeJobs selected by a config, grouped by how
+// they are handled.
+type selectedTargets struct {
+	// discovered are the rendered jobs.
+	discovered []string
+	// suspended are the jobs left out because of spec.suspend.
+	suspended []string
+	// inactive are the jobs left out because they are outside their active
+	// window.
+	inactive []string
+	// excluded are the jobs left out because they can't be rendered.
+	excluded []prometheusv1.ExcludedScrapeJob
+}
+
+func (r *AdditionalScrapeConfigReconciler) updateStatusIfNeeded(ctx context.Context, targets selectedTargets, config *prometheusv1.AdditionalScrapeConfig) error {
+	previous := config.Status.DeepCopy()
+	// An empty list and a missing one are the same, don't update the status just because of the difference
+	if len(targets.discovered) != 0 || len(config.Status.DiscoveredScrapeJobs) != 0 {
+		config.Status.DiscoveredScrapeJobs = targets.discovered
+	}
+	config.Status.SuspendedScrapeJobs = targets.suspended
+	config.Status.InactiveScrapeJobs = targets.inactive
+	config.Status.ExcludedScrapeJobs = targets.excluded
+	setPausedCondition(config)
+
+	return r.updateStatusIfChanged(ctx, previous, config)
+}
+
+// secretUpdateResult describes the outcome of updateSecret.
+type secretUpdateResult struct {
+	// created is true if the secret didn't exist before the update.
+	created bool
+	// diff describes the changes written, nil if the secret was not written.
+	diff *prometheus.JobListDiff
+	// checksum is the checksum of the secret data after the update.
+	checksum string
+}
+
+// updateSecret writes the rendered jobs to the configured secret key and
+// records the config as the owner of the key. Returns a secretConflictError
+// if the config is not allowed to write the key.
+func (r *AdditionalScrapeConfigReconciler) updateSecret(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, jobs []prometheus.Job) (secretUpdateResult, error) {
+	// The controller has no permissions on the secrets outside of the watched namespaces
+	if !r.isWatchedNamespace(config.Spec.SecretNamespace) {
+		return secretUpdateResult{}, &secretConflictError{
+			reason:  prometheusv1.ReasonSecretNamespaceNotWatched,
+			message: fmt.Sprintf("Secret namespace %s is not watched by the controller, it is restricted to %s", config.Spec.SecretNamespace, strings.Join(r.WatchNamespaces, ", ")),
+		}
+	}
+
+	unlock := r.secretLocks.lock(config.Spec.SecretNamespace, config.Spec.SecretName)
+	defer unlock()
+
 	secret, secretExists, err := r.KubeClient.GetSecret(ctx, config)
 	if nil != err {
-		return err
+		return secretUpdateResult{}, err
 	}
 
-	sort.Slice(jobs, func(i, j int) bool {
-		return jobs[i].JobName < jobs[j].JobName
-	})
+	if err = r.checkSecretOwnership(ctx, config, secret, secretExists); nil != err {
+		return secretUpdateResult{}, err
+	}
 
-	yamlData, err := yaml.Marshal(jobs)
+	owners, err := getSecretKeyOwners(secret)
 	if nil != err {
-		return err
+		return secretUpdateResult{}, err
+	}
+	if nil == owners {
+		owners = make(map[string]string)
+	}
+	ownerName := getConfigOwnerName(config)
+	ownerChanged := owners[config.Spec.SecretKey] != ownerName || secret.Labels[managedSecretLabel] != "true"
+	owners[config.Spec.SecretKey] = ownerName
+	if err = setSecretKeyOwners(secret, owners); nil != err {
+		return secretUpdateResult{}, err
+	}
+
+	yamlData, err := renderJobs(jobs)
+	if nil != err {
+		return secretUpdateResult{}, err
 	}
 
 	if nil == secret.Data {
 		secret.Data = make(map[string][]byte)
 	}
 
-	if secretExists && string(secret.Data[config.Spec.SecretKey]) == string(yamlData) {
-		return nil
+	if secretExists && !ownerChanged && string(secret.Data[config.Spec.SecretKey]) == string(yamlData) {
+		return secretUpdateResult{checksum: getSecretChecksum(secret)}, nil
 	}
 
 	logger.Info("Updating secret")
+	diff := prometheus.DiffJobs(getRenderedJobs(secret.Data[config.Spec.SecretKey]), jobs)
 	secret.Data[config.Spec.SecretKey] = yamlData
 	logger.V(1).Info(fmt.Sprintf("Updating secret to %+v", secret.Data))
 
-	if err = r.KubeClient.CreateOrUpdateSecret(ctx, secretExists, secret); err != nil {
+	applyConfig, err := getSecretApplyConfiguration(secret, secretExists)
+	if nil != err {
+		return secretUpdateResult{}, err
+	}
+
+	writeStart := time.Now()
+	err = r.KubeClient.ApplySecret(ctx, applyConfig)
+	secretWriteDurationHistogram.WithLabelValues(config.Name, config.Namespace).Observe(time.Since(writeStart).Seconds())
+	if err != nil {
 		secretUpdateErrorCounter.WithLabelValues(config.Name, config.Namespace).Inc()
-		return err
+		r.recordEvent(config, nil, corev1.EventTypeWarning, eventReasonWriteFailed, eventActionWrite, "Failed to write secret %s/%s: %s", secret.Namespace, secret.Name, err)
+		return secretUpdateResult{}, err
 	}
 
 	secretUpdateCounter.WithLabelValues(config.Name, config.Namespace).Inc()
+	logger.Info("Updated secret", "summary", diff.Summary(), "changes", diff.Lines())
+	r.recordEvent(config, nil, corev1.EventTypeNormal, eventReasonSecretUpdated, eventActionWrite, "Updated key %s in secret %s/%s: %s", config.Spec.SecretKey, secret.Namespace, secret.Name, strings.Join(append([]string{diff.Summary()}, diff.Lines()...), "; "))
+
+	return secretUpdateResult{created: !secretExists, diff: diff, checksum: getSecretChecksum(secret)}, nil
+}
+
+// getRenderedJobs parses the previously rendered jobs. A value that can't be
+// parsed is treated as if there were no jobs.
+func getRenderedJobs(data []byte) []prometheus.Job {
+	var jobs []prometheus.Job
+	if err := yaml.Unmarshal(data, &jobs); nil != err {
+		return nil
+	}
+
+	return jobs
+}
+
+// getOutputLocation returns the current output location of the config. The
+// secret is considered operator created if it was just created, or if the
+// previous output was in the same secret and that one was created by the
+// operator.
+func (r *AdditionalScrapeConfigReconciler) getOutputLocation(config *prometheusv1.AdditionalScrapeConfig, secretCreated bool) *prometheusv1.OutputLocation {
+	output := config.Spec.OutputLocation()
+	previous := config.Status.LastOutput
+
+	output.SecretCreated = secretCreated || (nil != previous && previous.SameSecret(output) && previous.SecretCreated)
+
+	return output
+}
+
+// cleanupStaleOutput removes the previously written key if the output location
+// changed. If the previous secret was created by the operator and has no data
+// left, the whole secret is deleted. Keys that have been taken over by another
+// config are left alone.
+func (r *AdditionalScrapeConfigReconciler) cleanupStaleOutput(ctx context.Context, logger logr.Logger, owner string, previous *prometheusv1.OutputLocation, current *prometheusv1.OutputLocation) error {
+	if nil == previous || previous.SameLocation(current) {
+		return nil
+	}
+
+	unlock := r.secretLocks.lock(previous.SecretNamespace, previous.SecretName)
+	defer unlock()
+
+	secret, secretExists, err := r.KubeClient.GetSecretByName(ctx, previous.SecretNamespace, previous.SecretName)
+	if nil != err {
+		return err
+	}
+
+	if !secretExists {
+		return nil
+	}
+
+	original := secret.DeepCopy()
+
+	owners, err := getSecretKeyOwners(secret)
+	if nil != err {
+		return err
+	}
+
+	if nil != owners {
+		if keyOwner := owners[previous.SecretKey]; keyOwner != owner {
+			return nil
+		}
+		delete(owners, previous.SecretKey)
+		if err = setSecretKeyOwners(secret, owners); nil != err {
+			return err
+		}
+	}
+
+	_, keyExists := secret.Data[previous.SecretKey]
+	delete(secret.Data, previous.SecretKey)
 
-	return nil
+	if previous.SecretCreated && !previous.SameSecret(current) && len(secret.Data) == 0 {
+		logger.Info(fmt.Sprintf("Deleting previous output secret %s/%s", previous.SecretNamespace, previous.SecretName))
+		return r.KubeClient.DeleteSecret(ctx, secret)
+	}
+
+	if !keyExists && nil == owners {
+		return nil
+	}
+
+	logger.Info(fmt.Sprintf("Removing stale key %s from secret %s/%s", previous.SecretKey, previous.SecretNamespace, previous.SecretName))
+
+	return r.KubeClient.PatchSecret(ctx, original, secret)
+}
+
+func (r *AdditionalScrapeConfigReconciler) updateOutputStatusIfNeeded(ctx context.Context, output *prometheusv1.OutputLocation, change *prometheusv1.ConfigChange, previous *prometheusv1.AdditionalScrapeConfigStatus, config *prometheusv1.AdditionalScrapeConfig) error {
+	r.setConflictCondition(nil, config)
+	config.Status.LastOutput = output
+	if nil != change {
+		config.Status.LastChange = change
+	}
+
+	return r.updateStatusIfChanged(ctx, previous, config)
+}
+
+func (r *AdditionalScrapeConfigReconciler) updateConflictStatusIfNeeded(ctx context.Context, conflictErr *secretConflictError, previous *prometheusv1.AdditionalScrapeConfigStatus, config *prometheusv1.AdditionalScrapeConfig) error {
+	r.setConflictCondition(conflictErr, config)
+
+	return r.updateStatusIfChanged(ctx, previous, config)
+}
+
+// updateStatusIfChanged saves the status of the config if it differs from the
+// previous one.
+func (r *AdditionalScrapeConfigReconciler) updateStatusIfChanged(ctx context.Context, previous *prometheusv1.AdditionalScrapeConfigStatus, config *prometheusv1.AdditionalScrapeConfig) error {
+	if reflect.DeepEqual(previous, &config.Status) {
+		return nil
+	}
+
+	return r.Status().Update(ctx, config)
+}
+
+// setConflictCondition sets the SecretConflict condition based on the conflict
+// error, or clears it if it's nil. Returns true if the condition changed.
+func (r *AdditionalScrapeConfigReconciler) setConflictCondition(conflictErr *secretConflictError, config *prometheusv1.AdditionalScrapeConfig) bool {
+	condition := metav1.Condition{
+		Type:               prometheusv1.ConditionTypeSecretConflict,
+		Status:             metav1.ConditionFalse,
+		Reason:             prometheusv1.ReasonNoConflict,
+		Message:            "The output secret key is owned by this config",
+		ObservedGeneration: config.Generation,
+	}
+
+	if nil != conflictErr {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = conflictErr.reason
+		condition.Message = conflictErr.message
+	}
+
+	return meta.SetStatusCondition(&config.Status.Conditions, condition)
 }
 
 func (r *AdditionalScrapeConfigReconciler) findConfigsForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
@@ -206,87 +714,176 @@ func (r *AdditionalScrapeConfigReconciler) findConfigsForSecret(ctx context.Cont
 
 	var requests []reconcile.Request
 	for _, item := range configYamlList.Items {
-		if item.Spec.SecretNamespace == secret.GetNamespace() {
-			requests = append(requests, reconcile.Request{
-				NamespacedName: types.NamespacedName{
-					Name:      item.GetName(),
-					Namespace: item.GetNamespace(),
-				},
-			})
-		}
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name:      item.GetName(),
+				Namespace: item.GetNamespace(),
+			},
+		})
 	}
 
 	return requests
 }
 
-func (r *AdditionalScrapeConfigReconciler) findConfigsForJobs(ctx context.Context, target client.Object) []reconcile.Request {
+// findConfigsForJobs returns the configs selecting any of the given versions
+// of a ScrapeJob. Configs listing the job in their status are only included if
+// includeDiscovered is set. The selector index is used once it's set up,
+// otherwise every config is listed and checked.
+func (r *AdditionalScrapeConfigReconciler) findConfigsForJobs(ctx context.Context, includeDiscovered bool, targets ...client.Object) []reconcile.Request {
+	if nil != r.configIndex {
+		return r.configIndex.find(includeDiscovered, targets...)
+	}
+
 	allConfigYamls, err := r.KubeClient.GetAllAdditionalScrapeConfigs(ctx)
 	if err != nil {
 		return []reconcile.Request{}
 	}
 
-	targetLabels := target.GetLabels()
-
 	var requests []reconcile.Request
 	for _, item := range allConfigYamls.Items {
-		if !item.Spec.ScrapeJobNamespaceSelector.Matches(target.GetNamespace(), item.GetNamespace()) {
-			continue
-		}
-
-		if len(item.Spec.ScrapeJobLabels) == 0 {
-			continue
-		}
-
-		matched := true
-		for key, value := range item.Spec.ScrapeJobLabels {
-			if targetLabels[key] != value {
-				matched = false
+		for _, target := range targets {
+			if configSelectsJob(&item, target) || (includeDiscovered && configDiscoveredJob(&item, target)) {
+				requests = append(
+					requests,
+					reconcile.Request{
+						NamespacedName: types.NamespacedName{
+							Name:      item.GetName(),
+							Namespace: item.GetNamespace(),
+						},
+					},
+				)
 				break
 			}
 		}
-		if !matched {
-			continue
+	}
+
+	return requests
+}
+
+func configSelectsJob(config *prometheusv1.AdditionalScrapeConfig, target client.Object) bool {
+	if !config.Spec.ScrapeJobNamespaceSelector.Matches(target.GetNamespace(), config.GetNamespace()) {
+		return false
+	}
+
+	// An empty label set selects every ScrapeJob, the same way LoadScrapeJobs
+	// lists every job for it.
+	targetLabels := target.GetLabels()
+	for key, value := range config.Spec.ScrapeJobLabels {
+		if targetLabels[key] != value {
+			return false
 		}
+	}
+
+	return true
+}
+
+func configDiscoveredJob(config *prometheusv1.AdditionalScrapeConfig, target client.Object) bool {
+	return helper.StringInStringSlice(getScrapeJobName(target), config.Status.DiscoveredScrapeJobs)
+}
+
+func getScrapeJobName(target client.Object) string {
+	return fmt.Sprintf("%s/%s", target.GetNamespace(), target.GetName())
+}
+
+// NewRateLimiter returns the requeue rate limiter of the controller: a per
+// item exponential backoff between baseDelay and maxDelay, combined with an
+// overall token bucket limit of qps with the given burst.
+func NewRateLimiter(baseDelay time.Duration, maxDelay time.Duration, qps float64, burst int) workqueue.TypedRateLimiter[reconcile.Request] {
+	return workqueue.NewTypedMaxOfRateLimiter(
+		workqueue.NewTypedItemExponentialFailureRateLimiter[reconcile.Request](baseDelay, maxDelay),
+		&workqueue.TypedBucketRateLimiter[reconcile.Request]{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	)
+}
 
-		requests = append(
-			requests,
-			reconcile.Request{
-				NamespacedName: types.NamespacedName{
-					Name:      item.GetName(),
-					Namespace: item.GetNamespace(),
-				},
+// CacheOptions returns the manager cache options required by the controller.
+// Only the secrets managed by the operator are cached, the metadata of the
+// other secrets is watched through a separate cache. The secrets themselves
+// are read directly from the API server.
+func CacheOptions() cache.Options {
+	return cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Secret{}: {
+				Label: labels.SelectorFromSet(labels.Set{managedSecretLabel: "true"}),
 			},
-		)
+		},
 	}
-
-	return requests
 }
 
 func (r *AdditionalScrapeConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if r.KubeClient == nil {
-		r.KubeClient = kubernetes.NewClient(r.Client)
+		r.KubeClient = kubernetes.NewClient(r.Client, mgr.GetAPIReader())
+	}
+	if r.Recorder == nil {
+		r.Recorder = mgr.GetEventRecorder(eventRecorderName)
+	}
+	if r.PrometheusClient == nil {
+		r.PrometheusClient = prometheus.NewHTTPClient(prometheusRequestTimeout)
+	}
+
+	configInformer, err := mgr.GetCache().GetInformer(context.Background(), &prometheusv1.AdditionalScrapeConfig{})
+	if err != nil {
+		return err
+	}
+	configIndex := newConfigSelectorIndex()
+	if _, err = configInformer.AddEventHandler(configIndex.eventHandler()); err != nil {
+		return err
+	}
+	r.configIndex = configIndex
+
+	if err := mgr.GetFieldIndexer().IndexField(
+		context.Background(), &prometheusv1.AdditionalScrapeConfig{}, kubernetes.SecretIndexField, func(rawObj client.Object) []string {
+			return kubernetes.GetReferencedSecretIndexKeys(rawObj.(*prometheusv1.AdditionalScrapeConfig))
+		},
+	); err != nil {
+		return err
 	}
 
 	if err := mgr.GetFieldIndexer().IndexField(
-		context.Background(), &prometheusv1.AdditionalScrapeConfig{}, ".spec.secretName", func(rawObj client.Object) []string {
-			config := rawObj.(*prometheusv1.AdditionalScrapeConfig)
-			return []string{config.Spec.SecretName}
+		context.Background(), &prometheusv1.ScrapeJob{}, kubernetes.TemplateIndexField, func(rawObj client.Object) []string {
+			return kubernetes.GetReferencedTemplateIndexKeys(rawObj.(*prometheusv1.ScrapeJob))
 		},
 	); err != nil {
 		return err
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	unmanagedSecretCache, unmanagedSecrets, err := r.newUnmanagedSecretSource(mgr)
+	if nil != err {
+		return err
+	}
+	if err = mgr.Add(unmanagedSecretCache); nil != err {
+		return err
+	}
+
+	controllerBuilder := ctrl.NewControllerManagedBy(mgr).
 		For(&prometheusv1.AdditionalScrapeConfig{}).
+		WithOptions(crcontroller.Options{
+			MaxConcurrentReconciles: r.MaxConcurrentReconciles,
+			RateLimiter:             r.RateLimiter,
+		}).
 		Watches(
 			&corev1.Secret{},
 			handler.EnqueueRequestsFromMapFunc(r.findConfigsForSecret),
 			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
 		).
+		WatchesRawSource(unmanagedSecrets).
 		Watches(
 			&prometheusv1.ScrapeJob{},
-			handler.EnqueueRequestsFromMapFunc(r.findConfigsForJobs),
-			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
+			&scrapeJobEventHandler{reconciler: r},
+			// The status of the jobs is written by the controller, only spec and label changes are relevant
+			builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{})),
 		).
-		Complete(r)
+		Watches(
+			&prometheusv1.ScrapeJobTemplate{},
+			handler.EnqueueRequestsFromMapFunc(r.findConfigsForTemplate),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		)
+	if r.clusterTemplatesAvailable() {
+		controllerBuilder = controllerBuilder.Watches(
+			&prometheusv1.ClusterScrapeJobTemplate{},
+			handler.EnqueueRequestsFromMapFunc(r.findConfigsForTemplate),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{}),
+		)
+	}
+
+	return controllerBuilder.Complete(r)
 }
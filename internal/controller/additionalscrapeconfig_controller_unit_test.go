@@ -4,11 +4,16 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
 	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 // --- processTargets tests ---
@@ -30,7 +35,7 @@ func TestProcessTargets_FiltersNamespace(t *testing.T) {
 		},
 	}
 
-	discovered, jobs := r.processTargets(config, targets)
+	discovered, jobs, _ := r.processTargets(config, targets, nil, time.Now())
 	if len(discovered) != 1 || discovered[0] != "ns1/j1" {
 		t.Errorf("discovered = %v, want [ns1/j1]", discovered)
 	}
@@ -54,12 +59,36 @@ func TestProcessTargets_SortsDiscovered(t *testing.T) {
 		},
 	}
 
-	discovered, _ := r.processTargets(config, targets)
+	discovered, _, _ := r.processTargets(config, targets, nil, time.Now())
 	if len(discovered) != 2 || discovered[0] != "ns1/alpha" || discovered[1] != "ns1/beta" {
 		t.Errorf("discovered = %v, want [ns1/alpha ns1/beta]", discovered)
 	}
 }
 
+func TestProcessTargets_LimitsToWatchedNamespaces(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{WatchNamespaces: []string{"ns1"}}
+	config := &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default"},
+		Spec: prometheusv1.AdditionalScrapeConfigSpec{
+			ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{Any: true},
+		},
+	}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "job1"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "j2", Namespace: "ns2"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "job2"}},
+		},
+	}
+
+	discovered, jobs, _ := r.processTargets(config, targets, nil, time.Now())
+	if len(discovered) != 1 || discovered[0] != "ns1/j1" {
+		t.Errorf("discovered = %v, want [ns1/j1]", discovered)
+	}
+	if len(jobs) != 1 || jobs[0].JobName != "job1" {
+		t.Errorf("jobs = %v, want [job1]", jobs)
+	}
+}
+
 func TestProcessTargets_EmptyInput(t *testing.T) {
 	r := &AdditionalScrapeConfigReconciler{}
 	config := &prometheusv1.AdditionalScrapeConfig{
@@ -70,7 +99,7 @@ func TestProcessTargets_EmptyInput(t *testing.T) {
 	}
 	targets := &prometheusv1.ScrapeJobList{}
 
-	discovered, jobs := r.processTargets(config, targets)
+	discovered, jobs, _ := r.processTargets(config, targets, nil, time.Now())
 	if discovered != nil {
 		t.Errorf("discovered = %v, want nil", discovered)
 	}
@@ -102,7 +131,7 @@ func TestProcessTargets_MultipleStaticConfigs(t *testing.T) {
 		},
 	}
 
-	_, jobs := r.processTargets(config, targets)
+	_, jobs, _ := r.processTargets(config, targets, nil, time.Now())
 	if len(jobs) != 1 {
 		t.Fatalf("got %d jobs, want 1", len(jobs))
 	}
@@ -113,6 +142,32 @@ func TestProcessTargets_MultipleStaticConfigs(t *testing.T) {
 	assertStaticConfig(t, jobs[0].StaticConfigs[1], []string{"host2:9090"}, "staging")
 }
 
+func TestProcessTargets_ExpandsModulesIntoJobs(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{Recorder: &mockEventRecorder{}}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	exporter := &prometheusv1.ScrapeJobMultiTargetExporter{Address: "snmp:9116", Path: "/snmp", Modules: []string{"if_mib", "ip_mib"}, Targets: []string{"switch"}}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "a-snmp", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "snmp", MultiTargetExporter: exporter}},
+			// Collides with the job rendered for the if_mib module
+			{ObjectMeta: metav1.ObjectMeta{Name: "b-static", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "snmp_if_mib",
+				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"host:80"}}},
+			}},
+		},
+	}
+
+	discovered, jobs, excluded := r.processTargets(config, targets, nil, time.Now())
+
+	if len(discovered) != 1 || discovered[0] != "ns1/a-snmp" || len(jobs) != 2 {
+		t.Errorf("discovered = %v, jobs = %d, want both module jobs of the exporter", discovered, len(jobs))
+	}
+	if len(excluded) != 1 || excluded[0].Name != "ns1/b-static" || excluded[0].Reason != prometheusv1.ReasonDuplicateJobName {
+		t.Errorf("excluded = %+v, want the colliding static job", excluded)
+	}
+}
+
 func assertStaticConfig(t *testing.T, sc prometheus.StaticConfig, expectedTargets []string, expectedEnv string) {
 	t.Helper()
 	if len(sc.Targets) != len(expectedTargets) || sc.Targets[0] != expectedTargets[0] {
@@ -161,6 +216,27 @@ func TestFindConfigsForSecret_Match(t *testing.T) {
 	}
 }
 
+func TestFindConfigsForSecret_CredentialSecret(t *testing.T) {
+	config := newTestConfig()
+	config.Spec.Reload = &prometheusv1.ReloadSpec{
+		PrometheusEndpoint: prometheusv1.PrometheusEndpoint{
+			BearerTokenSecret: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "prometheus-token"}, Key: "token"},
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{
+		KubeClient: &mockKubeClient{configs: &prometheusv1.AdditionalScrapeConfigList{Items: []prometheusv1.AdditionalScrapeConfig{*config}}},
+	}
+
+	// The unmanaged secrets are only watched with their metadata
+	secret := &metav1.PartialObjectMetadata{
+		ObjectMeta: metav1.ObjectMeta{Name: "prometheus-token", Namespace: "default"},
+	}
+	requests := r.findConfigsForSecret(context.Background(), secret)
+	if len(requests) != 1 || requests[0].Name != "cfg" {
+		t.Errorf("requests = %v, want default/cfg", requests)
+	}
+}
+
 func TestFindConfigsForSecret_Error(t *testing.T) {
 	r := &AdditionalScrapeConfigReconciler{
 		KubeClient: &mockKubeClient{err: fmt.Errorf("api error")},
@@ -203,7 +279,7 @@ func TestFindConfigsForJobs_LabelMatch(t *testing.T) {
 	job := &prometheusv1.ScrapeJob{
 		ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns1", Labels: map[string]string{"app": "web"}},
 	}
-	requests := r.findConfigsForJobs(context.Background(), job)
+	requests := r.findConfigsForJobs(context.Background(), false, job)
 	if len(requests) != 1 {
 		t.Fatalf("got %d requests, want 1", len(requests))
 	}
@@ -212,7 +288,7 @@ func TestFindConfigsForJobs_LabelMatch(t *testing.T) {
 	}
 }
 
-func TestFindConfigsForJobs_EmptyLabelsSkipped(t *testing.T) {
+func TestFindConfigsForJobs_EmptyLabelsMatchAll(t *testing.T) {
 	allConfigs := &prometheusv1.AdditionalScrapeConfigList{
 		Items: []prometheusv1.AdditionalScrapeConfig{
 			{
@@ -232,9 +308,9 @@ func TestFindConfigsForJobs_EmptyLabelsSkipped(t *testing.T) {
 	job := &prometheusv1.ScrapeJob{
 		ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns1", Labels: map[string]string{"app": "web"}},
 	}
-	requests := r.findConfigsForJobs(context.Background(), job)
-	if len(requests) != 0 {
-		t.Errorf("expected 0 requests for empty labels config, got %d", len(requests))
+	requests := r.findConfigsForJobs(context.Background(), false, job)
+	if len(requests) != 1 {
+		t.Errorf("expected 1 request for empty labels config, got %d", len(requests))
 	}
 }
 
@@ -261,14 +337,14 @@ func TestFindConfigsForJobs_NamespaceFiltering(t *testing.T) {
 	job := &prometheusv1.ScrapeJob{
 		ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns-other", Labels: map[string]string{"app": "web"}},
 	}
-	requests := r.findConfigsForJobs(context.Background(), job)
+	requests := r.findConfigsForJobs(context.Background(), false, job)
 	if len(requests) != 0 {
 		t.Errorf("expected 0 requests for wrong namespace, got %d", len(requests))
 	}
 
 	// Job in correct namespace
 	job.Namespace = "ns-allowed"
-	requests = r.findConfigsForJobs(context.Background(), job)
+	requests = r.findConfigsForJobs(context.Background(), false, job)
 	if len(requests) != 1 {
 		t.Errorf("expected 1 request for correct namespace, got %d", len(requests))
 	}
@@ -281,8 +357,263 @@ func TestFindConfigsForJobs_Error(t *testing.T) {
 	job := &prometheusv1.ScrapeJob{
 		ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns1"},
 	}
-	requests := r.findConfigsForJobs(context.Background(), job)
+	requests := r.findConfigsForJobs(context.Background(), false, job)
 	if len(requests) != 0 {
 		t.Errorf("expected empty requests on error, got %d", len(requests))
 	}
 }
+
+// --- output location tests ---
+
+func TestGetOutputLocation_KeepsCreatedFlagForSameSecret(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{}
+	config := &prometheusv1.AdditionalScrapeConfig{
+		Spec: prometheusv1.AdditionalScrapeConfigSpec{
+			SecretName:      "my-secret",
+			SecretNamespace: "default",
+			SecretKey:       "new-key",
+		},
+		Status: prometheusv1.AdditionalScrapeConfigStatus{
+			LastOutput: &prometheusv1.OutputLocation{
+				SecretName:      "my-secret",
+				SecretNamespace: "default",
+				SecretKey:       "old-key",
+				SecretCreated:   true,
+			},
+		},
+	}
+
+	output := r.getOutputLocation(config, false)
+	if !output.SecretCreated {
+		t.Error("expected SecretCreated to be carried over for the same secret")
+	}
+
+	config.Spec.SecretName = "other-secret"
+	output = r.getOutputLocation(config, false)
+	if output.SecretCreated {
+		t.Error("expected SecretCreated to be false for a different pre-existing secret")
+	}
+}
+
+// --- cleanupStaleOutput tests ---
+
+func TestCleanupStaleOutput_UnchangedLocation(t *testing.T) {
+	mock := &mockKubeClient{
+		patchFn: func(_ context.Context, _ *corev1.Secret, _ *corev1.Secret) error {
+			t.Error("unexpected secret write")
+			return nil
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	location := &prometheusv1.OutputLocation{SecretName: "s", SecretNamespace: "ns", SecretKey: "k"}
+
+	if err := r.cleanupStaleOutput(context.Background(), zap.New(), "default/cfg", nil, location); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := r.cleanupStaleOutput(context.Background(), zap.New(), "default/cfg", location.DeepCopy(), location); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCleanupStaleOutput_RemovesOldKey(t *testing.T) {
+	var written *corev1.Secret
+	mock := &mockKubeClient{
+		secretsByName: map[string]*corev1.Secret{
+			"ns/s": {
+				ObjectMeta: metav1.ObjectMeta{Name: "s", Namespace: "ns"},
+				Data:       map[string][]byte{"old": []byte("a"), "new": []byte("b")},
+			},
+		},
+		patchFn: func(_ context.Context, _ *corev1.Secret, secret *corev1.Secret) error {
+			written = secret
+			return nil
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	previous := &prometheusv1.OutputLocation{SecretName: "s", SecretNamespace: "ns", SecretKey: "old", SecretCreated: true}
+	current := &prometheusv1.OutputLocation{SecretName: "s", SecretNamespace: "ns", SecretKey: "new", SecretCreated: true}
+
+	if err := r.cleanupStaleOutput(context.Background(), zap.New(), "default/cfg", previous, current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written == nil {
+		t.Fatal("expected the secret to be updated")
+	}
+	if _, ok := written.Data["old"]; ok {
+		t.Error("expected the old key to be removed")
+	}
+	if string(written.Data["new"]) != "b" {
+		t.Errorf("Data[new] = %q, want %q", written.Data["new"], "b")
+	}
+}
+
+func TestCleanupStaleOutput_DeletesCreatedSecret(t *testing.T) {
+	var deleted *corev1.Secret
+	mock := &mockKubeClient{
+		secretsByName: map[string]*corev1.Secret{
+			"ns/old": {
+				ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "ns"},
+				Data:       map[string][]byte{"key": []byte("a")},
+			},
+		},
+		deleteFn: func(_ context.Context, secret *corev1.Secret) error {
+			deleted = secret
+			return nil
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	previous := &prometheusv1.OutputLocation{SecretName: "old", SecretNamespace: "ns", SecretKey: "key", SecretCreated: true}
+	current := &prometheusv1.OutputLocation{SecretName: "new", SecretNamespace: "ns", SecretKey: "key"}
+
+	if err := r.cleanupStaleOutput(context.Background(), zap.New(), "default/cfg", previous, current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deleted == nil || deleted.Name != "old" {
+		t.Errorf("deleted = %v, want ns/old", deleted)
+	}
+}
+
+func TestCleanupStaleOutput_KeepsPreExistingSecret(t *testing.T) {
+	var written *corev1.Secret
+	mock := &mockKubeClient{
+		secretsByName: map[string]*corev1.Secret{
+			"ns/old": {
+				ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "ns"},
+				Data:       map[string][]byte{"key": []byte("a")},
+			},
+		},
+		patchFn: func(_ context.Context, _ *corev1.Secret, secret *corev1.Secret) error {
+			written = secret
+			return nil
+		},
+		deleteFn: func(_ context.Context, _ *corev1.Secret) error {
+			t.Error("unexpected secret deletion")
+			return nil
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	previous := &prometheusv1.OutputLocation{SecretName: "old", SecretNamespace: "ns", SecretKey: "key"}
+	current := &prometheusv1.OutputLocation{SecretName: "new", SecretNamespace: "ns", SecretKey: "key"}
+
+	if err := r.cleanupStaleOutput(context.Background(), zap.New(), "default/cfg", previous, current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written == nil || len(written.Data) != 0 {
+		t.Errorf("expected the key to be removed from the pre-existing secret, got %v", written)
+	}
+}
+
+func TestCleanupStaleOutput_MissingSecret(t *testing.T) {
+	mock := &mockKubeClient{
+		deleteFn: func(_ context.Context, _ *corev1.Secret) error {
+			t.Error("unexpected secret deletion")
+			return nil
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	previous := &prometheusv1.OutputLocation{SecretName: "old", SecretNamespace: "ns", SecretKey: "key", SecretCreated: true}
+	current := &prometheusv1.OutputLocation{SecretName: "new", SecretNamespace: "ns", SecretKey: "key"}
+
+	if err := r.cleanupStaleOutput(context.Background(), zap.New(), "default/cfg", previous, current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCleanupStaleOutput_SkipsKeyOwnedByOtherConfig(t *testing.T) {
+	mock := &mockKubeClient{
+		secretsByName: map[string]*corev1.Secret{
+			"ns/s": {
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "s",
+					Namespace:   "ns",
+					Annotations: map[string]string{secretOwnersAnnotation: `{"old":"other/cfg"}`},
+				},
+				Data: map[string][]byte{"old": []byte("a")},
+			},
+		},
+		patchFn: func(_ context.Context, _ *corev1.Secret, _ *corev1.Secret) error {
+			t.Error("unexpected secret write")
+			return nil
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	previous := &prometheusv1.OutputLocation{SecretName: "s", SecretNamespace: "ns", SecretKey: "old"}
+	current := &prometheusv1.OutputLocation{SecretName: "s", SecretNamespace: "ns", SecretKey: "new"}
+
+	if err := r.cleanupStaleOutput(context.Background(), zap.New(), "default/cfg", previous, current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestCleanupStaleOutput_RemovesOwner(t *testing.T) {
+	var written *corev1.Secret
+	mock := &mockKubeClient{
+		secretsByName: map[string]*corev1.Secret{
+			"ns/s": {
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "s",
+					Namespace:   "ns",
+					Annotations: map[string]string{secretOwnersAnnotation: `{"old":"default/cfg","other":"other/cfg"}`},
+				},
+				Data: map[string][]byte{"old": []byte("a"), "other": []byte("b")},
+			},
+		},
+		patchFn: func(_ context.Context, _ *corev1.Secret, secret *corev1.Secret) error {
+			written = secret
+			return nil
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	previous := &prometheusv1.OutputLocation{SecretName: "s", SecretNamespace: "ns", SecretKey: "old"}
+	current := &prometheusv1.OutputLocation{SecretName: "s", SecretNamespace: "ns", SecretKey: "new"}
+
+	if err := r.cleanupStaleOutput(context.Background(), zap.New(), "default/cfg", previous, current); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written == nil {
+		t.Fatal("expected the secret to be updated")
+	}
+	if got := written.Annotations[secretOwnersAnnotation]; got != `{"other":"other/cfg"}` {
+		t.Errorf("owners annotation = %s, want only other/cfg", got)
+	}
+}
+
+// --- CacheOptions tests ---
+
+func TestCacheOptions_OnlySelectsManagedSecrets(t *testing.T) {
+	options := CacheOptions()
+
+	var selector labels.Selector
+	for object, byObject := range options.ByObject {
+		if _, ok := object.(*corev1.Secret); ok {
+			selector = byObject.Label
+		}
+	}
+	if selector == nil {
+		t.Fatal("expected a label selector for secrets")
+	}
+	if !selector.Matches(labels.Set{managedSecretLabel: "true"}) {
+		t.Error("expected managed secrets to be selected")
+	}
+	if selector.Matches(labels.Set{"other": "true"}) {
+		t.Error("expected unmanaged secrets not to be selected")
+	}
+}
+
+// --- rate limiter tests ---
+
+func TestNewRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(time.Second, time.Minute, 100, 100)
+	request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "cfg"}}
+
+	if got := limiter.When(request); got != time.Second {
+		t.Errorf("first delay = %v, want 1s", got)
+	}
+	if got := limiter.When(request); got != 2*time.Second {
+		t.Errorf("second delay = %v, want 2s", got)
+	}
+	limiter.Forget(request)
+	if got := limiter.When(request); got != time.Second {
+		t.Errorf("delay after forget = %v, want 1s", got)
+	}
+}
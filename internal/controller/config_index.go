@@ -0,0 +1,208 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sort"
+	"sync"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+type configKeySet map[types.NamespacedName]struct{}
+
+func (s configKeySet) add(key types.NamespacedName) {
+	s[key] = struct{}{}
+}
+
+// configSelectorIndex answers which AdditionalScrapeConfigs select a
+// ScrapeJob without going through every config. It is kept up to date from
+// the AdditionalScrapeConfig informer.
+//
+// Each config is indexed under a single one of its required labels, as a job
+// has to carry all of them to match. Looking up a job only checks the configs
+// indexed under one of the job's own labels, plus the configs without labels,
+// which select every job.
+type configSelectorIndex struct {
+	mu sync.RWMutex
+	// configs holds the last seen version of every config.
+	configs map[types.NamespacedName]*prometheusv1.AdditionalScrapeConfig
+	// byLabel maps a key=value pair to the configs indexed under it.
+	byLabel map[string]configKeySet
+	// selectAll holds the configs with an empty label set.
+	selectAll configKeySet
+	// byDiscoveredJob maps the namespace/name of a ScrapeJob to the configs
+	// listing it in their status.
+	byDiscoveredJob map[string]configKeySet
+}
+
+func newConfigSelectorIndex() *configSelectorIndex {
+	return &configSelectorIndex{
+		configs:         make(map[types.NamespacedName]*prometheusv1.AdditionalScrapeConfig),
+		byLabel:         make(map[string]configKeySet),
+		selectAll:       make(configKeySet),
+		byDiscoveredJob: make(map[string]configKeySet),
+	}
+}
+
+// getIndexedLabel returns the label pair the config is indexed under. The
+// smallest key is used so the choice is stable between updates.
+func getIndexedLabel(config *prometheusv1.AdditionalScrapeConfig) (string, bool) {
+	if len(config.Spec.ScrapeJobLabels) == 0 {
+		return "", false
+	}
+
+	keys := make([]string, 0, len(config.Spec.ScrapeJobLabels))
+	for key := range config.Spec.ScrapeJobLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return getLabelIndexKey(keys[0], config.Spec.ScrapeJobLabels[keys[0]]), true
+}
+
+func getLabelIndexKey(key string, value string) string {
+	return key + "=" + value
+}
+
+// upsert adds the config to the index, replacing its previous version.
+func (i *configSelectorIndex) upsert(config *prometheusv1.AdditionalScrapeConfig) {
+	key := client.ObjectKeyFromObject(config)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.removeLocked(key)
+
+	i.configs[key] = config
+	if label, ok := getIndexedLabel(config); ok {
+		if _, exists := i.byLabel[label]; !exists {
+			i.byLabel[label] = make(configKeySet)
+		}
+		i.byLabel[label].add(key)
+	} else {
+		i.selectAll.add(key)
+	}
+
+	for _, job := range config.Status.DiscoveredScrapeJobs {
+		if _, exists := i.byDiscoveredJob[job]; !exists {
+			i.byDiscoveredJob[job] = make(configKeySet)
+		}
+		i.byDiscoveredJob[job].add(key)
+	}
+}
+
+// remove drops the config from the index.
+func (i *configSelectorIndex) remove(key types.NamespacedName) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.removeLocked(key)
+}
+
+func (i *configSelectorIndex) removeLocked(key types.NamespacedName) {
+	config, ok := i.configs[key]
+	if !ok {
+		return
+	}
+
+	delete(i.configs, key)
+	if label, ok := getIndexedLabel(config); ok {
+		delete(i.byLabel[label], key)
+		if len(i.byLabel[label]) == 0 {
+			delete(i.byLabel, label)
+		}
+	} else {
+		delete(i.selectAll, key)
+	}
+
+	for _, job := range config.Status.DiscoveredScrapeJobs {
+		delete(i.byDiscoveredJob[job], key)
+		if len(i.byDiscoveredJob[job]) == 0 {
+			delete(i.byDiscoveredJob, job)
+		}
+	}
+}
+
+// find returns the configs selecting any of the given versions of a
+// ScrapeJob. Configs listing the job in their status are only included if
+// includeDiscovered is set.
+func (i *configSelectorIndex) find(includeDiscovered bool, targets ...client.Object) []reconcile.Request {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	matched := make(configKeySet)
+	checkCandidates := func(candidates configKeySet, target client.Object) {
+		for key := range candidates {
+			if _, done := matched[key]; done {
+				continue
+			}
+			if configSelectsJob(i.configs[key], target) {
+				matched.add(key)
+			}
+		}
+	}
+
+	for _, target := range targets {
+		checkCandidates(i.selectAll, target)
+		for key, value := range target.GetLabels() {
+			checkCandidates(i.byLabel[getLabelIndexKey(key, value)], target)
+		}
+
+		if includeDiscovered {
+			for key := range i.byDiscoveredJob[getScrapeJobName(target)] {
+				matched.add(key)
+			}
+		}
+	}
+
+	requests := make([]reconcile.Request, 0, len(matched))
+	for key := range matched {
+		requests = append(requests, reconcile.Request{NamespacedName: key})
+	}
+
+	return requests
+}
+
+// eventHandler returns the informer event handler keeping the index in sync
+// with the cached configs.
+func (i *configSelectorIndex) eventHandler() toolscache.ResourceEventHandler {
+	return toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if config, ok := obj.(*prometheusv1.AdditionalScrapeConfig); ok {
+				i.upsert(config)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if config, ok := newObj.(*prometheusv1.AdditionalScrapeConfig); ok {
+				i.upsert(config)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if config, ok := obj.(*prometheusv1.AdditionalScrapeConfig); ok {
+				i.remove(client.ObjectKeyFromObject(config))
+			}
+		},
+	}
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/kubernetes"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newIndexTestConfig(name string, labels map[string]string, discovered ...string) *prometheusv1.AdditionalScrapeConfig {
+	return &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: prometheusv1.AdditionalScrapeConfigSpec{
+			ScrapeJobLabels:            labels,
+			ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{Any: true},
+		},
+		Status: prometheusv1.AdditionalScrapeConfigStatus{DiscoveredScrapeJobs: discovered},
+	}
+}
+
+func getRequestNames(requests []reconcile.Request) []string {
+	names := make([]string, 0, len(requests))
+	for _, request := range requests {
+		names = append(names, request.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func assertRequestNames(t *testing.T, requests []reconcile.Request, want ...string) {
+	t.Helper()
+	got := getRequestNames(requests)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("requests = %v, want %v", got, want)
+	}
+}
+
+func TestConfigSelectorIndex_Find(t *testing.T) {
+	index := newConfigSelectorIndex()
+	index.upsert(newIndexTestConfig("web", map[string]string{"app": "web"}))
+	index.upsert(newIndexTestConfig("web-prod", map[string]string{"app": "web", "env": "prod"}))
+	index.upsert(newIndexTestConfig("all", nil))
+	restricted := newIndexTestConfig("restricted", map[string]string{"app": "web"})
+	restricted.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"other"}}
+	index.upsert(restricted)
+
+	assertRequestNames(t, index.find(false, newHandlerTestJob(map[string]string{"app": "web"})), "all", "web")
+	assertRequestNames(t, index.find(false, newHandlerTestJob(map[string]string{"app": "web", "env": "prod"})), "all", "web", "web-prod")
+	assertRequestNames(t, index.find(false, newHandlerTestJob(nil)), "all")
+}
+
+func TestConfigSelectorIndex_UpsertReplacesPreviousVersion(t *testing.T) {
+	index := newConfigSelectorIndex()
+	index.upsert(newIndexTestConfig("cfg", map[string]string{"app": "web"}))
+	index.upsert(newIndexTestConfig("cfg", map[string]string{"app": "api"}))
+
+	assertRequestNames(t, index.find(false, newHandlerTestJob(map[string]string{"app": "web"})))
+	assertRequestNames(t, index.find(false, newHandlerTestJob(map[string]string{"app": "api"})), "cfg")
+	if len(index.byLabel) != 1 {
+		t.Errorf("byLabel = %v, want only the current label", index.byLabel)
+	}
+}
+
+func TestConfigSelectorIndex_DiscoveredJobs(t *testing.T) {
+	index := newConfigSelectorIndex()
+	index.upsert(newIndexTestConfig("cfg", map[string]string{"app": "api"}, "ns1/j1"))
+	job := newHandlerTestJob(map[string]string{"app": "web"})
+
+	assertRequestNames(t, index.find(false, job))
+	assertRequestNames(t, index.find(true, job), "cfg")
+
+	index.upsert(newIndexTestConfig("cfg", map[string]string{"app": "api"}))
+	assertRequestNames(t, index.find(true, job))
+}
+
+func TestConfigSelectorIndex_EventHandler(t *testing.T) {
+	index := newConfigSelectorIndex()
+	h := index.eventHandler()
+	job := newHandlerTestJob(map[string]string{"app": "web"})
+
+	h.OnAdd(newIndexTestConfig("cfg", map[string]string{"app": "web"}), false)
+	assertRequestNames(t, index.find(false, job), "cfg")
+
+	h.OnUpdate(newIndexTestConfig("cfg", map[string]string{"app": "web"}), newIndexTestConfig("cfg", map[string]string{"app": "api"}))
+	assertRequestNames(t, index.find(false, job))
+
+	h.OnAdd(newIndexTestConfig("other", map[string]string{"app": "web"}), false)
+	h.OnDelete(toolscache.DeletedFinalStateUnknown{Key: "default/other", Obj: newIndexTestConfig("other", map[string]string{"app": "web"})})
+	assertRequestNames(t, index.find(false, job))
+	if len(index.configs) != 1 {
+		t.Errorf("configs = %d, want 1", len(index.configs))
+	}
+}
+
+func TestFindConfigsForJobs_UsesIndex(t *testing.T) {
+	index := newConfigSelectorIndex()
+	index.upsert(newIndexTestConfig("cfg", map[string]string{"app": "web"}))
+	r := &AdditionalScrapeConfigReconciler{
+		KubeClient:  &mockKubeClient{err: fmt.Errorf("the index should be used")},
+		configIndex: index,
+	}
+
+	assertRequestNames(t, r.findConfigsForJobs(context.Background(), false, newHandlerTestJob(map[string]string{"app": "web"})), "cfg")
+}
+
+// --- benchmarks ---
+
+const benchmarkConfigCount = 2000
+
+func newBenchmarkConfigs() []client.Object {
+	configs := make([]client.Object, 0, benchmarkConfigCount)
+	for i := range benchmarkConfigCount {
+		configs = append(configs, newIndexTestConfig(fmt.Sprintf("cfg-%d", i), map[string]string{
+			"app":  fmt.Sprintf("app-%d", i),
+			"team": fmt.Sprintf("team-%d", i%10),
+		}))
+	}
+	return configs
+}
+
+func newBenchmarkJob() *prometheusv1.ScrapeJob {
+	return newHandlerTestJob(map[string]string{"app": "app-42", "team": "team-2"})
+}
+
+func BenchmarkFindConfigsForJobs_List(b *testing.B) {
+	scheme := runtime.NewScheme()
+	_ = prometheusv1.AddToScheme(scheme)
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(newBenchmarkConfigs()...).Build()
+	r := &AdditionalScrapeConfigReconciler{KubeClient: kubernetes.NewClient(fakeClient, fakeClient)}
+	job := newBenchmarkJob()
+
+	for b.Loop() {
+		if len(r.findConfigsForJobs(context.Background(), true, job, job)) != 1 {
+			b.Fatal("expected a single config")
+		}
+	}
+}
+
+func BenchmarkFindConfigsForJobs_Index(b *testing.B) {
+	index := newConfigSelectorIndex()
+	for _, config := range newBenchmarkConfigs() {
+		index.upsert(config.(*prometheusv1.AdditionalScrapeConfig))
+	}
+	r := &AdditionalScrapeConfigReconciler{configIndex: index}
+	job := newBenchmarkJob()
+
+	for b.Loop() {
+		if len(r.findConfigsForJobs(context.Background(), true, job, job)) != 1 {
+			b.Fatal("expected a single config")
+		}
+	}
+}
@@ -3,10 +3,12 @@ package controller
 import (
 	"fmt"
 	gomegaTypes "github.com/onsi/gomega/types"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/kubernetes"
 	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
 	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"time"
@@ -52,6 +54,10 @@ var _ = Describe("Additional Scrape Config controller", func() {
 		}
 	}
 
+	adoptExistingSecret := func(config *prometheusv1.AdditionalScrapeConfig) {
+		config.Spec.AdoptExistingSecret = true
+	}
+
 	configLookupKey := types.NamespacedName{Name: ConfigName, Namespace: ConfigNamespace}
 	secretLookupKey := types.NamespacedName{Name: SecretName, Namespace: SecretNamespace}
 
@@ -98,7 +104,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 			JobName: "test1",
 			StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{
 				{
-					Targets: []string{"http://test1"},
+					Targets: []string{"test1:80"},
 					Labels:  map[string]string{"job": "test1"},
 				},
 			},
@@ -107,7 +113,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 			JobName: "test2",
 			StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{
 				{
-					Targets: []string{"http://test2"},
+					Targets: []string{"test2:80"},
 					Labels:  map[string]string{"job": "test2"},
 				},
 			},
@@ -116,7 +122,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 			JobName: "different-namespace",
 			StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{
 				{
-					Targets: []string{"http://different-namespace"},
+					Targets: []string{"different-namespace:80"},
 					Labels:  map[string]string{"job": "different-namespace"},
 				},
 			},
@@ -125,14 +131,17 @@ var _ = Describe("Additional Scrape Config controller", func() {
 			JobName: "invalid",
 			StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{
 				{
-					Targets: []string{"http://invalid"},
+					Targets: []string{"invalid:80"},
 					Labels:  map[string]string{"job": "invalid"},
 				},
 			},
 		})
 	}
-	createConfig := func() *prometheusv1.AdditionalScrapeConfig {
+	createConfig := func(modifiers ...func(config *prometheusv1.AdditionalScrapeConfig)) *prometheusv1.AdditionalScrapeConfig {
 		config := getConfig()
+		for _, modifier := range modifiers {
+			modifier(&config)
+		}
 		Expect(k8sClient.Create(ctx, &config)).Should(Succeed())
 		createdConfig := &prometheusv1.AdditionalScrapeConfig{}
 
@@ -225,9 +234,24 @@ var _ = Describe("Additional Scrape Config controller", func() {
 				return createdConfig.Status.DiscoveredScrapeJobs, nil
 			}).Should(Equal([]string{"test1/valid-1", "test2/valid-2"}))
 		})
+		It("Should record the last change in the status", func() {
+			createdConfig := createConfig()
+
+			Eventually(func() (*prometheusv1.ConfigChange, error) {
+				if err := k8sClient.Get(ctx, configLookupKey, createdConfig); nil != err {
+					return nil, err
+				}
+
+				return createdConfig.Status.LastChange, nil
+			}, timeout, interval).Should(And(
+				Not(BeNil()),
+				HaveField("Summary", "2 jobs added"),
+				HaveField("Details", Equal([]string{"added job test1", "added job test2"})),
+			))
+		})
 		It("Should update the existing secret overwriting the key", func() {
 			createSecret(map[string][]byte{"otherKey": []byte("test"), SecretKey: []byte("test2")})
-			createConfig()
+			createConfig(adoptExistingSecret)
 
 			secret := &v1.Secret{}
 
@@ -241,7 +265,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 		})
 		It("Should update the existing secret", func() {
 			createSecret(nil)
-			createConfig()
+			createConfig(adoptExistingSecret)
 
 			secret := &v1.Secret{}
 
@@ -313,7 +337,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 			deletableJob = createJob("deletable-job", "test1", validJobLabels, prometheusv1.ScrapeJobSpec{
 				JobName: "deletable",
 				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{
-					{Targets: []string{"http://deletable"}, Labels: map[string]string{"job": "deletable"}},
+					{Targets: []string{"deletable:80"}, Labels: map[string]string{"job": "deletable"}},
 				},
 			})
 			createConfig()
@@ -377,7 +401,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 			// Update the job
 			job := &prometheusv1.ScrapeJob{}
 			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: "test1", Name: "valid-1"}, job)).Should(Succeed())
-			job.Spec.StaticConfigs[0].Targets = []string{"http://updated-target"}
+			job.Spec.StaticConfigs[0].Targets = []string{"updated-target:80"}
 			Expect(k8sClient.Update(ctx, job)).Should(Succeed())
 
 			// Verify the secret reflects the update
@@ -387,7 +411,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 					return ""
 				}
 				return string(secret.Data[SecretKey])
-			}, timeout, interval).Should(ContainSubstring("http://updated-target"))
+			}, timeout, interval).Should(ContainSubstring("updated-target:80"))
 		})
 	})
 
@@ -420,6 +444,34 @@ var _ = Describe("Additional Scrape Config controller", func() {
 				return string(secret.Data[SecretKey]) != "tampered" && len(secret.Data[SecretKey]) > 0
 			}, timeout, interval).Should(BeTrue())
 		})
+
+		It("Should keep the fields added by other tools", func() {
+			secret := &v1.Secret{}
+			Expect(k8sClient.Get(ctx, secretLookupKey, secret)).Should(Succeed())
+
+			// Another tool adds a label and tampers with the managed key
+			secret.Labels["other-tool"] = "true"
+			secret.Data[SecretKey] = []byte("tampered")
+			Expect(k8sClient.Update(ctx, secret)).Should(Succeed())
+
+			Eventually(func() bool {
+				if err := k8sClient.Get(ctx, secretLookupKey, secret); err != nil {
+					return false
+				}
+				return string(secret.Data[SecretKey]) != "tampered" && len(secret.Data[SecretKey]) > 0
+			}, timeout, interval).Should(BeTrue())
+
+			Expect(secret.Labels).To(HaveKeyWithValue("other-tool", "true"))
+			Expect(secret.Labels).To(HaveKeyWithValue(managedSecretLabel, "true"))
+
+			var appliedManagers []string
+			for _, entry := range secret.ManagedFields {
+				if entry.Operation == metav1.ManagedFieldsOperationApply {
+					appliedManagers = append(appliedManagers, entry.Manager)
+				}
+			}
+			Expect(appliedManagers).To(ConsistOf(kubernetes.FieldManager))
+		})
 	})
 
 	Context("When a ScrapeJob's labels change to no longer match", Ordered, func() {
@@ -429,7 +481,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 			mutableJob = createJob("mutable-job", "test1", validJobLabels, prometheusv1.ScrapeJobSpec{
 				JobName: "mutable",
 				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{
-					{Targets: []string{"http://mutable"}, Labels: map[string]string{"job": "mutable"}},
+					{Targets: []string{"mutable:80"}, Labels: map[string]string{"job": "mutable"}},
 				},
 			})
 			createConfig()
@@ -511,6 +563,95 @@ var _ = Describe("Additional Scrape Config controller", func() {
 		})
 	})
 
+	Context("When ScrapeJobLabels is empty", Ordered, func() {
+		var unlabelledJob *prometheusv1.ScrapeJob
+
+		BeforeAll(func() {
+			createConfig(func(config *prometheusv1.AdditionalScrapeConfig) {
+				config.Spec.ScrapeJobLabels = nil
+			})
+		})
+
+		AfterAll(func() {
+			deleteConfigAndSecret()
+			if unlabelledJob != nil {
+				_ = k8sClient.Delete(ctx, unlabelledJob)
+			}
+		})
+
+		It("Should discover every job in the matched namespaces", func() {
+			createdConfig := &prometheusv1.AdditionalScrapeConfig{}
+			Eventually(func() ([]string, error) {
+				if err := k8sClient.Get(ctx, configLookupKey, createdConfig); err != nil {
+					return nil, err
+				}
+				return createdConfig.Status.DiscoveredScrapeJobs, nil
+			}, timeout, interval).Should(And(
+				ContainElement("test1/valid-1"),
+				ContainElement("test1/invalid"),
+				Not(ContainElement("test3/different-namespace")),
+			))
+		})
+
+		It("Should pick up new jobs without labels", func() {
+			unlabelledJob = createJob("unlabelled", "test2", nil, prometheusv1.ScrapeJobSpec{
+				JobName: "unlabelled",
+				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{
+					{Targets: []string{"unlabelled:80"}},
+				},
+			})
+
+			createdConfig := &prometheusv1.AdditionalScrapeConfig{}
+			Eventually(func() ([]string, error) {
+				if err := k8sClient.Get(ctx, configLookupKey, createdConfig); err != nil {
+					return nil, err
+				}
+				return createdConfig.Status.DiscoveredScrapeJobs, nil
+			}, timeout, interval).Should(ContainElement("test2/unlabelled"))
+		})
+	})
+
+	Context("When the config is paused", Ordered, func() {
+		BeforeAll(func() {
+			createConfig(func(config *prometheusv1.AdditionalScrapeConfig) {
+				config.Spec.Paused = true
+			})
+		})
+
+		AfterAll(func() {
+			deleteConfigAndSecret()
+		})
+
+		It("Should set the paused condition and not write the secret", func() {
+			Eventually(func() *metav1.Condition {
+				config := &prometheusv1.AdditionalScrapeConfig{}
+				if err := k8sClient.Get(ctx, configLookupKey, config); err != nil {
+					return nil
+				}
+				return meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypePaused)
+			}, timeout, interval).Should(And(
+				Not(BeNil()),
+				HaveField("Status", metav1.ConditionTrue),
+				HaveField("Reason", prometheusv1.ReasonPausedBySpec),
+			))
+
+			Consistently(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, secretLookupKey, &v1.Secret{}))
+			}, time.Second, interval).Should(BeTrue())
+		})
+
+		It("Should write the secret once resumed", func() {
+			config := &prometheusv1.AdditionalScrapeConfig{}
+			Expect(k8sClient.Get(ctx, configLookupKey, config)).Should(Succeed())
+			config.Spec.Paused = false
+			Expect(k8sClient.Update(ctx, config)).Should(Succeed())
+
+			Eventually(func() error {
+				return k8sClient.Get(ctx, secretLookupKey, &v1.Secret{})
+			}, timeout, interval).Should(Succeed())
+		})
+	})
+
 	Context("When NamespaceSelector has no MatchNames", Ordered, func() {
 		AfterAll(func() {
 			deleteConfigAndSecret()
@@ -521,7 +662,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 			ownNsJob := createJob("own-ns-job", ConfigNamespace, validJobLabels, prometheusv1.ScrapeJobSpec{
 				JobName: "own-ns",
 				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{
-					{Targets: []string{"http://own-ns"}, Labels: map[string]string{"job": "own-ns"}},
+					{Targets: []string{"own-ns:80"}, Labels: map[string]string{"job": "own-ns"}},
 				},
 			})
 
@@ -556,6 +697,187 @@ var _ = Describe("Additional Scrape Config controller", func() {
 		})
 	})
 
+	Context("When the output secret key changes", Ordered, func() {
+		AfterAll(func() {
+			deleteConfigAndSecret()
+		})
+
+		It("Should remove the stale key from the secret", func() {
+			createConfig()
+
+			secret := &v1.Secret{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, secretLookupKey, secret)
+				return err == nil && len(secret.Data[SecretKey]) > 0
+			}, timeout, interval).Should(BeTrue())
+
+			Eventually(func() error {
+				config := &prometheusv1.AdditionalScrapeConfig{}
+				if err := k8sClient.Get(ctx, configLookupKey, config); err != nil {
+					return err
+				}
+				config.Spec.SecretKey = "moved"
+				return k8sClient.Update(ctx, config)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(func() (map[string][]byte, error) {
+				if err := k8sClient.Get(ctx, secretLookupKey, secret); nil != err {
+					return nil, err
+				}
+
+				return secret.Data, nil
+			}, timeout, interval).Should(matchSecretData("moved", getPrometheusData(), map[string][]byte{}))
+
+			createdConfig := &prometheusv1.AdditionalScrapeConfig{}
+			Eventually(func() *prometheusv1.OutputLocation {
+				if err := k8sClient.Get(ctx, configLookupKey, createdConfig); err != nil {
+					return nil
+				}
+				return createdConfig.Status.LastOutput
+			}, timeout, interval).Should(Equal(&prometheusv1.OutputLocation{
+				SecretName:      SecretName,
+				SecretNamespace: SecretNamespace,
+				SecretKey:       "moved",
+				SecretCreated:   true,
+			}))
+		})
+	})
+
+	Context("When the output secret name changes", Ordered, func() {
+		movedSecretLookupKey := types.NamespacedName{Name: "moved-secret", Namespace: SecretNamespace}
+
+		AfterAll(func() {
+			deleteConfigAndSecret()
+			secret := &v1.Secret{}
+			if err := k8sClient.Get(ctx, movedSecretLookupKey, secret); err == nil {
+				Expect(k8sClient.Delete(ctx, secret)).Should(Succeed())
+			}
+		})
+
+		It("Should delete the previous secret created by the operator", func() {
+			createConfig()
+
+			secret := &v1.Secret{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, secretLookupKey, secret)
+				return err == nil && len(secret.Data[SecretKey]) > 0
+			}, timeout, interval).Should(BeTrue())
+
+			Eventually(func() error {
+				config := &prometheusv1.AdditionalScrapeConfig{}
+				if err := k8sClient.Get(ctx, configLookupKey, config); err != nil {
+					return err
+				}
+				config.Spec.SecretName = movedSecretLookupKey.Name
+				return k8sClient.Update(ctx, config)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, movedSecretLookupKey, secret)
+				return err == nil && len(secret.Data[SecretKey]) > 0
+			}, timeout, interval).Should(BeTrue())
+
+			Eventually(func() bool {
+				return apierrors.IsNotFound(k8sClient.Get(ctx, secretLookupKey, &v1.Secret{}))
+			}, timeout, interval).Should(BeTrue())
+		})
+
+		It("Should only remove the key from a pre-existing secret", func() {
+			deleteConfigAndSecret()
+			createSecret(map[string][]byte{"otherKey": []byte("test")})
+			createConfig(adoptExistingSecret)
+
+			secret := &v1.Secret{}
+			Eventually(func() bool {
+				err := k8sClient.Get(ctx, secretLookupKey, secret)
+				return err == nil && len(secret.Data[SecretKey]) > 0
+			}, timeout, interval).Should(BeTrue())
+
+			Eventually(func() error {
+				config := &prometheusv1.AdditionalScrapeConfig{}
+				if err := k8sClient.Get(ctx, configLookupKey, config); err != nil {
+					return err
+				}
+				config.Spec.SecretName = movedSecretLookupKey.Name
+				return k8sClient.Update(ctx, config)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(func() (map[string][]byte, error) {
+				if err := k8sClient.Get(ctx, secretLookupKey, secret); nil != err {
+					return nil, err
+				}
+
+				return secret.Data, nil
+			}, timeout, interval).Should(Equal(map[string][]byte{"otherKey": []byte("test")}))
+		})
+	})
+
+	Context("When the secret is owned by something else", Ordered, func() {
+		otherConfigLookupKey := types.NamespacedName{Name: "other-config", Namespace: ConfigNamespace}
+
+		AfterAll(func() {
+			config := &prometheusv1.AdditionalScrapeConfig{}
+			if err := k8sClient.Get(ctx, otherConfigLookupKey, config); err == nil {
+				Expect(k8sClient.Delete(ctx, config)).Should(Succeed())
+			}
+			deleteConfigAndSecret()
+		})
+
+		getConflictCondition := func(lookupKey types.NamespacedName) *metav1.Condition {
+			config := &prometheusv1.AdditionalScrapeConfig{}
+			if err := k8sClient.Get(ctx, lookupKey, config); err != nil {
+				return nil
+			}
+			return meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypeSecretConflict)
+		}
+
+		It("Should refuse to write an unmanaged secret", func() {
+			createSecret(map[string][]byte{"otherKey": []byte("test")})
+			createConfig()
+
+			Eventually(func() string {
+				condition := getConflictCondition(configLookupKey)
+				if condition == nil || condition.Status != metav1.ConditionTrue {
+					return ""
+				}
+				return condition.Reason
+			}, timeout, interval).Should(Equal(prometheusv1.ReasonUnmanagedSecret))
+
+			secret := &v1.Secret{}
+			Expect(k8sClient.Get(ctx, secretLookupKey, secret)).Should(Succeed())
+			Expect(secret.Data).To(Equal(map[string][]byte{"otherKey": []byte("test")}))
+		})
+
+		It("Should refuse to write a key owned by another config", func() {
+			deleteConfigAndSecret()
+			createConfig()
+
+			secret := &v1.Secret{}
+			Eventually(func() string {
+				if err := k8sClient.Get(ctx, secretLookupKey, secret); err != nil {
+					return ""
+				}
+				return secret.Annotations[secretOwnersAnnotation]
+			}, timeout, interval).Should(Equal(fmt.Sprintf(`{"%s":"%s/%s"}`, SecretKey, ConfigNamespace, ConfigName)))
+
+			otherConfig := getConfig()
+			otherConfig.Name = otherConfigLookupKey.Name
+			otherConfig.Spec.ScrapeJobLabels = invalidJobLabels
+			Expect(k8sClient.Create(ctx, &otherConfig)).Should(Succeed())
+
+			Eventually(func() string {
+				condition := getConflictCondition(otherConfigLookupKey)
+				if condition == nil || condition.Status != metav1.ConditionTrue {
+					return ""
+				}
+				return condition.Reason
+			}, timeout, interval).Should(Equal(prometheusv1.ReasonKeyOwnedByOtherConfig))
+
+			Expect(k8sClient.Get(ctx, secretLookupKey, secret)).Should(Succeed())
+			Expect(secret.Data).To(matchSecretData(SecretKey, getPrometheusData(), map[string][]byte{}))
+		})
+	})
+
 	Context("Finalizer lifecycle", Ordered, func() {
 		AfterAll(func() {
 			deleteConfigAndSecret()
@@ -589,6 +911,7 @@ var _ = Describe("Additional Scrape Config controller", func() {
 			Expect(gaugeLabelSetExists(discoveredJobsGauge, gaugeLabels)).To(BeFalse())
 			Expect(gaugeLabelSetExists(filteredJobsGauge, gaugeLabels)).To(BeFalse())
 			Expect(gaugeLabelSetExists(scrapeJobsLoadedGauge, gaugeLabels)).To(BeFalse())
+			Expect(gaugeLabelSetExists(secretConflictGauge, gaugeLabels)).To(BeFalse())
 		})
 	})
 })
@@ -0,0 +1,73 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// newUnmanagedSecretSource returns a source of the secrets not managed by the
+// operator, which are left out of the secret cache of the manager. They hold
+// the credentials of the Prometheus endpoints, so their changes requeue the
+// configs referencing them. Only their metadata is cached, in a cache of its
+// own, which has to be added to the manager.
+func (r *AdditionalScrapeConfigReconciler) newUnmanagedSecretSource(mgr ctrl.Manager) (cache.Cache, source.Source, error) {
+	unmanaged, err := labels.NewRequirement(managedSecretLabel, selection.NotEquals, []string{"true"})
+	if nil != err {
+		return nil, nil, err
+	}
+
+	options := cache.Options{
+		Scheme:               mgr.GetScheme(),
+		Mapper:               mgr.GetRESTMapper(),
+		DefaultLabelSelector: labels.NewSelector().Add(*unmanaged),
+	}
+	if len(r.WatchNamespaces) > 0 {
+		options.DefaultNamespaces = make(map[string]cache.Config)
+		for _, namespace := range r.WatchNamespaces {
+			options.DefaultNamespaces[namespace] = cache.Config{}
+		}
+	}
+
+	secretCache, err := cache.New(mgr.GetConfig(), options)
+	if nil != err {
+		return nil, nil, err
+	}
+
+	secret := &metav1.PartialObjectMetadata{}
+	secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+
+	return secretCache, source.Kind(
+		secretCache,
+		secret,
+		handler.TypedEnqueueRequestsFromMapFunc(func(ctx context.Context, secret *metav1.PartialObjectMetadata) []reconcile.Request {
+			return r.findConfigsForSecret(ctx, secret)
+		}),
+		predicate.TypedResourceVersionChangedPredicate[*metav1.PartialObjectMetadata]{},
+	), nil
+}
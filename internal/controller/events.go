@@ -0,0 +1,86 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// eventRecorderName is the reporting controller of the emitted events.
+const eventRecorderName = "prometheus-static-target"
+
+// maxEventNoteLength is the maximum length of an event note accepted by the
+// API server.
+const maxEventNoteLength = 1024
+
+// Event reasons
+const (
+	eventReasonSecretUpdated              = "SecretUpdated"
+	eventReasonLoadFailed                 = "LoadFailed"
+	eventReasonWriteFailed                = "WriteFailed"
+	eventReasonSecretConflict             = "SecretConflict"
+	eventReasonInvalidScrapeJob           = "InvalidScrapeJob"
+	eventReasonDuplicateJobName           = "DuplicateJobName"
+	eventReasonScrapeJobExpired           = "ScrapeJobExpired"
+	eventReasonInvalidOutput              = "InvalidOutput"
+	eventReasonReloaded                   = "Reloaded"
+	eventReasonReloadFailed               = "ReloadFailed"
+	eventReasonWorkloadRolledOut          = "WorkloadRolledOut"
+	eventReasonPrometheusWired            = "PrometheusWired"
+	eventReasonPrometheusUnwired          = "PrometheusUnwired"
+	eventReasonTemplateNotFound           = "TemplateNotFound"
+	eventReasonClusterTemplateUnavailable = "ClusterTemplateUnavailable"
+)
+
+// Event actions
+const (
+	eventActionLoad    = "Load"
+	eventActionRender  = "Render"
+	eventActionWrite   = "Write"
+	eventActionDelete  = "Delete"
+	eventActionReload  = "Reload"
+	eventActionRollout = "Rollout"
+	eventActionWire    = "Wire"
+)
+
+// recordEvent emits an event about the regarding object. Notes over the
+// length limit are truncated. Nothing is emitted if the reconciler has no
+// recorder set up.
+func (r *AdditionalScrapeConfigReconciler) recordEvent(regarding runtime.Object, related runtime.Object, eventType string, reason string, action string, note string, args ...interface{}) {
+	if nil == r.Recorder {
+		return
+	}
+
+	message := fmt.Sprintf(note, args...)
+	if len(message) > maxEventNoteLength {
+		message = message[:maxEventNoteLength-3] + "..."
+	}
+
+	r.Recorder.Eventf(regarding, related, eventType, reason, action, "%s", message)
+}
+
+// recordScrapeJobWarning emits the same warning about a ScrapeJob on both the
+// config and the job.
+func (r *AdditionalScrapeConfigReconciler) recordScrapeJobWarning(config *prometheusv1.AdditionalScrapeConfig, job *prometheusv1.ScrapeJob, reason string, note string, args ...interface{}) {
+	message := fmt.Sprintf(note, args...)
+	r.recordEvent(config, job, corev1.EventTypeWarning, reason, eventActionRender, "ScrapeJob %s: %s", getScrapeJobName(job), message)
+	r.recordEvent(job, config, corev1.EventTypeWarning, reason, eventActionRender, "AdditionalScrapeConfig %s: %s", getConfigOwnerName(config), message)
+}
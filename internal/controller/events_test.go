@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestProcessTargets_RecordsInvalidAndDuplicateJobs(t *testing.T) {
+	recorder := &mockEventRecorder{}
+	r := &AdditionalScrapeConfigReconciler{Recorder: recorder}
+	config := &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "default"},
+		Spec: prometheusv1.AdditionalScrapeConfigSpec{
+			ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{Any: true},
+		},
+	}
+	staticConfigs := []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"host:80"}}}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "dup", StaticConfigs: staticConfigs}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "j2", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "dup", StaticConfigs: staticConfigs}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "j3", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "invalid"}},
+		},
+	}
+
+	r.processTargets(config, targets, nil, time.Now())
+
+	regarding := make(map[string]bool)
+	for _, event := range recorder.findEvents(eventReasonDuplicateJobName) {
+		regarding[event.regarding] = true
+		if event.eventType != corev1.EventTypeWarning {
+			t.Errorf("event type = %s, want Warning", event.eventType)
+		}
+	}
+	for _, want := range []string{"*v1.AdditionalScrapeConfig default/cfg", "*v1.ScrapeJob ns1/j1", "*v1.ScrapeJob ns1/j2"} {
+		if !regarding[want] {
+			t.Errorf("expected a %s event about %s, got %v", eventReasonDuplicateJobName, want, recorder.events)
+		}
+	}
+
+	if got := len(recorder.findEvents(eventReasonInvalidScrapeJob)); got != 0 {
+		t.Errorf("got %d %s events, want none for jobs without static configs", got, eventReasonInvalidScrapeJob)
+	}
+}
+
+func TestUpdateSecret_RecordsEvents(t *testing.T) {
+	recorder := &mockEventRecorder{}
+	mock := &mockKubeClient{
+		secret:  &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "s", Namespace: "ns"}},
+		applyFn: func(_ context.Context, _ *corev1ac.SecretApplyConfiguration) error { return nil },
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock, Recorder: recorder}
+
+	if _, err := r.updateSecret(context.Background(), zap.New(), newTestConfig(), []prometheus.Job{{JobName: "job1"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	events := recorder.findEvents(eventReasonSecretUpdated)
+	if len(events) != 1 || events[0].note != "Updated key key in secret ns/s: 1 job added; added job job1" {
+		t.Errorf("events = %v", recorder.events)
+	}
+
+	mock.applyFn = func(_ context.Context, _ *corev1ac.SecretApplyConfiguration) error { return errors.New("api error") }
+	if _, err := r.updateSecret(context.Background(), zap.New(), newTestConfig(), []prometheus.Job{{JobName: "job2"}}); err == nil {
+		t.Fatal("expected error")
+	}
+	if events := recorder.findEvents(eventReasonWriteFailed); len(events) != 1 || events[0].eventType != corev1.EventTypeWarning {
+		t.Errorf("events = %v", recorder.events)
+	}
+}
+
+func TestRecordEvent_TruncatesNote(t *testing.T) {
+	recorder := &mockEventRecorder{}
+	r := &AdditionalScrapeConfigReconciler{Recorder: recorder}
+
+	r.recordEvent(newTestConfig(), nil, corev1.EventTypeNormal, eventReasonSecretUpdated, eventActionWrite, "%s", strings.Repeat("x", 2*maxEventNoteLength))
+
+	if got := len(recorder.events[0].note); got != maxEventNoteLength {
+		t.Errorf("note length = %d, want %d", got, maxEventNoteLength)
+	}
+}
@@ -38,6 +38,14 @@ var (
 		[]string{"config_name", "config_namespace"},
 	)
 
+	excludedJobsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_static_target_excluded_scrape_jobs",
+			Help: "ScrapeJobs left out of the output, because they can't be rendered, per AdditionalScrapeConfig",
+		},
+		[]string{"config_name", "config_namespace"},
+	)
+
 	secretUpdateCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "prometheus_static_target_secret_updates_total",
@@ -54,6 +62,31 @@ var (
 		[]string{"config_name", "config_namespace"},
 	)
 
+	secretConflictGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "prometheus_static_target_secret_conflict",
+			Help: "Whether the output of the AdditionalScrapeConfig is blocked by a secret ownership conflict (1) or not (0)",
+		},
+		[]string{"config_name", "config_namespace"},
+	)
+
+	coalescedEventsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "prometheus_static_target_coalesced_events_total",
+			Help: "Total number of ScrapeJob events merged into an already pending reconciliation",
+		},
+		[]string{"config_name", "config_namespace"},
+	)
+
+	secretWriteDurationHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "prometheus_static_target_secret_write_duration_seconds",
+			Help:    "Duration of the secret writes",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"config_name", "config_namespace"},
+	)
+
 	scrapeJobsLoadedGauge = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "prometheus_static_target_scrape_jobs_loaded",
@@ -67,8 +100,12 @@ func init() {
 	metrics.Registry.MustRegister(
 		discoveredJobsGauge,
 		filteredJobsGauge,
+		excludedJobsGauge,
 		secretUpdateCounter,
 		secretUpdateErrorCounter,
+		secretConflictGauge,
 		scrapeJobsLoadedGauge,
+		coalescedEventsCounter,
+		secretWriteDurationHistogram,
 	)
 }
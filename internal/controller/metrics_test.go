@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
@@ -11,6 +12,7 @@ import (
 	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
@@ -61,7 +63,7 @@ func TestProcessTargets_SetsGaugeMetrics(t *testing.T) {
 		},
 	}
 
-	r.processTargets(config, targets)
+	r.processTargets(config, targets, nil, time.Now())
 
 	discovered := testutil.ToFloat64(discoveredJobsGauge.WithLabelValues("cfg-gauge", "ns-gauge"))
 	if discovered != 1 {
@@ -89,7 +91,7 @@ func TestProcessTargets_AllDiscovered(t *testing.T) {
 		},
 	}
 
-	r.processTargets(config, targets)
+	r.processTargets(config, targets, nil, time.Now())
 
 	discovered := testutil.ToFloat64(discoveredJobsGauge.WithLabelValues("cfg-all", "ns-all"))
 	if discovered != 2 {
@@ -120,7 +122,7 @@ func TestUpdateSecret_IncrementsCounterOnSuccess(t *testing.T) {
 
 	before := testutil.ToFloat64(secretUpdateCounter.WithLabelValues("cfg-counter", "ns-counter"))
 
-	err := r.updateSecret(context.Background(), logger, config, nil)
+	_, err := r.updateSecret(context.Background(), logger, config, nil)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -136,7 +138,7 @@ func TestUpdateSecret_IncrementsErrorCounterOnFailure(t *testing.T) {
 	mock := &mockKubeClient{
 		secret:       &corev1.Secret{Data: map[string][]byte{}},
 		secretExists: false,
-		createUpdateFn: func(_ context.Context, _ bool, _ *corev1.Secret) error {
+		applyFn: func(_ context.Context, _ *corev1ac.SecretApplyConfiguration) error {
 			return fmt.Errorf("write failed")
 		},
 	}
@@ -152,7 +154,7 @@ func TestUpdateSecret_IncrementsErrorCounterOnFailure(t *testing.T) {
 
 	before := testutil.ToFloat64(secretUpdateErrorCounter.WithLabelValues("cfg-err", "ns-err"))
 
-	err := r.updateSecret(context.Background(), logger, config, nil)
+	_, err := r.updateSecret(context.Background(), logger, config, nil)
 	if err == nil {
 		t.Fatal("expected error, got nil")
 	}
@@ -181,13 +183,14 @@ func TestUpdateSecret_NoOpSkipsCounters(t *testing.T) {
 	config := &prometheusv1.AdditionalScrapeConfig{
 		ObjectMeta: metav1.ObjectMeta{Name: "cfg-noop", Namespace: "ns-noop"},
 		Spec: prometheusv1.AdditionalScrapeConfigSpec{
-			SecretName:      "noop-secret",
-			SecretNamespace: "noop-ns",
-			SecretKey:       "key",
+			SecretName:          "noop-secret",
+			SecretNamespace:     "noop-ns",
+			SecretKey:           "key",
+			AdoptExistingSecret: true,
 		},
 	}
 
-	err := r.updateSecret(context.Background(), logger, config, nil)
+	_, err := r.updateSecret(context.Background(), logger, config, nil)
 	if err != nil {
 		t.Fatalf("unexpected error on first call: %v", err)
 	}
@@ -198,7 +201,7 @@ func TestUpdateSecret_NoOpSkipsCounters(t *testing.T) {
 	beforeSuccess := testutil.ToFloat64(secretUpdateCounter.WithLabelValues("cfg-noop", "ns-noop"))
 	beforeError := testutil.ToFloat64(secretUpdateErrorCounter.WithLabelValues("cfg-noop", "ns-noop"))
 
-	err = r.updateSecret(context.Background(), logger, config, nil)
+	_, err = r.updateSecret(context.Background(), logger, config, nil)
 	if err != nil {
 		t.Fatalf("unexpected error on no-op call: %v", err)
 	}
@@ -2,20 +2,42 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 
 	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/helper"
 	"github.com/szeber/kube-stager-prometheus-static-target/internal/kubernetes"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// newTestConfig returns a config writing the key "key" of the secret ns/s.
+func newTestConfig() *prometheusv1.AdditionalScrapeConfig {
+	return &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "default"},
+		Spec: prometheusv1.AdditionalScrapeConfigSpec{
+			SecretName:      "s",
+			SecretNamespace: "ns",
+			SecretKey:       "key",
+		},
+	}
+}
+
 // Compile-time check that mockKubeClient satisfies the interface.
 var _ kubernetes.ClientInterface = (*mockKubeClient)(nil)
 
 type mockKubeClient struct {
 	// err is the default error returned by all methods except GetSecret
-	// (which uses secretErr when non-nil) and CreateOrUpdateSecret (which
-	// uses createUpdateFn when non-nil).
+	// (which uses secretErr when non-nil) and the secret write methods (which
+	// use the matching function when non-nil).
 	err error
 
 	secret       *corev1.Secret
@@ -23,22 +45,99 @@ type mockKubeClient struct {
 	// secretErr, when non-nil, overrides err for GetSecret only.
 	secretErr error
 
-	createUpdateFn func(ctx context.Context, secretExists bool, secret *corev1.Secret) error
+	// secretsByName holds the secrets returned by GetSecretByName, keyed by
+	// namespace/name. Missing entries are reported as not existing.
+	secretsByName map[string]*corev1.Secret
+
+	applyFn  func(ctx context.Context, secret *corev1ac.SecretApplyConfiguration) error
+	patchFn  func(ctx context.Context, original *corev1.Secret, modified *corev1.Secret) error
+	deleteFn func(ctx context.Context, secret *corev1.Secret) error
 
 	scrapeJobs *prometheusv1.ScrapeJobList
+	// scrapeJobsByName holds the ScrapeJobs returned by GetScrapeJob, keyed
+	// by namespace/name. Missing entries are reported as not found.
+	scrapeJobsByName map[string]*prometheusv1.ScrapeJob
+	// updatedScrapeJobs holds the ScrapeJobs passed to UpdateScrapeJobStatus.
+	updatedScrapeJobs []prometheusv1.ScrapeJob
+	// deletedScrapeJobs holds the names of the ScrapeJobs passed to
+	// DeleteScrapeJob.
+	deletedScrapeJobs []string
 
 	configs    *prometheusv1.AdditionalScrapeConfigList
 	allConfigs *prometheusv1.AdditionalScrapeConfigList
+	// configsByName holds the configs returned by GetAdditionalScrapeConfig,
+	// keyed by namespace/name. Missing entries are reported as not found.
+	configsByName map[string]*prometheusv1.AdditionalScrapeConfig
+
+	// configMaps holds the ConfigMaps listed, created and deleted through the
+	// mock.
+	configMaps []corev1.ConfigMap
+	// patchedConfigMaps records the names of the patched ConfigMaps.
+	patchedConfigMaps []string
+
+	// workloads holds the workloads returned by GetWorkload and updated by
+	// PatchWorkload. Missing workloads are reported as not found.
+	workloads []client.Object
+	// patchedWorkloads holds the names of the workloads passed to
+	// PatchWorkload.
+	patchedWorkloads []string
+
+	// prometheuses holds the Prometheus Operator objects returned by
+	// GetPrometheus and updated by PatchPrometheus, keyed by
+	// kind/namespace/name. Missing objects are reported as not found.
+	prometheuses map[string]*unstructured.Unstructured
+	// patchedPrometheuses counts the PatchPrometheus calls.
+	patchedPrometheuses int
+
+	// templates and clusterTemplates hold the templates returned by
+	// GetScrapeJobTemplate, keyed by namespace/name, and
+	// GetClusterScrapeJobTemplate, keyed by name. Missing entries are
+	// reported as not found.
+	templates        map[string]*prometheusv1.ScrapeJobTemplate
+	clusterTemplates map[string]*prometheusv1.ClusterScrapeJobTemplate
 }
 
-func (m *mockKubeClient) GetAdditionalScrapeConfig(_ context.Context, _ string, _ string) (*prometheusv1.AdditionalScrapeConfig, error) {
-	return nil, m.err
+func (m *mockKubeClient) GetAdditionalScrapeConfig(_ context.Context, namespace string, name string) (*prometheusv1.AdditionalScrapeConfig, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if config, ok := m.configsByName[namespace+"/"+name]; ok {
+		return config, nil
+	}
+	return nil, apierrors.NewNotFound(prometheusv1.GroupVersion.WithResource("additionalscrapeconfigs").GroupResource(), name)
 }
 
 func (m *mockKubeClient) LoadScrapeJobs(_ context.Context, _ *prometheusv1.AdditionalScrapeConfig) (*prometheusv1.ScrapeJobList, error) {
 	return m.scrapeJobs, m.err
 }
 
+func (m *mockKubeClient) GetScrapeJob(_ context.Context, namespace string, name string) (*prometheusv1.ScrapeJob, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	job, ok := m.scrapeJobsByName[namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "scrapejobs"}, name)
+	}
+	return job.DeepCopy(), nil
+}
+
+func (m *mockKubeClient) UpdateScrapeJobStatus(_ context.Context, job *prometheusv1.ScrapeJob) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.updatedScrapeJobs = append(m.updatedScrapeJobs, *job.DeepCopy())
+	return nil
+}
+
+func (m *mockKubeClient) DeleteScrapeJob(_ context.Context, job *prometheusv1.ScrapeJob) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.deletedScrapeJobs = append(m.deletedScrapeJobs, job.Namespace+"/"+job.Name)
+	return nil
+}
+
 func (m *mockKubeClient) GetSecret(_ context.Context, _ *prometheusv1.AdditionalScrapeConfig) (*corev1.Secret, bool, error) {
 	if m.secretErr != nil {
 		return nil, false, m.secretErr
@@ -46,17 +145,259 @@ func (m *mockKubeClient) GetSecret(_ context.Context, _ *prometheusv1.Additional
 	return m.secret, m.secretExists, m.err
 }
 
-func (m *mockKubeClient) CreateOrUpdateSecret(ctx context.Context, secretExists bool, secret *corev1.Secret) error {
-	if m.createUpdateFn != nil {
-		return m.createUpdateFn(ctx, secretExists, secret)
+func (m *mockKubeClient) GetSecretByName(_ context.Context, namespace string, name string) (*corev1.Secret, bool, error) {
+	if m.secretErr != nil {
+		return nil, false, m.secretErr
+	}
+	if secret, ok := m.secretsByName[namespace+"/"+name]; ok {
+		return secret, true, m.err
+	}
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}, false, m.err
+}
+
+func (m *mockKubeClient) ApplySecret(ctx context.Context, secret *corev1ac.SecretApplyConfiguration) error {
+	if m.applyFn != nil {
+		return m.applyFn(ctx, secret)
 	}
 	return m.err
 }
 
-func (m *mockKubeClient) FindAdditionalScrapeConfigsForSecret(_ context.Context, _ client.Object) (*prometheusv1.AdditionalScrapeConfigList, error) {
-	return m.configs, m.err
+func (m *mockKubeClient) PatchSecret(ctx context.Context, original *corev1.Secret, modified *corev1.Secret) error {
+	if m.patchFn != nil {
+		return m.patchFn(ctx, original, modified)
+	}
+	return m.err
+}
+
+func (m *mockKubeClient) DeleteSecret(ctx context.Context, secret *corev1.Secret) error {
+	if m.deleteFn != nil {
+		return m.deleteFn(ctx, secret)
+	}
+	return m.err
+}
+
+// FindAdditionalScrapeConfigsForSecret filters configs the same way the
+// secret field index does.
+func (m *mockKubeClient) FindAdditionalScrapeConfigsForSecret(_ context.Context, secret client.Object) (*prometheusv1.AdditionalScrapeConfigList, error) {
+	if m.err != nil || m.configs == nil {
+		return m.configs, m.err
+	}
+
+	result := &prometheusv1.AdditionalScrapeConfigList{}
+	key := kubernetes.GetSecretIndexKey(secret.GetNamespace(), secret.GetName())
+	for _, config := range m.configs.Items {
+		if helper.StringInStringSlice(key, kubernetes.GetReferencedSecretIndexKeys(&config)) {
+			result.Items = append(result.Items, config)
+		}
+	}
+	return result, nil
 }
 
 func (m *mockKubeClient) GetAllAdditionalScrapeConfigs(_ context.Context) (*prometheusv1.AdditionalScrapeConfigList, error) {
 	return m.allConfigs, m.err
 }
+
+func (m *mockKubeClient) ListConfigMaps(_ context.Context, namespace string, matchLabels map[string]string) (*corev1.ConfigMapList, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	list := &corev1.ConfigMapList{}
+	for _, configMap := range m.configMaps {
+		if configMap.Namespace == namespace && labels.SelectorFromSet(matchLabels).Matches(labels.Set(configMap.Labels)) {
+			list.Items = append(list.Items, configMap)
+		}
+	}
+	return list, nil
+}
+
+func (m *mockKubeClient) CreateConfigMap(_ context.Context, configMap *corev1.ConfigMap) error {
+	if m.err != nil {
+		return m.err
+	}
+	if _, i := m.findConfigMap(configMap.Namespace, configMap.Name); i >= 0 {
+		return apierrors.NewAlreadyExists(schema.GroupResource{Resource: "configmaps"}, configMap.Name)
+	}
+	m.configMaps = append(m.configMaps, *configMap)
+	return nil
+}
+
+func (m *mockKubeClient) findConfigMap(namespace string, name string) (*corev1.ConfigMap, int) {
+	for i := range m.configMaps {
+		if m.configMaps[i].Namespace == namespace && m.configMaps[i].Name == name {
+			return &m.configMaps[i], i
+		}
+	}
+	return nil, -1
+}
+
+func (m *mockKubeClient) GetConfigMap(_ context.Context, namespace string, name string) (*corev1.ConfigMap, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	configMap, i := m.findConfigMap(namespace, name)
+	if i < 0 {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, name)
+	}
+	return configMap.DeepCopy(), nil
+}
+
+func (m *mockKubeClient) PatchConfigMap(_ context.Context, _ *corev1.ConfigMap, modified *corev1.ConfigMap) error {
+	if m.err != nil {
+		return m.err
+	}
+	_, i := m.findConfigMap(modified.Namespace, modified.Name)
+	if i < 0 {
+		return apierrors.NewNotFound(schema.GroupResource{Resource: "configmaps"}, modified.Name)
+	}
+	m.configMaps[i] = *modified.DeepCopy()
+	m.patchedConfigMaps = append(m.patchedConfigMaps, modified.Name)
+	return nil
+}
+
+func (m *mockKubeClient) DeleteConfigMap(_ context.Context, configMap *corev1.ConfigMap) error {
+	if m.err != nil {
+		return m.err
+	}
+	for i, existing := range m.configMaps {
+		if existing.Namespace == configMap.Namespace && existing.Name == configMap.Name {
+			m.configMaps = append(m.configMaps[:i], m.configMaps[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockKubeClient) findWorkload(workload client.Object) int {
+	for i, existing := range m.workloads {
+		if reflect.TypeOf(existing) == reflect.TypeOf(workload) &&
+			existing.GetNamespace() == workload.GetNamespace() && existing.GetName() == workload.GetName() {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *mockKubeClient) GetWorkload(_ context.Context, workload client.Object) error {
+	if m.err != nil {
+		return m.err
+	}
+	i := m.findWorkload(workload)
+	if i < 0 {
+		return apierrors.NewNotFound(schema.GroupResource{Group: "apps"}, workload.GetName())
+	}
+	reflect.ValueOf(workload).Elem().Set(reflect.ValueOf(m.workloads[i].DeepCopyObject()).Elem())
+	return nil
+}
+
+func (m *mockKubeClient) PatchWorkload(_ context.Context, _ client.Object, modified client.Object) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.patchedWorkloads = append(m.patchedWorkloads, modified.GetNamespace()+"/"+modified.GetName())
+	if i := m.findWorkload(modified); i >= 0 {
+		m.workloads[i] = modified.DeepCopyObject().(client.Object)
+	}
+	return nil
+}
+
+func getMockPrometheusKey(prometheus *unstructured.Unstructured) string {
+	return prometheus.GetKind() + "/" + prometheus.GetNamespace() + "/" + prometheus.GetName()
+}
+
+func (m *mockKubeClient) GetPrometheus(_ context.Context, prometheus *unstructured.Unstructured) error {
+	if m.err != nil {
+		return m.err
+	}
+	existing, ok := m.prometheuses[getMockPrometheusKey(prometheus)]
+	if !ok {
+		return apierrors.NewNotFound(schema.GroupResource{Group: "monitoring.coreos.com"}, prometheus.GetName())
+	}
+	existing.DeepCopyInto(prometheus)
+	return nil
+}
+
+func (m *mockKubeClient) PatchPrometheus(_ context.Context, _ *unstructured.Unstructured, modified *unstructured.Unstructured) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.patchedPrometheuses++
+	m.prometheuses[getMockPrometheusKey(modified)] = modified.DeepCopy()
+	return nil
+}
+
+func (m *mockKubeClient) GetScrapeJobTemplate(_ context.Context, namespace string, name string) (*prometheusv1.ScrapeJobTemplate, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	template, ok := m.templates[namespace+"/"+name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "scrapejobtemplates"}, name)
+	}
+	return template.DeepCopy(), nil
+}
+
+func (m *mockKubeClient) GetClusterScrapeJobTemplate(_ context.Context, name string) (*prometheusv1.ClusterScrapeJobTemplate, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	template, ok := m.clusterTemplates[name]
+	if !ok {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "clusterscrapejobtemplates"}, name)
+	}
+	return template.DeepCopy(), nil
+}
+
+// FindScrapeJobsForTemplate filters scrapeJobs by their template reference,
+// like the field index does.
+func (m *mockKubeClient) FindScrapeJobsForTemplate(_ context.Context, kind string, namespace string, name string) (*prometheusv1.ScrapeJobList, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	result := &prometheusv1.ScrapeJobList{}
+	if nil == m.scrapeJobs {
+		return result, nil
+	}
+	key := kubernetes.GetTemplateIndexKey(kind, namespace, name)
+	for _, job := range m.scrapeJobs.Items {
+		if keys := kubernetes.GetReferencedTemplateIndexKeys(&job); len(keys) == 1 && keys[0] == key {
+			result.Items = append(result.Items, job)
+		}
+	}
+	return result, nil
+}
+
+// recordedEvent is an event captured by mockEventRecorder.
+type recordedEvent struct {
+	// regarding is the kind and namespace/name of the regarding object.
+	regarding string
+	eventType string
+	reason    string
+	note      string
+}
+
+// mockEventRecorder captures the emitted events along with the object they
+// are about.
+type mockEventRecorder struct {
+	events []recordedEvent
+}
+
+func (m *mockEventRecorder) Eventf(regarding runtime.Object, _ runtime.Object, eventType, reason, _, note string, args ...interface{}) {
+	object := regarding.(client.Object)
+	m.events = append(m.events, recordedEvent{
+		regarding: fmt.Sprintf("%T %s/%s", regarding, object.GetNamespace(), object.GetName()),
+		eventType: eventType,
+		reason:    reason,
+		note:      fmt.Sprintf(note, args...),
+	})
+}
+
+// findEvents returns the events with the given reason.
+func (m *mockEventRecorder) findEvents(reason string) []recordedEvent {
+	var found []recordedEvent
+	for _, event := range m.events {
+		if event.reason == reason {
+			found = append(found, event)
+		}
+	}
+	return found
+}
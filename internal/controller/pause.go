@@ -0,0 +1,94 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sort"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setPausedCondition sets the paused condition of the config.
+func setPausedCondition(config *prometheusv1.AdditionalScrapeConfig) {
+	condition := metav1.Condition{
+		Type:               prometheusv1.ConditionTypePaused,
+		Status:             metav1.ConditionFalse,
+		Reason:             prometheusv1.ReasonNotPaused,
+		Message:            "The output secret is written",
+		ObservedGeneration: config.Generation,
+	}
+
+	switch config.GetPausedReason() {
+	case prometheusv1.ReasonPausedBySpec:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = prometheusv1.ReasonPausedBySpec
+		condition.Message = "spec.paused is set, the ScrapeJobs and the output secret are left unchanged"
+	case prometheusv1.ReasonPausedByAnnotation:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = prometheusv1.ReasonPausedByAnnotation
+		condition.Message = "The " + prometheusv1.PausedAnnotation + " annotation is set, the ScrapeJobs and the output secret are left unchanged"
+	}
+
+	meta.SetStatusCondition(&config.Status.Conditions, condition)
+}
+
+// getSuspendedScrapeJobs returns the names of the suspended jobs selected by
+// the config, sorted.
+func (r *AdditionalScrapeConfigReconciler) getSuspendedScrapeJobs(config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList) []string {
+	var suspendedJobs []string
+	for _, target := range targetList.Items {
+		if target.Spec.Suspend && r.selectsNamespace(config, target.Namespace) {
+			suspendedJobs = append(suspendedJobs, getScrapeJobName(&target))
+		}
+	}
+
+	sort.Strings(suspendedJobs)
+
+	return suspendedJobs
+}
+
+// updateSuspendedConditions sets the suspended condition of the jobs selected
+// by the config.
+func (r *AdditionalScrapeConfigReconciler) updateSuspendedConditions(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList) error {
+	for i := range targetList.Items {
+		target := &targetList.Items[i]
+		if !r.selectsNamespace(config, target.Namespace) {
+			continue
+		}
+
+		condition := metav1.Condition{
+			Type:    prometheusv1.ConditionTypeSuspended,
+			Status:  metav1.ConditionFalse,
+			Reason:  prometheusv1.ReasonNotSuspended,
+			Message: "The job is rendered",
+		}
+		if target.Spec.Suspend {
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = prometheusv1.ReasonSuspended
+			condition.Message = "spec.suspend is set, the job is not rendered"
+		}
+
+		if err := r.updateScrapeJobCondition(ctx, target, condition); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestSetPausedCondition(t *testing.T) {
+	tests := map[string]struct {
+		paused      bool
+		annotations map[string]string
+		wantStatus  metav1.ConditionStatus
+		wantReason  string
+	}{
+		"not paused": {
+			wantStatus: metav1.ConditionFalse,
+			wantReason: prometheusv1.ReasonNotPaused,
+		},
+		"paused by spec": {
+			paused:     true,
+			wantStatus: metav1.ConditionTrue,
+			wantReason: prometheusv1.ReasonPausedBySpec,
+		},
+		"paused by annotation": {
+			annotations: map[string]string{prometheusv1.PausedAnnotation: "true"},
+			wantStatus:  metav1.ConditionTrue,
+			wantReason:  prometheusv1.ReasonPausedByAnnotation,
+		},
+		"annotation not true": {
+			annotations: map[string]string{prometheusv1.PausedAnnotation: "false"},
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  prometheusv1.ReasonNotPaused,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := newTestConfig()
+			config.Spec.Paused = tt.paused
+			config.Annotations = tt.annotations
+
+			setPausedCondition(config)
+
+			condition := meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypePaused)
+			if condition == nil || condition.Status != tt.wantStatus || condition.Reason != tt.wantReason {
+				t.Errorf("condition = %v, want %s/%s", condition, tt.wantStatus, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestProcessTargets_SkipsSuspendedJobs(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "active", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "active"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "suspended", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "suspended", Suspend: true}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns2"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "other", Suspend: true}},
+		},
+	}
+
+	discovered, jobs, _ := r.processTargets(config, targets, nil, time.Now())
+	if len(discovered) != 1 || discovered[0] != "ns1/active" {
+		t.Errorf("discovered = %v, want [ns1/active]", discovered)
+	}
+	if len(jobs) != 1 || jobs[0].JobName != "active" {
+		t.Errorf("jobs = %v, want [active]", jobs)
+	}
+
+	if suspended := r.getSuspendedScrapeJobs(config, targets); len(suspended) != 1 || suspended[0] != "ns1/suspended" {
+		t.Errorf("suspended = %v, want [ns1/suspended]", suspended)
+	}
+}
+
+func TestUpdateSuspendedConditions(t *testing.T) {
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "active", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "active"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "suspended", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "suspended", Suspend: true}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns2"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "other", Suspend: true}},
+		},
+	}
+
+	if err := r.updateSuspendedConditions(context.Background(), config, targets); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.updatedScrapeJobs) != 1 || mock.updatedScrapeJobs[0].Name != "suspended" {
+		t.Fatalf("updated jobs = %v, want only the suspended job in a selected namespace", mock.updatedScrapeJobs)
+	}
+	if !meta.IsStatusConditionTrue(mock.updatedScrapeJobs[0].Status.Conditions, prometheusv1.ConditionTypeSuspended) {
+		t.Errorf("expected the %s condition to be true", prometheusv1.ConditionTypeSuspended)
+	}
+
+	mock.updatedScrapeJobs = nil
+	if err := r.updateSuspendedConditions(context.Background(), config, targets); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.updatedScrapeJobs) != 0 {
+		t.Errorf("updated jobs = %v, want no update when the conditions are unchanged", mock.updatedScrapeJobs)
+	}
+
+	targets.Items[1].Spec.Suspend = false
+	if err := r.updateSuspendedConditions(context.Background(), config, targets); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.updatedScrapeJobs) != 1 || !meta.IsStatusConditionFalse(mock.updatedScrapeJobs[0].Status.Conditions, prometheusv1.ConditionTypeSuspended) {
+		t.Errorf("updated jobs = %v, want the resumed job with a false condition", mock.updatedScrapeJobs)
+	}
+}
+
+func TestUpdateScrapeJobs_LeavesJobsUnchangedWhilePaused(t *testing.T) {
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock, Recorder: &mockEventRecorder{}, DeleteExpiredScrapeJobs: true}
+	config := newTestConfig()
+	config.Spec.Paused = true
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	now := time.Now()
+	past := metav1.NewTime(now.Add(-time.Hour))
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "suspended", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "suspended", Suspend: true}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "expired", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "expired", ActiveUntil: &past}},
+		},
+	}
+	selected := selectedTargets{excluded: []prometheusv1.ExcludedScrapeJob{{Name: "ns1/suspended", Reason: prometheusv1.ReasonDuplicateJobName}}}
+
+	if _, err := r.updateScrapeJobs(context.Background(), zap.New(), config, targets, selected, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.deletedScrapeJobs) != 0 {
+		t.Errorf("deleted = %v, want the expired job to survive while paused", mock.deletedScrapeJobs)
+	}
+	if len(mock.updatedScrapeJobs) != 0 {
+		t.Errorf("updated jobs = %v, want no status writes while paused", mock.updatedScrapeJobs)
+	}
+
+	config.Spec.Paused = false
+	if _, err := r.updateScrapeJobs(context.Background(), zap.New(), config, targets, selected, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.deletedScrapeJobs) != 1 || mock.deletedScrapeJobs[0] != "ns1/expired" {
+		t.Errorf("deleted = %v, want the expired job once resumed", mock.deletedScrapeJobs)
+	}
+}
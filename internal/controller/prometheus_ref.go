@@ -0,0 +1,191 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// prometheusGroupVersionKinds maps the supported Prometheus Operator kinds to
+// their group version kind. They are handled as unstructured objects, so the
+// operator doesn't depend on the Prometheus Operator types.
+var prometheusGroupVersionKinds = map[string]schema.GroupVersionKind{
+	"Prometheus":      {Group: "monitoring.coreos.com", Version: "v1", Kind: "Prometheus"},
+	"PrometheusAgent": {Group: "monitoring.coreos.com", Version: "v1alpha1", Kind: "PrometheusAgent"},
+}
+
+func newPrometheusObject(wired *prometheusv1.WiredPrometheus) *unstructured.Unstructured {
+	prometheus := &unstructured.Unstructured{}
+	prometheus.SetGroupVersionKind(prometheusGroupVersionKinds[wired.Kind])
+	prometheus.SetNamespace(wired.Namespace)
+	prometheus.SetName(wired.Name)
+
+	return prometheus
+}
+
+func getPrometheusName(wired *prometheusv1.WiredPrometheus) string {
+	return fmt.Sprintf("%s %s/%s", wired.Kind, wired.Namespace, wired.Name)
+}
+
+// getDesiredWiredPrometheus returns the Prometheus object referenced by the
+// spec, nil if there is none.
+func getDesiredWiredPrometheus(config *prometheusv1.AdditionalScrapeConfig) *prometheusv1.WiredPrometheus {
+	if nil == config.Spec.PrometheusRef {
+		return nil
+	}
+
+	return &prometheusv1.WiredPrometheus{
+		Kind:      config.Spec.PrometheusRef.Kind,
+		Name:      config.Spec.PrometheusRef.Name,
+		Namespace: config.Spec.SecretNamespace,
+	}
+}
+
+// getAdditionalScrapeConfigs returns the secret name and key set in the
+// additionalScrapeConfigs of the Prometheus object.
+func getAdditionalScrapeConfigs(prometheus *unstructured.Unstructured) (string, string) {
+	name, _, _ := unstructured.NestedString(prometheus.Object, "spec", "additionalScrapeConfigs", "name")
+	key, _, _ := unstructured.NestedString(prometheus.Object, "spec", "additionalScrapeConfigs", "key")
+
+	return name, key
+}
+
+// isPrometheusNotFound returns true if the Prometheus object or the Prometheus
+// Operator CRD doesn't exist.
+func isPrometheusNotFound(err error) bool {
+	return apierrors.IsNotFound(err) || meta.IsNoMatchError(err)
+}
+
+// isOwnAdditionalScrapeConfigs returns true if the Prometheus object reads the
+// current or the previous output of the config.
+func isOwnAdditionalScrapeConfigs(config *prometheusv1.AdditionalScrapeConfig, prometheus *unstructured.Unstructured) bool {
+	name, key := getAdditionalScrapeConfigs(prometheus)
+	for _, output := range []*prometheusv1.OutputLocation{config.Spec.OutputLocation(), config.Status.LastOutput} {
+		if nil != output && output.SecretNamespace == prometheus.GetNamespace() && output.SecretName == name && output.SecretKey == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// updatePrometheusRef sets the additionalScrapeConfigs of the referenced
+// Prometheus object to the secret, and removes it from the previously wired
+// object if the reference changed. Additional scrape configs pointing at a
+// secret not written by the config are left alone. The result is set in the
+// status, but not saved.
+func (r *AdditionalScrapeConfigReconciler) updatePrometheusRef(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig) error {
+	desired := getDesiredWiredPrometheus(config)
+	if wired := config.Status.WiredPrometheus; nil != wired && (nil == desired || *wired != *desired) {
+		if err := r.unwirePrometheus(ctx, logger, config, wired); nil != err {
+			return err
+		}
+		config.Status.WiredPrometheus = nil
+	}
+
+	if nil == desired {
+		meta.RemoveStatusCondition(&config.Status.Conditions, prometheusv1.ConditionTypePrometheusWired)
+		return nil
+	}
+
+	condition := metav1.Condition{
+		Type:               prometheusv1.ConditionTypePrometheusWired,
+		Status:             metav1.ConditionTrue,
+		Reason:             prometheusv1.ReasonPrometheusWired,
+		Message:            fmt.Sprintf("%s reads the additional scrape configs from the secret", getPrometheusName(desired)),
+		ObservedGeneration: config.Generation,
+	}
+
+	prometheus := newPrometheusObject(desired)
+	err := r.KubeClient.GetPrometheus(ctx, prometheus)
+	if isPrometheusNotFound(err) {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = prometheusv1.ReasonPrometheusNotFound
+		condition.Message = fmt.Sprintf("%s not found", getPrometheusName(desired))
+		meta.SetStatusCondition(&config.Status.Conditions, condition)
+		return nil
+	}
+	if nil != err {
+		return err
+	}
+
+	name, key := getAdditionalScrapeConfigs(prometheus)
+	if name != config.Spec.SecretName || key != config.Spec.SecretKey {
+		if name != "" && !isOwnAdditionalScrapeConfigs(config, prometheus) {
+			condition.Status = metav1.ConditionFalse
+			condition.Reason = prometheusv1.ReasonAdditionalScrapeConfigsInUse
+			condition.Message = fmt.Sprintf("%s already reads the additional scrape configs from key %s in secret %s", getPrometheusName(desired), key, name)
+			meta.SetStatusCondition(&config.Status.Conditions, condition)
+			return nil
+		}
+
+		original := prometheus.DeepCopy()
+		additionalScrapeConfigs := map[string]interface{}{"name": config.Spec.SecretName, "key": config.Spec.SecretKey}
+		if err = unstructured.SetNestedMap(prometheus.Object, additionalScrapeConfigs, "spec", "additionalScrapeConfigs"); nil != err {
+			return err
+		}
+
+		logger.Info(fmt.Sprintf("Wiring the secret into %s", getPrometheusName(desired)))
+		if err = r.KubeClient.PatchPrometheus(ctx, original, prometheus); nil != err {
+			return err
+		}
+		r.recordEvent(config, prometheus, corev1.EventTypeNormal, eventReasonPrometheusWired, eventActionWire, "Set the additional scrape configs of %s to key %s in secret %s", getPrometheusName(desired), config.Spec.SecretKey, config.Spec.SecretName)
+	}
+
+	config.Status.WiredPrometheus = desired
+	meta.SetStatusCondition(&config.Status.Conditions, condition)
+
+	return nil
+}
+
+// unwirePrometheus removes the additionalScrapeConfigs from the Prometheus
+// object, if it still points at the output of the config.
+func (r *AdditionalScrapeConfigReconciler) unwirePrometheus(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, wired *prometheusv1.WiredPrometheus) error {
+	prometheus := newPrometheusObject(wired)
+	err := r.KubeClient.GetPrometheus(ctx, prometheus)
+	if isPrometheusNotFound(err) {
+		return nil
+	}
+	if nil != err {
+		return err
+	}
+
+	if !isOwnAdditionalScrapeConfigs(config, prometheus) {
+		return nil
+	}
+
+	original := prometheus.DeepCopy()
+	unstructured.RemoveNestedField(prometheus.Object, "spec", "additionalScrapeConfigs")
+
+	logger.Info(fmt.Sprintf("Removing the secret from %s", getPrometheusName(wired)))
+	if err = r.KubeClient.PatchPrometheus(ctx, original, prometheus); nil != err {
+		return err
+	}
+	r.recordEvent(config, prometheus, corev1.EventTypeNormal, eventReasonPrometheusUnwired, eventActionWire, "Removed the additional scrape configs from %s", getPrometheusName(wired))
+
+	return nil
+}
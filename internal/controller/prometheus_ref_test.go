@@ -0,0 +1,139 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func newTestPrometheus(kind string, name string, secretName string, secretKey string) *unstructured.Unstructured {
+	prometheus := newPrometheusObject(&prometheusv1.WiredPrometheus{Kind: kind, Name: name, Namespace: "ns"})
+	if secretName != "" {
+		_ = unstructured.SetNestedMap(prometheus.Object, map[string]interface{}{"name": secretName, "key": secretKey}, "spec", "additionalScrapeConfigs")
+	}
+	return prometheus
+}
+
+func newPrometheusRefTestReconciler(prometheuses ...*unstructured.Unstructured) (*AdditionalScrapeConfigReconciler, *mockKubeClient) {
+	kubeClient := &mockKubeClient{prometheuses: map[string]*unstructured.Unstructured{}}
+	for _, prometheus := range prometheuses {
+		kubeClient.prometheuses[getMockPrometheusKey(prometheus)] = prometheus
+	}
+	return &AdditionalScrapeConfigReconciler{KubeClient: kubeClient, Recorder: &mockEventRecorder{}}, kubeClient
+}
+
+func getTestAdditionalScrapeConfigs(t *testing.T, kubeClient *mockKubeClient, kind string, name string) (string, string) {
+	t.Helper()
+	return getAdditionalScrapeConfigs(kubeClient.prometheuses[kind+"/ns/"+name])
+}
+
+func TestUpdatePrometheusRef_WiresAndRewires(t *testing.T) {
+	r, kubeClient := newPrometheusRefTestReconciler(
+		newTestPrometheus("Prometheus", "main", "", ""),
+		newTestPrometheus("PrometheusAgent", "agent", "", ""),
+	)
+	config := newTestConfig()
+	config.Spec.PrometheusRef = &prometheusv1.PrometheusReference{Kind: "Prometheus", Name: "main"}
+
+	if err := r.updatePrometheusRef(context.Background(), zap.New(), config); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name, key := getTestAdditionalScrapeConfigs(t, kubeClient, "Prometheus", "main"); name != "s" || key != "key" {
+		t.Errorf("additionalScrapeConfigs = %s/%s, want s/key", name, key)
+	}
+	if !meta.IsStatusConditionTrue(config.Status.Conditions, prometheusv1.ConditionTypePrometheusWired) {
+		t.Errorf("expected the %s condition to be true", prometheusv1.ConditionTypePrometheusWired)
+	}
+
+	// An already wired object isn't patched again.
+	if err := r.updatePrometheusRef(context.Background(), zap.New(), config); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kubeClient.patchedPrometheuses != 1 {
+		t.Errorf("got %d patches, want 1", kubeClient.patchedPrometheuses)
+	}
+
+	// Changing the reference moves the secret to the new object.
+	config.Spec.PrometheusRef = &prometheusv1.PrometheusReference{Kind: "PrometheusAgent", Name: "agent"}
+	if err := r.updatePrometheusRef(context.Background(), zap.New(), config); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name, _ := getTestAdditionalScrapeConfigs(t, kubeClient, "Prometheus", "main"); name != "" {
+		t.Errorf("expected the additionalScrapeConfigs to be removed from the previous object, got %s", name)
+	}
+	if name, key := getTestAdditionalScrapeConfigs(t, kubeClient, "PrometheusAgent", "agent"); name != "s" || key != "key" {
+		t.Errorf("additionalScrapeConfigs = %s/%s, want s/key", name, key)
+	}
+
+	// Removing the reference unwires the object.
+	config.Spec.PrometheusRef = nil
+	if err := r.updatePrometheusRef(context.Background(), zap.New(), config); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name, _ := getTestAdditionalScrapeConfigs(t, kubeClient, "PrometheusAgent", "agent"); name != "" {
+		t.Errorf("expected the additionalScrapeConfigs to be removed, got %s", name)
+	}
+	if nil != config.Status.WiredPrometheus || nil != meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypePrometheusWired) {
+		t.Errorf("expected the wired status and condition to be cleared, got %+v", config.Status)
+	}
+}
+
+func TestUpdatePrometheusRef_LeavesOtherSecretsAlone(t *testing.T) {
+	r, kubeClient := newPrometheusRefTestReconciler(newTestPrometheus("Prometheus", "main", "other", "key"))
+	config := newTestConfig()
+	config.Spec.PrometheusRef = &prometheusv1.PrometheusReference{Kind: "Prometheus", Name: "main"}
+
+	if err := r.updatePrometheusRef(context.Background(), zap.New(), config); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if kubeClient.patchedPrometheuses != 0 {
+		t.Errorf("got %d patches, want none", kubeClient.patchedPrometheuses)
+	}
+	condition := meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypePrometheusWired)
+	if nil == condition || condition.Reason != prometheusv1.ReasonAdditionalScrapeConfigsInUse {
+		t.Errorf("condition = %+v, want %s", condition, prometheusv1.ReasonAdditionalScrapeConfigsInUse)
+	}
+
+	// Unwiring doesn't touch an object reading a different secret either.
+	if err := r.unwirePrometheus(context.Background(), zap.New(), config, getDesiredWiredPrometheus(config)); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name, _ := getTestAdditionalScrapeConfigs(t, kubeClient, "Prometheus", "main"); name != "other" {
+		t.Errorf("additionalScrapeConfigs secret = %q, want other", name)
+	}
+}
+
+func TestUpdatePrometheusRef_FollowsOutputChanges(t *testing.T) {
+	r, kubeClient := newPrometheusRefTestReconciler(newTestPrometheus("Prometheus", "main", "old", "key"))
+	config := newTestConfig()
+	config.Spec.PrometheusRef = &prometheusv1.PrometheusReference{Kind: "Prometheus", Name: "main"}
+	config.Status.LastOutput = &prometheusv1.OutputLocation{SecretName: "old", SecretNamespace: "ns", SecretKey: "key"}
+
+	if err := r.updatePrometheusRef(context.Background(), zap.New(), config); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name, _ := getTestAdditionalScrapeConfigs(t, kubeClient, "Prometheus", "main"); name != "s" {
+		t.Errorf("additionalScrapeConfigs secret = %q, want the new secret", name)
+	}
+}
+
+func TestUpdatePrometheusRef_NotFound(t *testing.T) {
+	r, _ := newPrometheusRefTestReconciler()
+	config := newTestConfig()
+	config.Spec.PrometheusRef = &prometheusv1.PrometheusReference{Kind: "Prometheus", Name: "main"}
+
+	if err := r.updatePrometheusRef(context.Background(), zap.New(), config); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	condition := meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypePrometheusWired)
+	if nil == condition || condition.Reason != prometheusv1.ReasonPrometheusNotFound {
+		t.Errorf("condition = %+v, want %s", condition, prometheusv1.ReasonPrometheusNotFound)
+	}
+	if nil != config.Status.WiredPrometheus {
+		t.Errorf("wired = %+v, want nil", config.Status.WiredPrometheus)
+	}
+}
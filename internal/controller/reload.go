@@ -0,0 +1,202 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// prometheusRequestTimeout is the timeout of the requests sent to the
+	// Prometheus servers.
+	prometheusRequestTimeout = 10 * time.Second
+	// reloadPath is the reload endpoint of Prometheus.
+	reloadPath = "/-/reload"
+	// reloadBaseBackoff is the wait after the first failed reload, doubled
+	// after every further failure.
+	reloadBaseBackoff = 5 * time.Second
+	// reloadMaxBackoff is the longest wait between two reload attempts.
+	reloadMaxBackoff = 5 * time.Minute
+	// reloadRetryInterval is the wait between the attempts of a reload that
+	// gave up, so a Prometheus server that was down for longer is reloaded
+	// once it's back.
+	reloadRetryInterval = 10 * time.Minute
+)
+
+// updateReload reloads the Prometheus server once the reload delay passed
+// after an output change. The delay only approximates the kubelet updating the
+// mounted secret, a reload before that loads the previous output until the
+// next change. Failed attempts are retried with an exponential backoff. After
+// the last attempt it keeps retrying every reloadRetryInterval. The reload
+// state is set in the status, but not saved.
+// Returns the time until the next attempt, or 0 if no reload is due.
+func (r *AdditionalScrapeConfigReconciler) updateReload(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, outputChanged bool, now time.Time) time.Duration {
+	spec := config.Spec.Reload
+	if nil == spec {
+		config.Status.Reload = nil
+		meta.RemoveStatusCondition(&config.Status.Conditions, prometheusv1.ConditionTypeReloaded)
+		return 0
+	}
+
+	if nil == config.Status.Reload {
+		config.Status.Reload = &prometheusv1.ReloadStatus{}
+	}
+	status := config.Status.Reload
+	if outputChanged {
+		changeTime := metav1.NewTime(now)
+		status.Pending = true
+		status.ChangeTime = &changeTime
+		status.Attempts = 0
+		status.LastError = ""
+		setReloadedCondition(config, metav1.ConditionFalse, prometheusv1.ReasonReloadPending, "Waiting for the kubelet to update the mounted secret")
+	}
+	// A reload that gave up is not pending, but still has its error
+	failed := !status.Pending && status.LastError != ""
+	if !status.Pending && !failed {
+		return 0
+	}
+
+	var next time.Time
+	if failed && nil != status.LastAttemptTime {
+		next = status.LastAttemptTime.Add(reloadRetryInterval)
+	} else if status.Attempts > 0 && nil != status.LastAttemptTime {
+		next = status.LastAttemptTime.Add(getReloadBackoff(status.Attempts))
+	} else if nil != status.ChangeTime {
+		next = status.ChangeTime.Add(spec.GetDelay())
+	}
+	if now.Before(next) {
+		return next.Sub(now)
+	}
+
+	attemptTime := metav1.NewTime(now)
+	status.Attempts++
+	status.LastAttemptTime = &attemptTime
+
+	url := spec.GetURL(config.Namespace, reloadPath)
+	err := r.reload(ctx, config, spec, url)
+	if nil == err {
+		logger.Info(fmt.Sprintf("Reloaded Prometheus at %s", url))
+		status.Pending = false
+		status.LastSuccessTime = &attemptTime
+		status.LastError = ""
+		setReloadedCondition(config, metav1.ConditionTrue, prometheusv1.ReasonReloadSucceeded, "Prometheus was reloaded after the last output change")
+		r.recordEvent(config, nil, corev1.EventTypeNormal, eventReasonReloaded, eventActionReload, "Reloaded Prometheus at %s", url)
+		return 0
+	}
+
+	logger.Info(fmt.Sprintf("Reload attempt %d failed: %s", status.Attempts, err))
+	status.LastError = err.Error()
+	if failed || status.Attempts >= spec.GetMaxAttempts() {
+		status.Pending = false
+		setReloadedCondition(config, metav1.ConditionFalse, prometheusv1.ReasonReloadFailed, fmt.Sprintf("The reload failed after %d attempts, retrying every %s: %s", status.Attempts, reloadRetryInterval, err))
+		if !failed {
+			r.recordEvent(config, nil, corev1.EventTypeWarning, eventReasonReloadFailed, eventActionReload, "Failed to reload Prometheus at %s after %d attempts: %s", url, status.Attempts, err)
+		}
+		return reloadRetryInterval
+	}
+
+	setReloadedCondition(config, metav1.ConditionFalse, prometheusv1.ReasonReloadPending, fmt.Sprintf("Reload attempt %d failed, retrying: %s", status.Attempts, err))
+
+	return getReloadBackoff(status.Attempts)
+}
+
+func (r *AdditionalScrapeConfigReconciler) reload(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig, spec *prometheusv1.ReloadSpec, url string) error {
+	auth, err := r.getEndpointAuth(ctx, config, &spec.PrometheusEndpoint)
+	if nil != err {
+		return err
+	}
+
+	return r.PrometheusClient.Reload(ctx, url, auth)
+}
+
+// getEndpointAuth loads the credentials of the endpoint from the secrets in
+// the namespace of the config.
+func (r *AdditionalScrapeConfigReconciler) getEndpointAuth(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig, endpoint *prometheusv1.PrometheusEndpoint) (prometheus.HTTPAuth, error) {
+	var auth prometheus.HTTPAuth
+	var err error
+	if nil != endpoint.BasicAuth {
+		if auth.Username, err = r.getSecretValue(ctx, config.Namespace, &endpoint.BasicAuth.Username); nil != err {
+			return auth, err
+		}
+		if auth.Password, err = r.getSecretValue(ctx, config.Namespace, &endpoint.BasicAuth.Password); nil != err {
+			return auth, err
+		}
+	}
+	if nil != endpoint.BearerTokenSecret {
+		if auth.BearerToken, err = r.getSecretValue(ctx, config.Namespace, endpoint.BearerTokenSecret); nil != err {
+			return auth, err
+		}
+	}
+
+	return auth, nil
+}
+
+func (r *AdditionalScrapeConfigReconciler) getSecretValue(ctx context.Context, namespace string, selector *corev1.SecretKeySelector) (string, error) {
+	secret, exists, err := r.KubeClient.GetSecretByName(ctx, namespace, selector.Name)
+	if nil != err {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("secret %s/%s not found", namespace, selector.Name)
+	}
+	value, ok := secret.Data[selector.Key]
+	if !ok {
+		return "", fmt.Errorf("key %s not found in secret %s/%s", selector.Key, namespace, selector.Name)
+	}
+
+	return string(value), nil
+}
+
+// getReloadBackoff returns the wait after the given number of failed
+// attempts.
+func getReloadBackoff(attempts int32) time.Duration {
+	backoff := reloadBaseBackoff
+	for i := int32(1); i < attempts && backoff < reloadMaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, reloadMaxBackoff)
+}
+
+func setReloadedCondition(config *prometheusv1.AdditionalScrapeConfig, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+		Type:               prometheusv1.ConditionTypeReloaded,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: config.Generation,
+	})
+}
+
+// getEarlierRequeue returns the shorter of two RequeueAfter values, where 0
+// means no requeue.
+func getEarlierRequeue(first time.Duration, second time.Duration) time.Duration {
+	if first == 0 || (second != 0 && second < first) {
+		return second
+	}
+
+	return first
+}
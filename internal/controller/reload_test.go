@@ -0,0 +1,209 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// newReloadTestServer returns a Prometheus stand-in answering the reload
+// requests with the given status, and the list of the received requests.
+func newReloadTestServer(t *testing.T, status *int) (*httptest.Server, *[]*http.Request) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		w.WriteHeader(*status)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestUpdateReload_ReloadsAfterDelay(t *testing.T) {
+	status := http.StatusOK
+	server, requests := newReloadTestServer(t, &status)
+	recorder := &mockEventRecorder{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}, Recorder: recorder, PrometheusClient: prometheus.NewHTTPClient(time.Second)}
+	config := newTestConfig()
+	config.Spec.Reload = &prometheusv1.ReloadSpec{
+		PrometheusEndpoint: prometheusv1.PrometheusEndpoint{URL: server.URL},
+		Delay:              &metav1.Duration{Duration: time.Minute},
+	}
+	now := time.Now()
+
+	if got := r.updateReload(context.Background(), zap.New(), config, true, now); got != time.Minute {
+		t.Errorf("requeue = %v, want the reload delay", got)
+	}
+	if len(*requests) != 0 {
+		t.Fatalf("got %d reload requests before the delay, want none", len(*requests))
+	}
+	if !meta.IsStatusConditionFalse(config.Status.Conditions, prometheusv1.ConditionTypeReloaded) {
+		t.Errorf("expected the %s condition to be false while pending", prometheusv1.ConditionTypeReloaded)
+	}
+
+	if got := r.updateReload(context.Background(), zap.New(), config, false, now.Add(time.Minute)); got != 0 {
+		t.Errorf("requeue = %v, want none after a successful reload", got)
+	}
+	if len(*requests) != 1 || (*requests)[0].Method != http.MethodPost || (*requests)[0].URL.Path != "/-/reload" {
+		t.Fatalf("requests = %v, want a single POST /-/reload", *requests)
+	}
+	if config.Status.Reload.Pending || nil == config.Status.Reload.LastSuccessTime {
+		t.Errorf("reload status = %+v, want a recorded success", config.Status.Reload)
+	}
+	if !meta.IsStatusConditionTrue(config.Status.Conditions, prometheusv1.ConditionTypeReloaded) {
+		t.Errorf("expected the %s condition to be true", prometheusv1.ConditionTypeReloaded)
+	}
+	if got := len(recorder.findEvents(eventReasonReloaded)); got != 1 {
+		t.Errorf("got %d %s events, want 1", got, eventReasonReloaded)
+	}
+
+	if got := r.updateReload(context.Background(), zap.New(), config, false, now.Add(time.Hour)); got != 0 || len(*requests) != 1 {
+		t.Errorf("requeue = %v, requests = %d, want no reload without an output change", got, len(*requests))
+	}
+}
+
+func TestUpdateReload_RetriesWithBackoff(t *testing.T) {
+	status := http.StatusInternalServerError
+	server, requests := newReloadTestServer(t, &status)
+	recorder := &mockEventRecorder{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}, Recorder: recorder, PrometheusClient: prometheus.NewHTTPClient(time.Second)}
+	config := newTestConfig()
+	config.Spec.Reload = &prometheusv1.ReloadSpec{
+		PrometheusEndpoint: prometheusv1.PrometheusEndpoint{URL: server.URL},
+		Delay:              &metav1.Duration{},
+	}
+	maxAttempts := int32(3)
+	config.Spec.Reload.MaxAttempts = &maxAttempts
+	now := time.Now()
+
+	if got := r.updateReload(context.Background(), zap.New(), config, true, now); got != reloadBaseBackoff {
+		t.Errorf("requeue = %v, want %v after the first failure", got, reloadBaseBackoff)
+	}
+	if got := r.updateReload(context.Background(), zap.New(), config, false, now.Add(time.Second)); got != reloadBaseBackoff-time.Second || len(*requests) != 1 {
+		t.Errorf("requeue = %v, requests = %d, want to wait for the backoff", got, len(*requests))
+	}
+	if got := r.updateReload(context.Background(), zap.New(), config, false, now.Add(reloadBaseBackoff)); got != 2*reloadBaseBackoff {
+		t.Errorf("requeue = %v, want %v after the second failure", got, 2*reloadBaseBackoff)
+	}
+	if got := r.updateReload(context.Background(), zap.New(), config, false, now.Add(time.Hour)); got != reloadRetryInterval {
+		t.Errorf("requeue = %v, want the retry interval after the last attempt", got)
+	}
+
+	condition := meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypeReloaded)
+	if len(*requests) != 3 || condition.Reason != prometheusv1.ReasonReloadFailed || config.Status.Reload.LastError == "" {
+		t.Errorf("requests = %d, condition = %v, status = %+v", len(*requests), condition, config.Status.Reload)
+	}
+	if got := len(recorder.findEvents(eventReasonReloadFailed)); got != 1 {
+		t.Errorf("got %d %s events, want 1", got, eventReasonReloadFailed)
+	}
+
+	status = http.StatusOK
+	r.updateReload(context.Background(), zap.New(), config, true, now.Add(2*time.Hour))
+	if !meta.IsStatusConditionTrue(config.Status.Conditions, prometheusv1.ConditionTypeReloaded) || config.Status.Reload.Attempts != 1 {
+		t.Errorf("expected a new output change to start over, status = %+v", config.Status.Reload)
+	}
+}
+
+func TestUpdateReload_RetriesFailedReloadOnInterval(t *testing.T) {
+	status := http.StatusUnauthorized
+	server, requests := newReloadTestServer(t, &status)
+	recorder := &mockEventRecorder{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}, Recorder: recorder, PrometheusClient: prometheus.NewHTTPClient(time.Second)}
+	config := newTestConfig()
+	config.Spec.Reload = &prometheusv1.ReloadSpec{
+		PrometheusEndpoint: prometheusv1.PrometheusEndpoint{URL: server.URL},
+		Delay:              &metav1.Duration{},
+	}
+	maxAttempts := int32(1)
+	config.Spec.Reload.MaxAttempts = &maxAttempts
+	now := time.Now()
+
+	if got := r.updateReload(context.Background(), zap.New(), config, true, now); got != reloadRetryInterval {
+		t.Fatalf("requeue = %v, want the retry interval after the last attempt", got)
+	}
+	if got := r.updateReload(context.Background(), zap.New(), config, false, now.Add(time.Minute)); got != reloadRetryInterval-time.Minute || len(*requests) != 1 {
+		t.Errorf("requeue = %v, requests = %d, want to wait for the retry interval", got, len(*requests))
+	}
+	if got := r.updateReload(context.Background(), zap.New(), config, false, now.Add(reloadRetryInterval)); got != reloadRetryInterval || len(*requests) != 2 {
+		t.Errorf("requeue = %v, requests = %d, want a retry after the interval", got, len(*requests))
+	}
+	if got := len(recorder.findEvents(eventReasonReloadFailed)); got != 1 {
+		t.Errorf("got %d %s events, want 1 as retries are not reported again", got, eventReasonReloadFailed)
+	}
+
+	// The credentials were fixed, so the next retry succeeds
+	status = http.StatusOK
+	if got := r.updateReload(context.Background(), zap.New(), config, false, now.Add(2*reloadRetryInterval)); got != 0 || len(*requests) != 3 {
+		t.Errorf("requeue = %v, requests = %d, want a successful retry", got, len(*requests))
+	}
+	if !meta.IsStatusConditionTrue(config.Status.Conditions, prometheusv1.ConditionTypeReloaded) || config.Status.Reload.LastError != "" {
+		t.Errorf("expected the retry to succeed, status = %+v", config.Status.Reload)
+	}
+	if got := r.updateReload(context.Background(), zap.New(), config, false, now.Add(3*reloadRetryInterval)); got != 0 || len(*requests) != 3 {
+		t.Errorf("requeue = %v, requests = %d, want no reload after the success", got, len(*requests))
+	}
+}
+
+func TestUpdateReload_SendsCredentials(t *testing.T) {
+	status := http.StatusOK
+	server, requests := newReloadTestServer(t, &status)
+	mock := &mockKubeClient{
+		secretsByName: map[string]*corev1.Secret{
+			"default/prometheus-auth": {Data: map[string][]byte{"user": []byte("admin"), "password": []byte("secret")}},
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock, PrometheusClient: prometheus.NewHTTPClient(time.Second)}
+	config := newTestConfig()
+	config.Spec.Reload = &prometheusv1.ReloadSpec{
+		PrometheusEndpoint: prometheusv1.PrometheusEndpoint{URL: server.URL},
+		Delay:              &metav1.Duration{},
+	}
+	config.Spec.Reload.BasicAuth = &prometheusv1.BasicAuth{
+		Username: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "prometheus-auth"}, Key: "user"},
+		Password: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "prometheus-auth"}, Key: "password"},
+	}
+
+	r.updateReload(context.Background(), zap.New(), config, true, time.Now())
+	if len(*requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*requests))
+	}
+	if user, password, ok := (*requests)[0].BasicAuth(); !ok || user != "admin" || password != "secret" {
+		t.Errorf("basic auth = %s/%s, want admin/secret", user, password)
+	}
+
+	config.Spec.Reload.BasicAuth.Password.Key = "missing"
+	r.updateReload(context.Background(), zap.New(), config, true, time.Now())
+	if len(*requests) != 1 || config.Status.Reload.LastError != "key missing not found in secret default/prometheus-auth" {
+		t.Errorf("requests = %d, last error = %q, want the missing key reported", len(*requests), config.Status.Reload.LastError)
+	}
+}
+
+func TestUpdateReload_ClearsStatusWhenDisabled(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{}
+	config := newTestConfig()
+	config.Status.Reload = &prometheusv1.ReloadStatus{Pending: true}
+	setReloadedCondition(config, metav1.ConditionFalse, prometheusv1.ReasonReloadPending, "")
+
+	r.updateReload(context.Background(), zap.New(), config, true, time.Now())
+
+	if config.Status.Reload != nil || meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypeReloaded) != nil {
+		t.Errorf("status = %+v, want the reload state removed", config.Status)
+	}
+}
+
+func TestGetReloadBackoff(t *testing.T) {
+	for attempts, want := range map[int32]time.Duration{1: 5 * time.Second, 2: 10 * time.Second, 4: 40 * time.Second, 20: reloadMaxBackoff} {
+		if got := getReloadBackoff(attempts); got != want {
+			t.Errorf("backoff after %d attempts = %v, want %v", attempts, got, want)
+		}
+	}
+}
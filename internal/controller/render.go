@@ -0,0 +1,165 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+)
+
+// renderScrapeJob converts the ScrapeJob into Prometheus scrape configs with
+// the given scrape settings. Most ScrapeJobs are rendered into a single job,
+// multi-target exporters with modules into one job per module. If the job
+// can't be rendered, the exclusion reason is returned with the error.
+func renderScrapeJob(target *prometheusv1.ScrapeJob, settings *prometheusv1.ScrapeSettings) ([]prometheus.Job, string, error) {
+	if err := validateScrapeJob(target); nil != err {
+		return nil, prometheusv1.ReasonInvalidScrapeJob, err
+	}
+
+	var jobs []prometheus.Job
+	if probe := target.Spec.Probe; nil != probe {
+		jobs = append(jobs, prometheus.NewMultiTargetJob(
+			target.Spec.JobName,
+			probe.ProberAddress,
+			probe.GetPath(),
+			map[string][]string{"module": {probe.Module}},
+			[]prometheus.StaticConfig{{Targets: probe.Targets, Labels: probe.Labels}},
+		))
+	} else if exporter := target.Spec.MultiTargetExporter; nil != exporter {
+		jobs = renderMultiTargetExporter(target.Spec.JobName, exporter)
+	} else {
+		job := prometheus.Job{
+			JobName:       target.Spec.JobName,
+			StaticConfigs: []prometheus.StaticConfig{},
+		}
+		for _, staticConfig := range target.Spec.StaticConfigs {
+			job.StaticConfigs = append(job.StaticConfigs, prometheus.StaticConfig{
+				Targets: staticConfig.Targets,
+				Labels:  staticConfig.Labels,
+			})
+		}
+		jobs = append(jobs, job)
+	}
+
+	for i := range jobs {
+		applyScrapeSettings(&jobs[i], settings)
+	}
+
+	for _, job := range jobs {
+		if err := prometheus.ValidateJob(job); nil != err {
+			return nil, prometheusv1.ReasonRejectedByPrometheus, err
+		}
+	}
+
+	return jobs, "", nil
+}
+
+// renderMultiTargetExporter returns a job for each module of the exporter,
+// with the module set in the module parameter, or a single job with the
+// configured parameters if there are no modules.
+func renderMultiTargetExporter(jobName string, exporter *prometheusv1.ScrapeJobMultiTargetExporter) []prometheus.Job {
+	staticConfigs := []prometheus.StaticConfig{{Targets: exporter.Targets, Labels: exporter.Labels}}
+	if len(exporter.Modules) == 0 {
+		return []prometheus.Job{prometheus.NewMultiTargetJob(jobName, exporter.Address, exporter.Path, exporter.Params, staticConfigs)}
+	}
+
+	jobNames := exporter.GetJobNames(jobName)
+	jobs := make([]prometheus.Job, 0, len(exporter.Modules))
+	for i, module := range exporter.Modules {
+		params := make(map[string][]string, len(exporter.Params)+1)
+		for name, values := range exporter.Params {
+			params[name] = values
+		}
+		params["module"] = []string{module}
+		jobs = append(jobs, prometheus.NewMultiTargetJob(jobNames[i], exporter.Address, exporter.Path, params, staticConfigs))
+	}
+
+	return jobs
+}
+
+// validateScrapeJob returns an error describing why the job would render into
+// an invalid Prometheus scrape config.
+func validateScrapeJob(job *prometheusv1.ScrapeJob) error {
+	if job.Spec.JobName == "" {
+		return errors.New("jobName is empty")
+	}
+
+	for i, staticConfig := range job.Spec.StaticConfigs {
+		if err := validateTargets(fmt.Sprintf("staticConfigs[%d]", i), staticConfig.Targets); nil != err {
+			return err
+		}
+	}
+
+	if probe := job.Spec.Probe; nil != probe {
+		if len(job.Spec.StaticConfigs) > 0 {
+			return errors.New("staticConfigs and probe are mutually exclusive")
+		}
+		if strings.TrimSpace(probe.ProberAddress) == "" {
+			return errors.New("probe.proberAddress is empty")
+		}
+		if strings.TrimSpace(probe.Module) == "" {
+			return errors.New("probe.module is empty")
+		}
+		if err := validateTargets("probe", probe.Targets); nil != err {
+			return err
+		}
+	}
+
+	if exporter := job.Spec.MultiTargetExporter; nil != exporter {
+		if len(job.Spec.StaticConfigs) > 0 || nil != job.Spec.Probe {
+			return errors.New("multiTargetExporter is mutually exclusive with staticConfigs and probe")
+		}
+		if strings.TrimSpace(exporter.Address) == "" {
+			return errors.New("multiTargetExporter.address is empty")
+		}
+		if strings.TrimSpace(exporter.Path) == "" {
+			return errors.New("multiTargetExporter.path is empty")
+		}
+		seen := make(map[string]bool, len(exporter.Modules))
+		for _, module := range exporter.Modules {
+			if strings.TrimSpace(module) == "" {
+				return errors.New("multiTargetExporter has an empty module")
+			}
+			if seen[module] {
+				return fmt.Errorf("multiTargetExporter has the module %s more than once", module)
+			}
+			seen[module] = true
+		}
+		if err := validateTargets("multiTargetExporter", exporter.Targets); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validateTargets(path string, targets []string) error {
+	if len(targets) == 0 {
+		return fmt.Errorf("%s has no targets", path)
+	}
+	for _, target := range targets {
+		if strings.TrimSpace(target) == "" {
+			return fmt.Errorf("%s has an empty target", path)
+		}
+	}
+
+	return nil
+}
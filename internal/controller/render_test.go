@@ -0,0 +1,177 @@
+package controller
+
+import (
+	"testing"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+)
+
+func TestValidateScrapeJob(t *testing.T) {
+	tests := map[string]struct {
+		spec    prometheusv1.ScrapeJobSpec
+		wantErr bool
+	}{
+		"valid": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "job",
+				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"host:80"}}},
+			},
+		},
+		"empty job name": {
+			spec:    prometheusv1.ScrapeJobSpec{},
+			wantErr: true,
+		},
+		"no targets": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "job",
+				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{{}},
+			},
+			wantErr: true,
+		},
+		"empty target": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "job",
+				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{" "}}},
+			},
+			wantErr: true,
+		},
+		"valid probe": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName: "job",
+				Probe:   &prometheusv1.ScrapeJobProbe{ProberAddress: "blackbox:9115", Module: "http_2xx", Targets: []string{"https://example.com"}},
+			},
+		},
+		"probe without module": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName: "job",
+				Probe:   &prometheusv1.ScrapeJobProbe{ProberAddress: "blackbox:9115", Targets: []string{"https://example.com"}},
+			},
+			wantErr: true,
+		},
+		"probe without targets": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName: "job",
+				Probe:   &prometheusv1.ScrapeJobProbe{ProberAddress: "blackbox:9115", Module: "http_2xx"},
+			},
+			wantErr: true,
+		},
+		"valid multi-target exporter": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName:             "job",
+				MultiTargetExporter: &prometheusv1.ScrapeJobMultiTargetExporter{Address: "snmp:9116", Path: "/snmp", Modules: []string{"if_mib"}, Targets: []string{"switch"}},
+			},
+		},
+		"multi-target exporter with duplicate modules": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName:             "job",
+				MultiTargetExporter: &prometheusv1.ScrapeJobMultiTargetExporter{Address: "snmp:9116", Path: "/snmp", Modules: []string{"if_mib", "if_mib"}, Targets: []string{"switch"}},
+			},
+			wantErr: true,
+		},
+		"multi-target exporter without path": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName:             "job",
+				MultiTargetExporter: &prometheusv1.ScrapeJobMultiTargetExporter{Address: "snmp:9116", Targets: []string{"switch"}},
+			},
+			wantErr: true,
+		},
+		"multi-target exporter with probe": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName:             "job",
+				Probe:               &prometheusv1.ScrapeJobProbe{ProberAddress: "blackbox:9115", Module: "http_2xx", Targets: []string{"https://example.com"}},
+				MultiTargetExporter: &prometheusv1.ScrapeJobMultiTargetExporter{Address: "snmp:9116", Path: "/snmp", Targets: []string{"switch"}},
+			},
+			wantErr: true,
+		},
+		"probe with static configs": {
+			spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "job",
+				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"host:80"}}},
+				Probe:         &prometheusv1.ScrapeJobProbe{ProberAddress: "blackbox:9115", Module: "http_2xx", Targets: []string{"https://example.com"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateScrapeJob(&prometheusv1.ScrapeJob{Spec: tt.spec})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderScrapeJob_Probe(t *testing.T) {
+	jobs, _, err := renderScrapeJob(&prometheusv1.ScrapeJob{Spec: prometheusv1.ScrapeJobSpec{
+		JobName: "probe",
+		Probe: &prometheusv1.ScrapeJobProbe{
+			ProberAddress: "blackbox:9115",
+			Module:        "http_2xx",
+			Targets:       []string{"https://example.com/health"},
+			Labels:        map[string]string{"env": "prod"},
+		},
+	}}, &prometheusv1.ScrapeSettings{})
+	if nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(jobs) != 1 {
+		t.Fatalf("got %d jobs, want 1", len(jobs))
+	}
+
+	job := jobs[0]
+	if job.MetricsPath != prometheusv1.DefaultProbePath || len(job.Params["module"]) != 1 || job.Params["module"][0] != "http_2xx" {
+		t.Errorf("metrics path = %s, params = %v, want the probe path and the module", job.MetricsPath, job.Params)
+	}
+	if len(job.StaticConfigs) != 1 || job.StaticConfigs[0].Targets[0] != "https://example.com/health" || job.StaticConfigs[0].Labels["env"] != "prod" {
+		t.Errorf("static configs = %+v, want the probed targets", job.StaticConfigs)
+	}
+	if len(job.RelabelConfigs) != 3 || job.RelabelConfigs[2].Replacement != "blackbox:9115" {
+		t.Errorf("relabel configs = %+v, want the address replaced with the prober", job.RelabelConfigs)
+	}
+}
+
+func TestRenderScrapeJob_MultiTargetExporter(t *testing.T) {
+	jobs, _, err := renderScrapeJob(&prometheusv1.ScrapeJob{Spec: prometheusv1.ScrapeJobSpec{
+		JobName: "snmp",
+		MultiTargetExporter: &prometheusv1.ScrapeJobMultiTargetExporter{
+			Address: "snmp-exporter:9116",
+			Path:    "/snmp",
+			Params:  map[string][]string{"auth": {"public_v2"}, "module": {"ignored"}},
+			Modules: []string{"if_mib", "ip_mib"},
+			Targets: []string{"192.168.1.2", "switch.local"},
+		},
+	}}, &prometheusv1.ScrapeSettings{})
+	if nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(jobs) != 2 || jobs[0].JobName != "snmp_if_mib" || jobs[1].JobName != "snmp_ip_mib" {
+		t.Fatalf("jobs = %+v, want one job per module", jobs)
+	}
+	for i, module := range []string{"if_mib", "ip_mib"} {
+		params := jobs[i].Params
+		if len(params["module"]) != 1 || params["module"][0] != module || len(params["auth"]) != 1 || params["auth"][0] != "public_v2" {
+			t.Errorf("params of %s = %v, want module %s and the shared auth", jobs[i].JobName, params, module)
+		}
+		if jobs[i].MetricsPath != "/snmp" || jobs[i].RelabelConfigs[2].Replacement != "snmp-exporter:9116" {
+			t.Errorf("job %s scrapes %s%s, want the exporter", jobs[i].JobName, jobs[i].RelabelConfigs[2].Replacement, jobs[i].MetricsPath)
+		}
+	}
+
+	jobs, _, err = renderScrapeJob(&prometheusv1.ScrapeJob{Spec: prometheusv1.ScrapeJobSpec{
+		JobName: "json",
+		MultiTargetExporter: &prometheusv1.ScrapeJobMultiTargetExporter{
+			Address: "json-exporter:7979",
+			Path:    "/probe",
+			Targets: []string{"https://example.com/stats.json"},
+		},
+	}}, &prometheusv1.ScrapeSettings{})
+	if nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].JobName != "json" || len(jobs[0].Params) != 0 {
+		t.Errorf("jobs = %+v, want a single job without params", jobs)
+	}
+}
@@ -0,0 +1,302 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// revisionConfigLabel holds the name of the config a revision ConfigMap
+	// belongs to. The ConfigMaps are in the namespace of the config.
+	revisionConfigLabel = "prometheus-static-target.kube-stager.io/config"
+	// revisionNumberLabel holds the revision number of a revision ConfigMap.
+	revisionNumberLabel = "prometheus-static-target.kube-stager.io/revision"
+	// revisionHashLabel holds the hash of the rendered output in a revision
+	// ConfigMap.
+	revisionHashLabel = "prometheus-static-target.kube-stager.io/hash"
+	// revisionDataKey is the ConfigMap key the rendered output is stored in.
+	revisionDataKey = "scrape-configs.yaml"
+)
+
+var (
+	// errPinnedRevisionNotFound is returned when the pinned revision is not in
+	// the history.
+	errPinnedRevisionNotFound = errors.New("pinned revision not found")
+	// errInvalidPinnedRevision is returned when the output stored in the
+	// pinned revision can't be parsed.
+	errInvalidPinnedRevision = errors.New("pinned revision is invalid")
+)
+
+// renderJobs returns the scrape configs written into the secret.
+func renderJobs(jobs []prometheus.Job) ([]byte, error) {
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].JobName < jobs[j].JobName
+	})
+
+	return yaml.Marshal(jobs)
+}
+
+func getRevisionHash(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	// Label values are limited to 63 characters
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+func getRevisionNumber(configMap *corev1.ConfigMap) (int64, bool) {
+	revision, err := strconv.ParseInt(configMap.Labels[revisionNumberLabel], 10, 64)
+
+	return revision, nil == err && revision > 0
+}
+
+// loadRevisions returns the revision ConfigMaps of the config, oldest first.
+// ConfigMaps without a valid revision number are ignored.
+func (r *AdditionalScrapeConfigReconciler) loadRevisions(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig) ([]corev1.ConfigMap, error) {
+	configMapList, err := r.KubeClient.ListConfigMaps(ctx, config.Namespace, map[string]string{revisionConfigLabel: config.Name})
+	if nil != err {
+		return nil, err
+	}
+
+	var revisions []corev1.ConfigMap
+	for _, configMap := range configMapList.Items {
+		if _, ok := getRevisionNumber(&configMap); ok {
+			revisions = append(revisions, configMap)
+		}
+	}
+
+	sort.Slice(revisions, func(i, j int) bool {
+		first, _ := getRevisionNumber(&revisions[i])
+		second, _ := getRevisionNumber(&revisions[j])
+		return first < second
+	})
+
+	return revisions, nil
+}
+
+// getOutputJobs returns the jobs to write into the secret: the rendered jobs,
+// or the jobs of the pinned revision if the config is pinned. If the pinned
+// revision doesn't exist or can't be parsed, errPinnedRevisionNotFound or
+// errInvalidPinnedRevision is returned. The pinned condition is set in the
+// status, but not saved.
+func (r *AdditionalScrapeConfigReconciler) getOutputJobs(config *prometheusv1.AdditionalScrapeConfig, revisions []corev1.ConfigMap, jobs []prometheus.Job) ([]prometheus.Job, error) {
+	if nil != config.Spec.PinnedRevision {
+		return r.getPinnedJobs(config, revisions)
+	}
+
+	meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+		Type:               prometheusv1.ConditionTypePinned,
+		Status:             metav1.ConditionFalse,
+		Reason:             prometheusv1.ReasonNotPinned,
+		Message:            "The rendered ScrapeJobs are written",
+		ObservedGeneration: config.Generation,
+	})
+
+	return jobs, nil
+}
+
+// updateRevisions is called after the jobs were written into the secret. It
+// records them as a new revision if they differ from the latest one, and
+// prunes the revisions over the limit. Pinned configs don't record new
+// revisions. The revisions are set in the status, but not saved.
+func (r *AdditionalScrapeConfigReconciler) updateRevisions(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, revisions []corev1.ConfigMap, jobs []prometheus.Job) error {
+	if nil != config.Spec.PinnedRevision {
+		setRevisionStatus(config, revisions)
+		config.Status.CurrentRevision = *config.Spec.PinnedRevision
+
+		return nil
+	}
+
+	var err error
+	limit := config.Spec.GetRevisionHistoryLimit()
+	if limit > 0 {
+		revisions, err = r.recordRevision(ctx, logger, config, revisions, jobs)
+		if nil != err {
+			return err
+		}
+	}
+
+	for len(revisions) > limit {
+		logger.Info(fmt.Sprintf("Pruning revision ConfigMap %s", revisions[0].Name))
+		if err = r.KubeClient.DeleteConfigMap(ctx, &revisions[0]); nil != err {
+			return err
+		}
+		revisions = revisions[1:]
+	}
+
+	setRevisionStatus(config, revisions)
+	config.Status.CurrentRevision = 0
+	if len(revisions) > 0 {
+		config.Status.CurrentRevision, _ = getRevisionNumber(&revisions[len(revisions)-1])
+	}
+
+	return nil
+}
+
+// recordRevision adds the jobs to the history, unless they are the same as
+// the latest revision.
+func (r *AdditionalScrapeConfigReconciler) recordRevision(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, revisions []corev1.ConfigMap, jobs []prometheus.Job) ([]corev1.ConfigMap, error) {
+	yamlData, err := renderJobs(jobs)
+	if nil != err {
+		return nil, err
+	}
+
+	hash := getRevisionHash(yamlData)
+	var revision int64 = 1
+	if len(revisions) > 0 {
+		latest := revisions[len(revisions)-1]
+		if latest.Labels[revisionHashLabel] == hash {
+			return revisions, nil
+		}
+		latestRevision, _ := getRevisionNumber(&latest)
+		revision = latestRevision + 1
+	}
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-rev-%d", config.Name, revision),
+			Namespace: config.Namespace,
+			Labels: map[string]string{
+				revisionConfigLabel: config.Name,
+				revisionNumberLabel: strconv.FormatInt(revision, 10),
+				revisionHashLabel:   hash,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(config, prometheusv1.GroupVersion.WithKind("AdditionalScrapeConfig")),
+			},
+		},
+		Data: map[string]string{revisionDataKey: string(yamlData)},
+	}
+
+	logger.Info(fmt.Sprintf("Recording revision %d in ConfigMap %s", revision, configMap.Name))
+	err = r.KubeClient.CreateConfigMap(ctx, configMap)
+	if apierrors.IsAlreadyExists(err) {
+		configMap, err = r.replaceRevision(ctx, logger, configMap)
+	}
+	if nil != err {
+		return nil, err
+	}
+
+	return append(revisions, *configMap), nil
+}
+
+// replaceRevision takes over a ConfigMap with the name of a new revision that
+// is missing from the history, like one left behind by a deleted config of the
+// same name or one with edited labels. It is adopted if it holds the same
+// output, and overwritten otherwise.
+func (r *AdditionalScrapeConfigReconciler) replaceRevision(ctx context.Context, logger logr.Logger, configMap *corev1.ConfigMap) (*corev1.ConfigMap, error) {
+	existing, err := r.KubeClient.GetConfigMap(ctx, configMap.Namespace, configMap.Name)
+	if nil != err {
+		return nil, err
+	}
+
+	modified := existing.DeepCopy()
+	if existing.Labels[revisionHashLabel] == configMap.Labels[revisionHashLabel] {
+		logger.Info(fmt.Sprintf("Adopting the existing revision ConfigMap %s", configMap.Name))
+	} else {
+		logger.Info(fmt.Sprintf("Overwriting the existing revision ConfigMap %s", configMap.Name))
+		modified.Data = configMap.Data
+		modified.BinaryData = nil
+	}
+	if nil == modified.Labels {
+		modified.Labels = make(map[string]string)
+	}
+	for key, value := range configMap.Labels {
+		modified.Labels[key] = value
+	}
+	modified.OwnerReferences = configMap.OwnerReferences
+
+	if err = r.KubeClient.PatchConfigMap(ctx, existing, modified); nil != err {
+		return nil, err
+	}
+
+	return modified, nil
+}
+
+// getPinnedJobs returns the jobs of the pinned revision.
+func (r *AdditionalScrapeConfigReconciler) getPinnedJobs(config *prometheusv1.AdditionalScrapeConfig, revisions []corev1.ConfigMap) ([]prometheus.Job, error) {
+	pinned := *config.Spec.PinnedRevision
+	setRevisionStatus(config, revisions)
+
+	for _, configMap := range revisions {
+		if revision, _ := getRevisionNumber(&configMap); revision != pinned {
+			continue
+		}
+
+		// A corrupt or edited revision would otherwise be written as an empty output
+		var jobs []prometheus.Job
+		if err := yaml.Unmarshal([]byte(configMap.Data[revisionDataKey]), &jobs); nil != err {
+			meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+				Type:               prometheusv1.ConditionTypePinned,
+				Status:             metav1.ConditionFalse,
+				Reason:             prometheusv1.ReasonInvalidPinnedRevision,
+				Message:            fmt.Sprintf("Revision %d in ConfigMap %s can't be parsed, the output is left unchanged: %s", pinned, configMap.Name, err),
+				ObservedGeneration: config.Generation,
+			})
+
+			return nil, errInvalidPinnedRevision
+		}
+
+		meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+			Type:               prometheusv1.ConditionTypePinned,
+			Status:             metav1.ConditionTrue,
+			Reason:             prometheusv1.ReasonRevisionPinned,
+			Message:            fmt.Sprintf("Revision %d is written instead of the rendered ScrapeJobs", pinned),
+			ObservedGeneration: config.Generation,
+		})
+
+		return jobs, nil
+	}
+
+	meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+		Type:               prometheusv1.ConditionTypePinned,
+		Status:             metav1.ConditionFalse,
+		Reason:             prometheusv1.ReasonPinnedRevisionNotFound,
+		Message:            fmt.Sprintf("Revision %d is not in the history, the output is left unchanged", pinned),
+		ObservedGeneration: config.Generation,
+	})
+
+	return nil, errPinnedRevisionNotFound
+}
+
+func setRevisionStatus(config *prometheusv1.AdditionalScrapeConfig, revisions []corev1.ConfigMap) {
+	config.Status.Revisions = nil
+	for _, configMap := range revisions {
+		revision, _ := getRevisionNumber(&configMap)
+		config.Status.Revisions = append(config.Status.Revisions, prometheusv1.Revision{
+			Revision:      revision,
+			Hash:          configMap.Labels[revisionHashLabel],
+			ConfigMapName: configMap.Name,
+			CreationTime:  configMap.CreationTimestamp,
+		})
+	}
+}
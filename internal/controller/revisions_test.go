@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// writeRevision goes through the revision handling of a reconcile with a
+// successful write, and returns the jobs written into the secret.
+func writeRevision(t *testing.T, r *AdditionalScrapeConfigReconciler, config *prometheusv1.AdditionalScrapeConfig, target string) []prometheus.Job {
+	t.Helper()
+	revisions, err := r.loadRevisions(context.Background(), config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	jobs, err := r.getOutputJobs(config, revisions, []prometheus.Job{{
+		JobName:       "job",
+		StaticConfigs: []prometheus.StaticConfig{{Targets: []string{target}}},
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = r.updateRevisions(context.Background(), zap.New(), config, revisions, jobs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return jobs
+}
+
+func TestUpdateRevisions_RecordsChangedOutputs(t *testing.T) {
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	config := &prometheusv1.AdditionalScrapeConfig{ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "default"}}
+
+	for _, target := range []string{"host1:80", "host1:80", "host2:80"} {
+		writeRevision(t, r, config, target)
+	}
+
+	if len(mock.configMaps) != 2 {
+		t.Fatalf("got %d revision ConfigMaps, want 2 as unchanged outputs are not recorded", len(mock.configMaps))
+	}
+	if name := mock.configMaps[1].Name; name != "cfg-rev-2" {
+		t.Errorf("latest ConfigMap = %s, want cfg-rev-2", name)
+	}
+	if config.Status.CurrentRevision != 2 || len(config.Status.Revisions) != 2 {
+		t.Errorf("current revision = %d, revisions = %v", config.Status.CurrentRevision, config.Status.Revisions)
+	}
+	if !meta.IsStatusConditionFalse(config.Status.Conditions, prometheusv1.ConditionTypePinned) {
+		t.Errorf("expected the %s condition to be false", prometheusv1.ConditionTypePinned)
+	}
+}
+
+func TestUpdateRevisions_PrunesOverLimit(t *testing.T) {
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	limit := int32(2)
+	config := &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "default"},
+		Spec:       prometheusv1.AdditionalScrapeConfigSpec{RevisionHistoryLimit: &limit},
+	}
+
+	for _, target := range []string{"host1:80", "host2:80", "host3:80"} {
+		writeRevision(t, r, config, target)
+	}
+
+	if len(mock.configMaps) != 2 || mock.configMaps[0].Name != "cfg-rev-2" {
+		t.Errorf("ConfigMaps = %v, want revisions 2 and 3", mock.configMaps)
+	}
+
+	limit = 0
+	writeRevision(t, r, config, "host4:80")
+	if len(mock.configMaps) != 0 || len(config.Status.Revisions) != 0 || config.Status.CurrentRevision != 0 {
+		t.Errorf("expected the history to be removed with a limit of 0, got %v", mock.configMaps)
+	}
+}
+
+func TestUpdateRevisions_ReplacesLeftoverConfigMaps(t *testing.T) {
+	// A ConfigMap of a deleted config with the same name, not yet garbage collected
+	leftover := corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "cfg-rev-1",
+			Namespace:       "default",
+			OwnerReferences: []metav1.OwnerReference{{Kind: "AdditionalScrapeConfig", Name: "cfg", UID: "old"}},
+		},
+		Data: map[string]string{revisionDataKey: "old output"},
+	}
+	mock := &mockKubeClient{configMaps: []corev1.ConfigMap{leftover}}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	config := &prometheusv1.AdditionalScrapeConfig{ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "default", UID: "new"}}
+
+	jobs := writeRevision(t, r, config, "host1:80")
+	yamlData, _ := renderJobs(jobs)
+	if len(mock.configMaps) != 1 || mock.configMaps[0].Data[revisionDataKey] != string(yamlData) {
+		t.Fatalf("ConfigMaps = %v, want the leftover overwritten with the output", mock.configMaps)
+	}
+	if owners := mock.configMaps[0].OwnerReferences; len(owners) != 1 || owners[0].UID != "new" {
+		t.Errorf("owner references = %v, want the config", owners)
+	}
+	if config.Status.CurrentRevision != 1 {
+		t.Errorf("current revision = %d, want 1", config.Status.CurrentRevision)
+	}
+
+	// A ConfigMap with the same output but edited labels is adopted as is
+	mock.configMaps[0].Labels = map[string]string{revisionHashLabel: getRevisionHash(yamlData)}
+	mock.configMaps[0].OwnerReferences = nil
+	writeRevision(t, r, config, "host1:80")
+	if len(mock.configMaps) != 1 || len(mock.patchedConfigMaps) != 2 || mock.configMaps[0].Labels[revisionConfigLabel] != "cfg" {
+		t.Errorf("ConfigMaps = %v, want the existing one adopted", mock.configMaps)
+	}
+}
+
+func TestGetOutputJobs_DoesNotRecordRevision(t *testing.T) {
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	config := &prometheusv1.AdditionalScrapeConfig{ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "default"}}
+
+	// The write of the secret fails after the output jobs were selected
+	jobs := []prometheus.Job{{JobName: "job", StaticConfigs: []prometheus.StaticConfig{{Targets: []string{"host1:80"}}}}}
+	if _, err := r.getOutputJobs(config, nil, jobs); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(mock.configMaps) != 0 || config.Status.CurrentRevision != 0 {
+		t.Errorf("ConfigMaps = %v, current revision = %d, want no revision before the write", mock.configMaps, config.Status.CurrentRevision)
+	}
+}
+
+func TestUpdateRevisions_Pinned(t *testing.T) {
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	config := &prometheusv1.AdditionalScrapeConfig{ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "default"}}
+
+	for _, target := range []string{"host1:80", "host2:80"} {
+		writeRevision(t, r, config, target)
+	}
+
+	pinned := int64(1)
+	config.Spec.PinnedRevision = &pinned
+	jobs := writeRevision(t, r, config, "host3:80")
+	if len(jobs) != 1 || jobs[0].StaticConfigs[0].Targets[0] != "host1:80" {
+		t.Errorf("jobs = %v, want the jobs of revision 1", jobs)
+	}
+	if len(mock.configMaps) != 2 {
+		t.Errorf("got %d revision ConfigMaps, want no new revision while pinned", len(mock.configMaps))
+	}
+	if config.Status.CurrentRevision != 1 || !meta.IsStatusConditionTrue(config.Status.Conditions, prometheusv1.ConditionTypePinned) {
+		t.Errorf("current revision = %d, conditions = %v", config.Status.CurrentRevision, config.Status.Conditions)
+	}
+
+	pinned = 5
+	revisions, err := r.loadRevisions(context.Background(), config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = r.getOutputJobs(config, revisions, nil); !errors.Is(err, errPinnedRevisionNotFound) {
+		t.Fatalf("err = %v, want errPinnedRevisionNotFound", err)
+	}
+	condition := meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypePinned)
+	if condition == nil || condition.Reason != prometheusv1.ReasonPinnedRevisionNotFound {
+		t.Errorf("condition = %v, want reason %s", condition, prometheusv1.ReasonPinnedRevisionNotFound)
+	}
+}
+
+func TestGetOutputJobs_InvalidPinnedRevision(t *testing.T) {
+	pinned := int64(1)
+	config := &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "default"},
+		Spec:       prometheusv1.AdditionalScrapeConfigSpec{PinnedRevision: &pinned},
+	}
+	revisions := []corev1.ConfigMap{{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cfg-rev-1",
+			Namespace: "default",
+			Labels:    map[string]string{revisionConfigLabel: "cfg", revisionNumberLabel: "1"},
+		},
+		Data: map[string]string{revisionDataKey: "not: [a job list"},
+	}}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}}
+
+	jobs, err := r.getOutputJobs(config, revisions, nil)
+	if !errors.Is(err, errInvalidPinnedRevision) || jobs != nil {
+		t.Fatalf("jobs = %v, err = %v, want errInvalidPinnedRevision", jobs, err)
+	}
+	condition := meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypePinned)
+	if condition == nil || condition.Reason != prometheusv1.ReasonInvalidPinnedRevision {
+		t.Errorf("condition = %v, want reason %s", condition, prometheusv1.ReasonInvalidPinnedRevision)
+	}
+}
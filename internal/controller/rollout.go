@@ -0,0 +1,145 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-logr/logr"
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// getSecretChecksum returns the checksum of every key in the secret. Configs
+// sharing a secret get the same checksum, so they don't restart the shared
+// workloads over each other.
+func getSecretChecksum(secret *corev1.Secret) string {
+	keys := make([]string, 0, len(secret.Data))
+	for key := range secret.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		hash.Write([]byte(key))
+		hash.Write([]byte{0})
+		hash.Write(secret.Data[key])
+		hash.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// newWorkload returns an empty workload object of the referenced kind.
+func newWorkload(reference prometheusv1.WorkloadReference, namespace string) (client.Object, error) {
+	objectMeta := metav1.ObjectMeta{Name: reference.Name, Namespace: namespace}
+	switch reference.Kind {
+	case "Deployment":
+		return &appsv1.Deployment{ObjectMeta: objectMeta}, nil
+	case "StatefulSet":
+		return &appsv1.StatefulSet{ObjectMeta: objectMeta}, nil
+	case "DaemonSet":
+		return &appsv1.DaemonSet{ObjectMeta: objectMeta}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported workload kind %s", reference.Kind)
+}
+
+func getPodTemplate(workload client.Object) *corev1.PodTemplateSpec {
+	switch typed := workload.(type) {
+	case *appsv1.Deployment:
+		return &typed.Spec.Template
+	case *appsv1.StatefulSet:
+		return &typed.Spec.Template
+	case *appsv1.DaemonSet:
+		return &typed.Spec.Template
+	}
+
+	return nil
+}
+
+// updateWorkloads sets the checksum annotation of the rollout workloads to the
+// checksum of the secret data, which makes them roll out their pods when the
+// secret changes. Missing workloads are reported in the workloads updated
+// condition, which is set in the status, but not saved.
+func (r *AdditionalScrapeConfigReconciler) updateWorkloads(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, checksum string) error {
+	if len(config.Spec.RolloutWorkloads) == 0 {
+		meta.RemoveStatusCondition(&config.Status.Conditions, prometheusv1.ConditionTypeWorkloadsUpdated)
+		return nil
+	}
+
+	var missing []string
+	for _, reference := range config.Spec.RolloutWorkloads {
+		name := fmt.Sprintf("%s %s/%s", reference.Kind, config.Spec.SecretNamespace, reference.Name)
+
+		workload, err := newWorkload(reference, config.Spec.SecretNamespace)
+		if nil != err {
+			return err
+		}
+		if err = r.KubeClient.GetWorkload(ctx, workload); nil != err {
+			if apierrors.IsNotFound(err) {
+				missing = append(missing, name)
+				continue
+			}
+			return err
+		}
+
+		podTemplate := getPodTemplate(workload)
+		if podTemplate.Annotations[prometheusv1.ChecksumAnnotation] == checksum {
+			continue
+		}
+
+		original := workload.DeepCopyObject().(client.Object)
+		if nil == podTemplate.Annotations {
+			podTemplate.Annotations = make(map[string]string)
+		}
+		podTemplate.Annotations[prometheusv1.ChecksumAnnotation] = checksum
+
+		logger.Info(fmt.Sprintf("Rolling out %s", name))
+		if err = r.KubeClient.PatchWorkload(ctx, original, workload); nil != err {
+			return err
+		}
+		r.recordEvent(config, workload, corev1.EventTypeNormal, eventReasonWorkloadRolledOut, eventActionRollout, "Rolled out %s after the secret changed", name)
+	}
+
+	condition := metav1.Condition{
+		Type:               prometheusv1.ConditionTypeWorkloadsUpdated,
+		Status:             metav1.ConditionTrue,
+		Reason:             prometheusv1.ReasonWorkloadsUpdated,
+		Message:            "Every workload has the checksum of the current secret data",
+		ObservedGeneration: config.Generation,
+	}
+	if len(missing) > 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = prometheusv1.ReasonWorkloadNotFound
+		condition.Message = fmt.Sprintf("Workloads not found: %s", strings.Join(missing, ", "))
+	}
+	meta.SetStatusCondition(&config.Status.Conditions, condition)
+
+	return nil
+}
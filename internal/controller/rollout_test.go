@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestGetSecretChecksum(t *testing.T) {
+	secret := &corev1.Secret{Data: map[string][]byte{"a": []byte("1"), "b": []byte("2")}}
+	checksum := getSecretChecksum(secret)
+
+	if got := getSecretChecksum(secret.DeepCopy()); got != checksum {
+		t.Errorf("checksum changed between calls: %s != %s", got, checksum)
+	}
+
+	secret.Data["b"] = []byte("3")
+	if got := getSecretChecksum(secret); got == checksum {
+		t.Error("expected the checksum to change with the data")
+	}
+
+	// The key boundary is part of the checksum.
+	if getSecretChecksum(&corev1.Secret{Data: map[string][]byte{"a": []byte("b1")}}) ==
+		getSecretChecksum(&corev1.Secret{Data: map[string][]byte{"ab": []byte("1")}}) {
+		t.Error("expected different checksums for different keys")
+	}
+}
+
+func TestUpdateWorkloads(t *testing.T) {
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: "ns"}}
+	daemonSet := &appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "ns"}}
+	kubeClient := &mockKubeClient{workloads: []client.Object{deployment, daemonSet}}
+	recorder := &mockEventRecorder{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: kubeClient, Recorder: recorder}
+	config := newTestConfig()
+	config.Spec.RolloutWorkloads = []prometheusv1.WorkloadReference{
+		{Kind: "Deployment", Name: "prometheus"},
+		{Kind: "DaemonSet", Name: "agent"},
+	}
+
+	if err := r.updateWorkloads(context.Background(), zap.New(), config, "abc"); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kubeClient.patchedWorkloads) != 2 {
+		t.Fatalf("patched workloads = %v, want both", kubeClient.patchedWorkloads)
+	}
+	for _, workload := range kubeClient.workloads {
+		if got := getPodTemplate(workload).Annotations[prometheusv1.ChecksumAnnotation]; got != "abc" {
+			t.Errorf("checksum annotation of %s = %q, want abc", workload.GetName(), got)
+		}
+	}
+	if got := len(recorder.findEvents(eventReasonWorkloadRolledOut)); got != 2 {
+		t.Errorf("got %d %s events, want 2", got, eventReasonWorkloadRolledOut)
+	}
+	if !meta.IsStatusConditionTrue(config.Status.Conditions, prometheusv1.ConditionTypeWorkloadsUpdated) {
+		t.Errorf("expected the %s condition to be true", prometheusv1.ConditionTypeWorkloadsUpdated)
+	}
+
+	// An unchanged checksum doesn't patch the workloads again.
+	if err := r.updateWorkloads(context.Background(), zap.New(), config, "abc"); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(kubeClient.patchedWorkloads) != 2 {
+		t.Errorf("patched workloads = %v, want no new patches", kubeClient.patchedWorkloads)
+	}
+}
+
+func TestUpdateWorkloads_MissingWorkload(t *testing.T) {
+	kubeClient := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: kubeClient, Recorder: &mockEventRecorder{}}
+	config := newTestConfig()
+	config.Spec.RolloutWorkloads = []prometheusv1.WorkloadReference{{Kind: "StatefulSet", Name: "prometheus"}}
+
+	if err := r.updateWorkloads(context.Background(), zap.New(), config, "abc"); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	condition := meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypeWorkloadsUpdated)
+	if nil == condition || condition.Status != metav1.ConditionFalse || condition.Reason != prometheusv1.ReasonWorkloadNotFound {
+		t.Errorf("condition = %+v, want %s", condition, prometheusv1.ReasonWorkloadNotFound)
+	}
+
+	config.Spec.RolloutWorkloads = nil
+	if err := r.updateWorkloads(context.Background(), zap.New(), config, "abc"); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if nil != meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypeWorkloadsUpdated) {
+		t.Errorf("expected the %s condition to be removed without workloads", prometheusv1.ConditionTypeWorkloadsUpdated)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// getInactiveScrapeJobs returns the names of the jobs selected by the config
+// that are outside their active window, sorted.
+func (r *AdditionalScrapeConfigReconciler) getInactiveScrapeJobs(config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList, now time.Time) []string {
+	var inactiveJobs []string
+	for _, target := range targetList.Items {
+		if !target.IsActive(now) && r.selectsNamespace(config, target.Namespace) {
+			inactiveJobs = append(inactiveJobs, getScrapeJobName(&target))
+		}
+	}
+
+	sort.Strings(inactiveJobs)
+
+	return inactiveJobs
+}
+
+// getNextScheduleBoundary returns the time until the next job selected by the
+// config becomes active or expires, or 0 if there is no such job.
+func (r *AdditionalScrapeConfigReconciler) getNextScheduleBoundary(config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList, now time.Time) time.Duration {
+	var next time.Duration
+	addBoundary := func(boundary time.Time) {
+		if until := boundary.Sub(now); until > 0 && (next == 0 || until < next) {
+			next = until
+		}
+	}
+
+	for _, target := range targetList.Items {
+		if !r.selectsNamespace(config, target.Namespace) {
+			continue
+		}
+		if nil != target.Spec.ActiveFrom {
+			addBoundary(target.Spec.ActiveFrom.Time)
+		}
+		if expiry, ok := target.GetExpiryTime(); ok {
+			addBoundary(expiry)
+		}
+	}
+
+	return next
+}
+
+// deleteExpiredScrapeJobs deletes the expired jobs selected by the config.
+func (r *AdditionalScrapeConfigReconciler) deleteExpiredScrapeJobs(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList, now time.Time) error {
+	for i := range targetList.Items {
+		target := &targetList.Items[i]
+		if !target.IsExpired(now) || !target.DeletionTimestamp.IsZero() || !r.selectsNamespace(config, target.Namespace) {
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("Deleting expired ScrapeJob %s", getScrapeJobName(target)))
+		if err := r.KubeClient.DeleteScrapeJob(ctx, target); nil != err {
+			return err
+		}
+		r.recordEvent(config, target, corev1.EventTypeNormal, eventReasonScrapeJobExpired, eventActionDelete, "Deleted expired ScrapeJob %s", getScrapeJobName(target))
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func TestProcessTargets_SkipsInactiveJobs(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	now := time.Now()
+	future := metav1.NewTime(now.Add(2 * time.Hour))
+	past := metav1.NewTime(now.Add(-time.Hour))
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "always", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "always"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "future", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "future", ActiveFrom: &future}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "expired", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "expired", ActiveUntil: &past}},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "ttl", Namespace: "ns1", CreationTimestamp: past},
+				Spec:       prometheusv1.ScrapeJobSpec{JobName: "ttl", TTL: &metav1.Duration{Duration: 90 * time.Minute}},
+			},
+			{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns2"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "other", ActiveUntil: &past}},
+		},
+	}
+
+	discovered, jobs, _ := r.processTargets(config, targets, nil, now)
+	if len(discovered) != 2 || discovered[0] != "ns1/always" || discovered[1] != "ns1/ttl" {
+		t.Errorf("discovered = %v, want [ns1/always ns1/ttl]", discovered)
+	}
+	if len(jobs) != 2 {
+		t.Errorf("jobs = %v, want 2 jobs", jobs)
+	}
+
+	inactive := r.getInactiveScrapeJobs(config, targets, now)
+	if len(inactive) != 2 || inactive[0] != "ns1/expired" || inactive[1] != "ns1/future" {
+		t.Errorf("inactive = %v, want [ns1/expired ns1/future]", inactive)
+	}
+}
+
+func TestGetNextScheduleBoundary(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	now := time.Now()
+	future := metav1.NewTime(now.Add(2 * time.Hour))
+	past := metav1.NewTime(now.Add(-time.Hour))
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "future", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "future", ActiveFrom: &future}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "expired", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "expired", ActiveUntil: &past}},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "ttl", Namespace: "ns1", CreationTimestamp: past},
+				Spec:       prometheusv1.ScrapeJobSpec{JobName: "ttl", TTL: &metav1.Duration{Duration: 90 * time.Minute}},
+			},
+		},
+	}
+
+	if got := r.getNextScheduleBoundary(config, targets, now); got != 30*time.Minute {
+		t.Errorf("next boundary = %v, want the ttl expiry in 30m", got)
+	}
+	if got := r.getNextScheduleBoundary(config, &prometheusv1.ScrapeJobList{}, now); got != 0 {
+		t.Errorf("next boundary = %v, want 0 without scheduled jobs", got)
+	}
+}
+
+func TestDeleteExpiredScrapeJobs(t *testing.T) {
+	mock := &mockKubeClient{}
+	recorder := &mockEventRecorder{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock, Recorder: recorder}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	now := time.Now()
+	future := metav1.NewTime(now.Add(2 * time.Hour))
+	past := metav1.NewTime(now.Add(-time.Hour))
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "always", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "always"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "future", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "future", ActiveFrom: &future}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "expired", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "expired", ActiveUntil: &past}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "ns2"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "other", ActiveUntil: &past}},
+		},
+	}
+
+	if err := r.deleteExpiredScrapeJobs(context.Background(), zap.New(), config, targets, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.deletedScrapeJobs) != 1 || mock.deletedScrapeJobs[0] != "ns1/expired" {
+		t.Errorf("deleted = %v, want only the expired job in a selected namespace", mock.deletedScrapeJobs)
+	}
+	if got := len(recorder.findEvents(eventReasonScrapeJobExpired)); got != 1 {
+		t.Errorf("got %d %s events, want 1", got, eventReasonScrapeJobExpired)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// scrapeJobEventHandler enqueues the configs affected by a ScrapeJob event.
+// Updates requeue the configs selecting either the old or the new version of
+// the job, so a config is refreshed when a job stops matching it. Deletes also
+// requeue every config listing the job in its status, in case the labels of
+// the deleted object are not the ones the config last saw.
+//
+// Configs with a debounce window are only requeued once the window has passed
+// since the first event, the workqueue merges the events arriving meanwhile.
+type scrapeJobEventHandler struct {
+	reconciler *AdditionalScrapeConfigReconciler
+}
+
+var _ handler.EventHandler = &scrapeJobEventHandler{}
+
+func (h *scrapeJobEventHandler) Create(ctx context.Context, e event.CreateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.enqueue(ctx, q, h.reconciler.findConfigsForJobs(ctx, false, e.Object))
+}
+
+func (h *scrapeJobEventHandler) Update(ctx context.Context, e event.UpdateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.enqueue(ctx, q, h.reconciler.findConfigsForJobs(ctx, false, e.ObjectOld, e.ObjectNew))
+}
+
+func (h *scrapeJobEventHandler) Delete(ctx context.Context, e event.DeleteEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.enqueue(ctx, q, h.reconciler.findConfigsForJobs(ctx, true, e.Object))
+}
+
+func (h *scrapeJobEventHandler) Generic(ctx context.Context, e event.GenericEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+	h.enqueue(ctx, q, h.reconciler.findConfigsForJobs(ctx, false, e.Object))
+}
+
+func (h *scrapeJobEventHandler) enqueue(ctx context.Context, q workqueue.TypedRateLimitingInterface[reconcile.Request], requests []reconcile.Request) {
+	for _, request := range requests {
+		if h.reconciler.pendingEvents.add(request.NamespacedName) {
+			coalescedEventsCounter.WithLabelValues(request.Name, request.Namespace).Inc()
+		}
+
+		config, err := h.reconciler.KubeClient.GetAdditionalScrapeConfig(ctx, request.Namespace, request.Name)
+		if nil != err || config.Spec.GetDebounceWindow() <= 0 {
+			q.Add(request)
+			continue
+		}
+
+		// Adding an item that is already waiting keeps the earlier deadline,
+		// so the window is not extended by the later events.
+		q.AddAfter(request, config.Spec.GetDebounceWindow())
+	}
+}
+
+// pendingEvents tracks the configs that have been enqueued for a ScrapeJob
+// event, but haven't been reconciled yet.
+type pendingEvents struct {
+	mu      sync.Mutex
+	pending map[types.NamespacedName]struct{}
+}
+
+// add marks the config as pending, and returns true if it was already pending.
+func (p *pendingEvents) add(key types.NamespacedName) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if nil == p.pending {
+		p.pending = make(map[types.NamespacedName]struct{})
+	}
+	if _, ok := p.pending[key]; ok {
+		return true
+	}
+	p.pending[key] = struct{}{}
+
+	return false
+}
+
+// done clears the pending mark once the reconciliation of the config starts.
+func (p *pendingEvents) done(key types.NamespacedName) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.pending, key)
+}
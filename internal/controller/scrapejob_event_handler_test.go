@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newHandlerTestReconciler() *AdditionalScrapeConfigReconciler {
+	return &AdditionalScrapeConfigReconciler{
+		KubeClient: &mockKubeClient{allConfigs: &prometheusv1.AdditionalScrapeConfigList{
+			Items: []prometheusv1.AdditionalScrapeConfig{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+					Spec: prometheusv1.AdditionalScrapeConfigSpec{
+						ScrapeJobLabels:            map[string]string{"app": "web"},
+						ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{Any: true},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"},
+					Spec: prometheusv1.AdditionalScrapeConfigSpec{
+						ScrapeJobLabels:            map[string]string{"app": "api"},
+						ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{Any: true},
+					},
+					Status: prometheusv1.AdditionalScrapeConfigStatus{
+						DiscoveredScrapeJobs: []string{"ns1/j1"},
+					},
+				},
+			},
+		}},
+	}
+}
+
+func newHandlerTestJob(labels map[string]string) *prometheusv1.ScrapeJob {
+	return &prometheusv1.ScrapeJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns1", Labels: labels},
+	}
+}
+
+func newHandlerTestQueue() workqueue.TypedRateLimitingInterface[reconcile.Request] {
+	return workqueue.NewTypedRateLimitingQueue(workqueue.DefaultTypedControllerRateLimiter[reconcile.Request]())
+}
+
+func getQueuedConfigNames(q workqueue.TypedRateLimitingInterface[reconcile.Request]) map[string]bool {
+	names := make(map[string]bool)
+	for q.Len() > 0 {
+		request, _ := q.Get()
+		names[request.Name] = true
+		q.Done(request)
+	}
+	return names
+}
+
+func TestScrapeJobEventHandler_UpdateRequeuesOldAndNewLabels(t *testing.T) {
+	h := &scrapeJobEventHandler{reconciler: newHandlerTestReconciler()}
+	q := newHandlerTestQueue()
+	defer q.ShutDown()
+
+	h.Update(context.Background(), event.UpdateEvent{
+		ObjectOld: newHandlerTestJob(map[string]string{"app": "web"}),
+		ObjectNew: newHandlerTestJob(map[string]string{"app": "api"}),
+	}, q)
+
+	names := getQueuedConfigNames(q)
+	if len(names) != 2 || !names["web"] || !names["api"] {
+		t.Errorf("queued = %v, want web and api", names)
+	}
+}
+
+func TestScrapeJobEventHandler_CreateUsesCurrentLabels(t *testing.T) {
+	h := &scrapeJobEventHandler{reconciler: newHandlerTestReconciler()}
+	q := newHandlerTestQueue()
+	defer q.ShutDown()
+
+	h.Create(context.Background(), event.CreateEvent{Object: newHandlerTestJob(map[string]string{"app": "web"})}, q)
+
+	names := getQueuedConfigNames(q)
+	if len(names) != 1 || !names["web"] {
+		t.Errorf("queued = %v, want web", names)
+	}
+}
+
+func TestScrapeJobEventHandler_DeleteUsesDiscoveredJobs(t *testing.T) {
+	h := &scrapeJobEventHandler{reconciler: newHandlerTestReconciler()}
+	q := newHandlerTestQueue()
+	defer q.ShutDown()
+
+	h.Delete(context.Background(), event.DeleteEvent{Object: newHandlerTestJob(map[string]string{"app": "web"})}, q)
+
+	names := getQueuedConfigNames(q)
+	if len(names) != 2 || !names["web"] || !names["api"] {
+		t.Errorf("queued = %v, want web and api", names)
+	}
+}
+
+func TestScrapeJobEventHandler_DebouncesEvents(t *testing.T) {
+	r := newHandlerTestReconciler()
+	r.KubeClient.(*mockKubeClient).configsByName = map[string]*prometheusv1.AdditionalScrapeConfig{
+		"default/web": {
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+			Spec: prometheusv1.AdditionalScrapeConfigSpec{
+				DebounceWindow: &metav1.Duration{Duration: time.Hour},
+			},
+		},
+	}
+	h := &scrapeJobEventHandler{reconciler: r}
+	q := newHandlerTestQueue()
+	defer q.ShutDown()
+	coalesced := testutil.ToFloat64(coalescedEventsCounter.WithLabelValues("web", "default"))
+
+	h.Create(context.Background(), event.CreateEvent{Object: newHandlerTestJob(map[string]string{"app": "web"})}, q)
+	h.Create(context.Background(), event.CreateEvent{Object: newHandlerTestJob(map[string]string{"app": "web"})}, q)
+
+	if q.Len() != 0 {
+		t.Errorf("queue length = %d, want the request to wait for the debounce window", q.Len())
+	}
+	if got := testutil.ToFloat64(coalescedEventsCounter.WithLabelValues("web", "default")) - coalesced; got != 1 {
+		t.Errorf("coalesced events = %v, want 1", got)
+	}
+
+	r.pendingEvents.done(types.NamespacedName{Namespace: "default", Name: "web"})
+	h.Create(context.Background(), event.CreateEvent{Object: newHandlerTestJob(map[string]string{"app": "web"})}, q)
+	if got := testutil.ToFloat64(coalescedEventsCounter.WithLabelValues("web", "default")) - coalesced; got != 1 {
+		t.Errorf("coalesced events = %v, want no new coalesced event after the reconciliation", got)
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// updateScrapeJobCondition sets the condition on the job and saves its status
+// if it changed. A false condition is only set if the job already has the
+// condition, so jobs that were never affected aren't written on every
+// reconcile.
+func (r *AdditionalScrapeConfigReconciler) updateScrapeJobCondition(ctx context.Context, target *prometheusv1.ScrapeJob, condition metav1.Condition) error {
+	if condition.Status == metav1.ConditionFalse && nil == meta.FindStatusCondition(target.Status.Conditions, condition.Type) {
+		return nil
+	}
+
+	condition.ObservedGeneration = target.Generation
+	if !meta.SetStatusCondition(&target.Status.Conditions, condition) {
+		return nil
+	}
+
+	return r.KubeClient.UpdateScrapeJobStatus(ctx, target)
+}
+
+// updateExcludedConditions records in the status of the jobs selected by the
+// config whether the config excluded them, and sets their excluded condition.
+// The entries of the config are removed from the deselected jobs.
+func (r *AdditionalScrapeConfigReconciler) updateExcludedConditions(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList, deselected []prometheusv1.ScrapeJob, excludedTargets []prometheusv1.ExcludedScrapeJob, now time.Time) error {
+	owner := getConfigOwnerName(config)
+	excludedByName := make(map[string]prometheusv1.ExcludedScrapeJob, len(excludedTargets))
+	for _, excluded := range excludedTargets {
+		excludedByName[excluded.Name] = excluded
+	}
+
+	for i := range targetList.Items {
+		target := &targetList.Items[i]
+
+		var exclusions []prometheusv1.ScrapeJobExclusion
+		if excluded, ok := excludedByName[getScrapeJobName(target)]; ok {
+			exclusions = setExclusion(target.Status.Exclusions, prometheusv1.ScrapeJobExclusion{
+				Config:  owner,
+				Reason:  excluded.Reason,
+				Message: excluded.Message,
+			})
+		} else if r.selectsNamespace(config, target.Namespace) && (target.Spec.Suspend || !target.IsActive(now)) {
+			// Suspended and inactive jobs are neither rendered nor excluded
+			continue
+		} else {
+			exclusions = removeExclusion(target.Status.Exclusions, owner)
+		}
+
+		if err := r.updateExclusions(ctx, target, exclusions); nil != err {
+			return err
+		}
+	}
+
+	for i := range deselected {
+		target := &deselected[i]
+		if err := r.updateExclusions(ctx, target, removeExclusion(target.Status.Exclusions, owner)); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadDeselectedScrapeJobs returns the jobs in the discovered and excluded
+// lists of the config status that are not in the target list anymore, because
+// their labels changed. The exclusions and target health of the config have to
+// be removed from them too. Deleted jobs are skipped.
+func (r *AdditionalScrapeConfigReconciler) loadDeselectedScrapeJobs(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList) ([]prometheusv1.ScrapeJob, error) {
+	seen := make(map[string]bool, len(targetList.Items))
+	for i := range targetList.Items {
+		seen[getScrapeJobName(&targetList.Items[i])] = true
+	}
+
+	names := append([]string{}, config.Status.DiscoveredScrapeJobs...)
+	for _, excluded := range config.Status.ExcludedScrapeJobs {
+		names = append(names, excluded.Name)
+	}
+
+	var deselected []prometheusv1.ScrapeJob
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		namespace, jobName, _ := strings.Cut(name, "/")
+		target, err := r.KubeClient.GetScrapeJob(ctx, namespace, jobName)
+		if apierrors.IsNotFound(err) {
+			continue
+		}
+		if nil != err {
+			return nil, err
+		}
+		deselected = append(deselected, *target)
+	}
+
+	return deselected, nil
+}
+
+// removeScrapeJobEntries removes the exclusions and target health of the
+// config from the jobs it selects or selected before. Called when the config
+// is deleted.
+func (r *AdditionalScrapeConfigReconciler) removeScrapeJobEntries(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig) error {
+	targetList, err := r.KubeClient.LoadScrapeJobs(ctx, config)
+	if nil != err {
+		return err
+	}
+
+	deselected, err := r.loadDeselectedScrapeJobs(ctx, config, targetList)
+	if nil != err {
+		return err
+	}
+
+	owner := getConfigOwnerName(config)
+	for i := range targetList.Items {
+		target := &targetList.Items[i]
+		if err = r.updateExclusions(ctx, target, removeExclusion(target.Status.Exclusions, owner)); nil != err {
+			return err
+		}
+	}
+	for i := range deselected {
+		target := &deselected[i]
+		if err = r.updateExclusions(ctx, target, removeExclusion(target.Status.Exclusions, owner)); nil != err {
+			return err
+		}
+	}
+
+	return r.updateTargetHealthStatuses(ctx, config, targetList, deselected, nil, nil)
+}
+
+// updateExclusions sets the exclusions of the job and the excluded condition
+// derived from them, and saves the status if either changed. The condition is
+// true while any config excludes the job, so configs rendering and excluding
+// the same job don't overwrite each other.
+func (r *AdditionalScrapeConfigReconciler) updateExclusions(ctx context.Context, target *prometheusv1.ScrapeJob, exclusions []prometheusv1.ScrapeJobExclusion) error {
+	changed := !equality.Semantic.DeepEqual(exclusions, target.Status.Exclusions)
+	target.Status.Exclusions = exclusions
+
+	condition := metav1.Condition{
+		Type:               prometheusv1.ConditionTypeExcluded,
+		Status:             metav1.ConditionFalse,
+		Reason:             prometheusv1.ReasonRendered,
+		Message:            "Not excluded by any AdditionalScrapeConfig",
+		ObservedGeneration: target.Generation,
+	}
+	if len(exclusions) > 0 {
+		messages := make([]string, 0, len(exclusions))
+		for _, exclusion := range exclusions {
+			messages = append(messages, fmt.Sprintf("Excluded by AdditionalScrapeConfig %s: %s", exclusion.Config, exclusion.Message))
+		}
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = exclusions[0].Reason
+		condition.Message = strings.Join(messages, "; ")
+	}
+	// Jobs that were never excluded don't get the condition
+	if condition.Status == metav1.ConditionTrue || nil != meta.FindStatusCondition(target.Status.Conditions, condition.Type) {
+		changed = meta.SetStatusCondition(&target.Status.Conditions, condition) || changed
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return r.KubeClient.UpdateScrapeJobStatus(ctx, target)
+}
+
+// setExclusion returns the exclusions with the entry of the config replaced
+// by the exclusion, sorted by config.
+func setExclusion(exclusions []prometheusv1.ScrapeJobExclusion, exclusion prometheusv1.ScrapeJobExclusion) []prometheusv1.ScrapeJobExclusion {
+	result := append(removeExclusion(exclusions, exclusion.Config), exclusion)
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Config < result[j].Config
+	})
+
+	return result
+}
+
+// removeExclusion returns the exclusions without the entry of the config.
+func removeExclusion(exclusions []prometheusv1.ScrapeJobExclusion, config string) []prometheusv1.ScrapeJobExclusion {
+	var result []prometheusv1.ScrapeJobExclusion
+	for _, exclusion := range exclusions {
+		if exclusion.Config != config {
+			result = append(result, exclusion)
+		}
+	}
+
+	return result
+}
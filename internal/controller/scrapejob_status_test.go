@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProcessTargets_ExcludesJobsThatCantBeRendered(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	config.Name = "cfg-excluded"
+	staticConfigs := []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"host:80"}}}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "j3", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "dup", StaticConfigs: staticConfigs}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "dup", StaticConfigs: staticConfigs}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{StaticConfigs: staticConfigs}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "rejected", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "rejected",
+				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"http://host"}}},
+			}},
+		},
+	}
+
+	discovered, jobs, excluded := r.processTargets(config, targets, nil, time.Now())
+
+	if len(discovered) != 1 || discovered[0] != "ns1/j1" || len(jobs) != 1 {
+		t.Errorf("discovered = %v, jobs = %v, want only the first of the duplicates", discovered, jobs)
+	}
+	want := map[string]string{
+		"ns1/j3":       prometheusv1.ReasonDuplicateJobName,
+		"ns1/invalid":  prometheusv1.ReasonInvalidScrapeJob,
+		"ns1/rejected": prometheusv1.ReasonRejectedByPrometheus,
+	}
+	if len(excluded) != len(want) {
+		t.Fatalf("excluded = %v, want %d jobs", excluded, len(want))
+	}
+	for _, job := range excluded {
+		if want[job.Name] != job.Reason || job.Message == "" {
+			t.Errorf("excluded %s with reason %s and message %q, want reason %s", job.Name, job.Reason, job.Message, want[job.Name])
+		}
+	}
+
+	if got := testutil.ToFloat64(excludedJobsGauge.WithLabelValues("cfg-excluded", config.Namespace)); got != 3 {
+		t.Errorf("excluded gauge = %v, want 3", got)
+	}
+}
+
+func TestUpdateExcludedConditions(t *testing.T) {
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	staticConfigs := []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"host:80"}}}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "j3", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "dup", StaticConfigs: staticConfigs}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "dup", StaticConfigs: staticConfigs}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{StaticConfigs: staticConfigs}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "rejected", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "rejected",
+				StaticConfigs: []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"http://host"}}},
+			}},
+		},
+	}
+	now := time.Now()
+	_, _, excluded := r.processTargets(config, targets, nil, now)
+
+	if err := r.updateExcludedConditions(context.Background(), config, targets, nil, excluded, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.updatedScrapeJobs) != 3 {
+		t.Fatalf("updated jobs = %v, want the 3 excluded jobs", mock.updatedScrapeJobs)
+	}
+	for _, job := range mock.updatedScrapeJobs {
+		if !meta.IsStatusConditionTrue(job.Status.Conditions, prometheusv1.ConditionTypeExcluded) {
+			t.Errorf("expected the %s condition of %s to be true", prometheusv1.ConditionTypeExcluded, job.Name)
+		}
+	}
+
+	mock.updatedScrapeJobs = nil
+	targets.Items[0].Spec.JobName = "fixed"
+	_, _, excluded = r.processTargets(config, targets, nil, now)
+	if err := r.updateExcludedConditions(context.Background(), config, targets, nil, excluded, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.updatedScrapeJobs) != 1 || mock.updatedScrapeJobs[0].Name != "j3" {
+		t.Fatalf("updated jobs = %v, want only the fixed job", mock.updatedScrapeJobs)
+	}
+	condition := meta.FindStatusCondition(mock.updatedScrapeJobs[0].Status.Conditions, prometheusv1.ConditionTypeExcluded)
+	if condition.Status != metav1.ConditionFalse || condition.Reason != prometheusv1.ReasonRendered {
+		t.Errorf("condition = %v, want false with reason %s", condition, prometheusv1.ReasonRendered)
+	}
+}
+
+func TestUpdateExcludedConditions_KeepsEntriesPerConfig(t *testing.T) {
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	rendering := &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "rendering", Namespace: "default"},
+		Spec:       prometheusv1.AdditionalScrapeConfigSpec{ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{Any: true}},
+	}
+	excluding := &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "excluding", Namespace: "default"},
+		Spec:       prometheusv1.AdditionalScrapeConfigSpec{ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{Any: true}},
+	}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "j1", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "dup"}},
+		},
+	}
+	excluded := []prometheusv1.ExcludedScrapeJob{{Name: "ns1/j1", Reason: prometheusv1.ReasonDuplicateJobName, Message: "job name dup is already used"}}
+	now := time.Now()
+
+	// The configs reconcile in turns, only the first round changes the status
+	for i := 0; i < 2; i++ {
+		if err := r.updateExcludedConditions(context.Background(), excluding, targets, nil, excluded, now); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := r.updateExcludedConditions(context.Background(), rendering, targets, nil, nil, now); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(mock.updatedScrapeJobs) != 1 {
+		t.Errorf("got %d status updates, want 1 without flapping between the configs", len(mock.updatedScrapeJobs))
+	}
+	status := targets.Items[0].Status
+	if len(status.Exclusions) != 1 || status.Exclusions[0].Config != "default/excluding" {
+		t.Errorf("exclusions = %+v, want only the entry of the excluding config", status.Exclusions)
+	}
+	condition := meta.FindStatusCondition(status.Conditions, prometheusv1.ConditionTypeExcluded)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != prometheusv1.ReasonDuplicateJobName {
+		t.Errorf("condition = %v, want true with reason %s", condition, prometheusv1.ReasonDuplicateJobName)
+	}
+
+	// Deleting the excluding config removes its entry
+	mock.scrapeJobs = targets
+	if err := r.removeScrapeJobEntries(context.Background(), excluding); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	status = targets.Items[0].Status
+	if len(status.Exclusions) != 0 || !meta.IsStatusConditionFalse(status.Conditions, prometheusv1.ConditionTypeExcluded) {
+		t.Errorf("status = %+v, want no exclusions and a false condition", status)
+	}
+}
+
+func TestUpdateExcludedConditions_RemovesEntriesOfDeselectedJobs(t *testing.T) {
+	excludedJob := &prometheusv1.ScrapeJob{
+		ObjectMeta: metav1.ObjectMeta{Name: "relabelled", Namespace: "ns1"},
+		Spec:       prometheusv1.ScrapeJobSpec{JobName: "relabelled"},
+		Status: prometheusv1.ScrapeJobStatus{Exclusions: []prometheusv1.ScrapeJobExclusion{
+			{Config: "default/cfg", Reason: prometheusv1.ReasonDuplicateJobName},
+			{Config: "default/other", Reason: prometheusv1.ReasonDuplicateJobName},
+		}},
+	}
+	mock := &mockKubeClient{scrapeJobsByName: map[string]*prometheusv1.ScrapeJob{"ns1/relabelled": excludedJob}}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}
+	config.Status.DiscoveredScrapeJobs = []string{"ns1/listed", "ns1/deleted"}
+	config.Status.ExcludedScrapeJobs = []prometheusv1.ExcludedScrapeJob{{Name: "ns1/relabelled", Reason: prometheusv1.ReasonDuplicateJobName}}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "listed", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "listed"}},
+		},
+	}
+
+	deselected, err := r.loadDeselectedScrapeJobs(context.Background(), config, targets)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(deselected) != 1 || deselected[0].Name != "relabelled" {
+		t.Fatalf("deselected = %v, want only the relabelled job, skipping the listed and deleted ones", deselected)
+	}
+
+	if err = r.updateExcludedConditions(context.Background(), config, targets, deselected, nil, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.updatedScrapeJobs) != 1 {
+		t.Fatalf("updated jobs = %v, want only the relabelled job", mock.updatedScrapeJobs)
+	}
+	exclusions := mock.updatedScrapeJobs[0].Status.Exclusions
+	if len(exclusions) != 1 || exclusions[0].Config != "default/other" {
+		t.Errorf("exclusions = %+v, want only the entry of the other config", exclusions)
+	}
+}
+
+func TestRemoveScrapeJobEntries(t *testing.T) {
+	entries := prometheusv1.ScrapeJobStatus{
+		Exclusions: []prometheusv1.ScrapeJobExclusion{{Config: "default/cfg", Reason: prometheusv1.ReasonDuplicateJobName}},
+		Targets:    []prometheusv1.TargetHealth{{Config: "default/cfg", ScrapeURL: "http://host:80/metrics", Health: "up"}},
+	}
+	relabelled := &prometheusv1.ScrapeJob{ObjectMeta: metav1.ObjectMeta{Name: "relabelled", Namespace: "ns1"}, Status: *entries.DeepCopy()}
+	mock := &mockKubeClient{
+		scrapeJobs: &prometheusv1.ScrapeJobList{
+			Items: []prometheusv1.ScrapeJob{
+				{ObjectMeta: metav1.ObjectMeta{Name: "listed", Namespace: "ns1"}, Status: *entries.DeepCopy()},
+			},
+		},
+		scrapeJobsByName: map[string]*prometheusv1.ScrapeJob{"ns1/relabelled": relabelled},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	config := newTestConfig()
+	config.Status.DiscoveredScrapeJobs = []string{"ns1/listed", "ns1/relabelled"}
+
+	if err := r.removeScrapeJobEntries(context.Background(), config); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cleared := make(map[string]bool)
+	for _, job := range mock.updatedScrapeJobs {
+		if len(job.Status.Exclusions) == 0 && len(job.Status.Targets) == 0 {
+			cleared[job.Name] = true
+		}
+	}
+	if !cleared["listed"] || !cleared["relabelled"] {
+		t.Errorf("updated jobs = %+v, want the exclusions and target health of both jobs removed", mock.updatedScrapeJobs)
+	}
+}
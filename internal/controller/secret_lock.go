@@ -0,0 +1,68 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// secretLocks serializes the writes to the same secret. Every apply contains
+// all the keys managed in the secret, so two configs writing the same secret
+// concurrently would drop each other's keys if both read the secret before
+// either of them applied it. The zero value is ready to use.
+type secretLocks struct {
+	mu    sync.Mutex
+	locks map[types.NamespacedName]*secretLock
+}
+
+type secretLock struct {
+	mu sync.Mutex
+	// users is the number of callers holding or waiting for the lock.
+	users int
+}
+
+// lock blocks until the secret is free, and returns the function releasing it.
+func (l *secretLocks) lock(namespace string, name string) func() {
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+
+	l.mu.Lock()
+	if nil == l.locks {
+		l.locks = make(map[types.NamespacedName]*secretLock)
+	}
+	entry, ok := l.locks[key]
+	if !ok {
+		entry = &secretLock{}
+		l.locks[key] = entry
+	}
+	entry.users++
+	l.mu.Unlock()
+
+	entry.mu.Lock()
+
+	return func() {
+		entry.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		entry.users--
+		if entry.users == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
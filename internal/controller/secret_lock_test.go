@@ -0,0 +1,44 @@
+package controller
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSecretLocks_SerializesSameSecret(t *testing.T) {
+	var locks secretLocks
+	unlock := locks.lock("ns", "s")
+
+	acquired := make(chan struct{})
+	go func() {
+		unlockSecond := locks.lock("ns", "s")
+		close(acquired)
+		unlockSecond()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("the second lock of the same secret should block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlockOther := locks.lock("ns", "other")
+	unlockOther()
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("the second lock should be acquired after the first one is released")
+	}
+}
+
+func TestSecretLocks_ReleasesEntries(t *testing.T) {
+	var locks secretLocks
+	locks.lock("ns", "s")()
+	locks.lock("ns", "other")()
+
+	if len(locks.locks) != 0 {
+		t.Errorf("locks = %d, want the released entries to be removed", len(locks.locks))
+	}
+}
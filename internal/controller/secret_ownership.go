@@ -0,0 +1,205 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+)
+
+const (
+	// secretOwnersAnnotation records the keys of a secret managed by the
+	// operator. The value is a JSON object mapping each managed secret key to
+	// the namespace/name of the AdditionalScrapeConfig writing it.
+	secretOwnersAnnotation = "prometheus-static-target.kube-stager.io/owners"
+	// managedSecretLabel marks a secret as managed by the operator.
+	managedSecretLabel = "prometheus-static-target.kube-stager.io/managed"
+)
+
+// secretConflictError is returned when the output of a config can't be
+// written because the secret or the key belongs to someone else.
+type secretConflictError struct {
+	reason  string
+	message string
+}
+
+func (e *secretConflictError) Error() string {
+	return e.message
+}
+
+func getConfigOwnerName(config *prometheusv1.AdditionalScrapeConfig) string {
+	return fmt.Sprintf("%s/%s", config.Namespace, config.Name)
+}
+
+// getSecretKeyOwners returns the key owners recorded on the secret, or nil if
+// the secret is not managed by the operator.
+func getSecretKeyOwners(secret *corev1.Secret) (map[string]string, error) {
+	value, ok := secret.Annotations[secretOwnersAnnotation]
+	if !ok {
+		return nil, nil
+	}
+
+	owners := make(map[string]string)
+	if err := json.Unmarshal([]byte(value), &owners); nil != err {
+		return nil, fmt.Errorf("invalid %s annotation on secret %s/%s: %w", secretOwnersAnnotation, secret.Namespace, secret.Name, err)
+	}
+
+	return owners, nil
+}
+
+// setSecretKeyOwners records the key owners on the secret, and marks it as
+// managed. If there are no owners left, both the annotation and the label are
+// removed.
+func setSecretKeyOwners(secret *corev1.Secret, owners map[string]string) error {
+	if len(owners) == 0 {
+		delete(secret.Annotations, secretOwnersAnnotation)
+		delete(secret.Labels, managedSecretLabel)
+		return nil
+	}
+
+	value, err := json.Marshal(owners)
+	if nil != err {
+		return err
+	}
+
+	if nil == secret.Annotations {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[secretOwnersAnnotation] = string(value)
+
+	if nil == secret.Labels {
+		secret.Labels = make(map[string]string)
+	}
+	secret.Labels[managedSecretLabel] = "true"
+
+	return nil
+}
+
+// getSecretApplyConfiguration returns the fields of the secret owned by the
+// operator: the managed label, the owners annotation and the data of every
+// managed key. All of them have to be part of each apply, as fields owned by
+// the field manager but missing from the apply are removed by the API server.
+func getSecretApplyConfiguration(secret *corev1.Secret, secretExists bool) (*corev1ac.SecretApplyConfiguration, error) {
+	owners, err := getSecretKeyOwners(secret)
+	if nil != err {
+		return nil, err
+	}
+
+	applyConfig := corev1ac.Secret(secret.Name, secret.Namespace).
+		WithLabels(map[string]string{managedSecretLabel: secret.Labels[managedSecretLabel]}).
+		WithAnnotations(map[string]string{secretOwnersAnnotation: secret.Annotations[secretOwnersAnnotation]})
+
+	if !secretExists {
+		applyConfig.WithType(secret.Type)
+	}
+
+	for key := range owners {
+		if value, ok := secret.Data[key]; ok {
+			applyConfig.WithData(map[string][]byte{key: value})
+		}
+	}
+
+	return applyConfig, nil
+}
+
+// checkSecretOwnership returns a secretConflictError if the config is not
+// allowed to write its key into the secret. Secrets without recorded owners
+// are only written if the config adopts them explicitly, or if they look like
+// the config already wrote them before ownership was tracked. Keys owned by a
+// config that was deleted or no longer points at the key are taken over.
+func (r *AdditionalScrapeConfigReconciler) checkSecretOwnership(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig, secret *corev1.Secret, secretExists bool) error {
+	if !secretExists {
+		return nil
+	}
+
+	owners, err := getSecretKeyOwners(secret)
+	if nil != err {
+		return err
+	}
+
+	location := config.Spec.OutputLocation()
+
+	if nil == owners {
+		if config.Spec.AdoptExistingSecret || isPreviousOutputSecret(config, secret, location) {
+			return nil
+		}
+
+		return &secretConflictError{
+			reason:  prometheusv1.ReasonUnmanagedSecret,
+			message: fmt.Sprintf("Secret %s/%s is not managed by the operator, set adoptExistingSecret to take it over", secret.Namespace, secret.Name),
+		}
+	}
+
+	owner, ok := owners[location.SecretKey]
+	if !ok || owner == getConfigOwnerName(config) {
+		return nil
+	}
+
+	stale, err := r.isStaleOwner(ctx, owner, location)
+	if nil != err || stale {
+		return err
+	}
+
+	return &secretConflictError{
+		reason:  prometheusv1.ReasonKeyOwnedByOtherConfig,
+		message: fmt.Sprintf("Key %s in secret %s/%s is owned by AdditionalScrapeConfig %s", location.SecretKey, secret.Namespace, secret.Name, owner),
+	}
+}
+
+// isPreviousOutputSecret returns true if the secret was written by the
+// config before the owners were recorded: either the status shows the config
+// wrote the same location, or the secret is labelled as managed, or its only
+// key is the key of the config, as written by operator versions predating the
+// ownership tracking.
+func isPreviousOutputSecret(config *prometheusv1.AdditionalScrapeConfig, secret *corev1.Secret, location *prometheusv1.OutputLocation) bool {
+	if nil != config.Status.LastOutput && config.Status.LastOutput.SameLocation(location) {
+		return true
+	}
+	if secret.Labels[managedSecretLabel] == "true" {
+		return true
+	}
+
+	_, ok := secret.Data[location.SecretKey]
+
+	return ok && len(secret.Data) == 1
+}
+
+// isStaleOwner returns true if the owning config no longer exists, is being
+// deleted or renders into a different location.
+func (r *AdditionalScrapeConfigReconciler) isStaleOwner(ctx context.Context, owner string, location *prometheusv1.OutputLocation) (bool, error) {
+	namespace, name, found := strings.Cut(owner, "/")
+	if !found {
+		return true, nil
+	}
+
+	ownerConfig, err := r.KubeClient.GetAdditionalScrapeConfig(ctx, namespace, name)
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	if nil != err {
+		return false, err
+	}
+
+	return !ownerConfig.DeletionTimestamp.IsZero() || !ownerConfig.Spec.OutputLocation().SameLocation(location), nil
+}
@@ -0,0 +1,249 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func newOwnedSecret(owners string) *corev1.Secret {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "s", Namespace: "ns"},
+		Data:       map[string][]byte{},
+	}
+	if owners != "" {
+		secret.Annotations = map[string]string{secretOwnersAnnotation: owners}
+	}
+	return secret
+}
+
+func assertConflictReason(t *testing.T, err error, reason string) {
+	t.Helper()
+	var conflictErr *secretConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected a secretConflictError, got %v", err)
+	}
+	if conflictErr.reason != reason {
+		t.Errorf("reason = %q, want %q", conflictErr.reason, reason)
+	}
+}
+
+func TestCheckSecretOwnership_NewSecret(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}}
+
+	if err := r.checkSecretOwnership(context.Background(), newTestConfig(), newOwnedSecret(""), false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckSecretOwnership_UnmanagedSecret(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}}
+	config := newTestConfig()
+
+	err := r.checkSecretOwnership(context.Background(), config, newOwnedSecret(""), true)
+	assertConflictReason(t, err, prometheusv1.ReasonUnmanagedSecret)
+
+	config.Spec.AdoptExistingSecret = true
+	if err = r.checkSecretOwnership(context.Background(), config, newOwnedSecret(""), true); err != nil {
+		t.Errorf("unexpected error with adoptExistingSecret: %v", err)
+	}
+}
+
+func TestCheckSecretOwnership_UnmanagedSecretPreviouslyWritten(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}}
+	config := newTestConfig()
+	config.Status.LastOutput = config.Spec.OutputLocation()
+
+	if err := r.checkSecretOwnership(context.Background(), config, newOwnedSecret(""), true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckSecretOwnership_UnmanagedSecretWithOtherKeys(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}}
+	secret := newOwnedSecret("")
+	secret.Data["key"] = []byte("previous output")
+	secret.Data["other"] = []byte("other data")
+
+	err := r.checkSecretOwnership(context.Background(), newTestConfig(), secret, true)
+	assertConflictReason(t, err, prometheusv1.ReasonUnmanagedSecret)
+
+	secret.Labels = map[string]string{managedSecretLabel: "true"}
+	if err = r.checkSecretOwnership(context.Background(), newTestConfig(), secret, true); err != nil {
+		t.Errorf("unexpected error for a secret labelled as managed: %v", err)
+	}
+}
+
+func TestUpdateSecret_UpgradesSecretWithoutOwners(t *testing.T) {
+	var written *corev1ac.SecretApplyConfiguration
+	// Secrets written before the ownership tracking only contain the key of
+	// the config, and the status of the config has no last output yet
+	secret := newOwnedSecret("")
+	secret.Data["key"] = []byte("previous output")
+	mock := &mockKubeClient{
+		secret: secret,
+		applyFn: func(_ context.Context, secret *corev1ac.SecretApplyConfiguration) error {
+			written = secret
+			return nil
+		},
+		secretExists: true,
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+
+	if _, err := r.updateSecret(context.Background(), zap.New(), newTestConfig(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written == nil {
+		t.Fatal("expected the secret to be written")
+	}
+	if got := written.Annotations[secretOwnersAnnotation]; got != `{"key":"default/cfg"}` {
+		t.Errorf("owners annotation = %s", got)
+	}
+	if got := written.Labels[managedSecretLabel]; got != "true" {
+		t.Errorf("managed label = %q, want true", got)
+	}
+}
+
+func TestCheckSecretOwnership_OwnedByOtherConfig(t *testing.T) {
+	other := newTestConfig()
+	other.Name = "other"
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{
+		configsByName: map[string]*prometheusv1.AdditionalScrapeConfig{"default/other": other},
+	}}
+
+	err := r.checkSecretOwnership(context.Background(), newTestConfig(), newOwnedSecret(`{"key":"default/other"}`), true)
+	assertConflictReason(t, err, prometheusv1.ReasonKeyOwnedByOtherConfig)
+}
+
+func TestCheckSecretOwnership_StaleOwner(t *testing.T) {
+	moved := newTestConfig()
+	moved.Name = "moved"
+	moved.Spec.SecretKey = "other-key"
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{
+		configsByName: map[string]*prometheusv1.AdditionalScrapeConfig{"default/moved": moved},
+	}}
+
+	if err := r.checkSecretOwnership(context.Background(), newTestConfig(), newOwnedSecret(`{"key":"default/deleted"}`), true); err != nil {
+		t.Errorf("unexpected error for deleted owner: %v", err)
+	}
+	if err := r.checkSecretOwnership(context.Background(), newTestConfig(), newOwnedSecret(`{"key":"default/moved"}`), true); err != nil {
+		t.Errorf("unexpected error for owner writing a different key: %v", err)
+	}
+}
+
+func TestCheckSecretOwnership_ManagedSecretOtherKey(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}}
+
+	if err := r.checkSecretOwnership(context.Background(), newTestConfig(), newOwnedSecret(`{"other":"default/other"}`), true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCheckSecretOwnership_InvalidAnnotation(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{KubeClient: &mockKubeClient{}}
+
+	err := r.checkSecretOwnership(context.Background(), newTestConfig(), newOwnedSecret("not-json"), true)
+	if err == nil {
+		t.Fatal("expected error for invalid annotation")
+	}
+	var conflictErr *secretConflictError
+	if errors.As(err, &conflictErr) {
+		t.Error("invalid annotation should not be reported as a conflict")
+	}
+}
+
+func TestUpdateSecret_RecordsOwner(t *testing.T) {
+	var written *corev1ac.SecretApplyConfiguration
+	secret := newOwnedSecret(`{"other":"default/other"}`)
+	secret.Data["other"] = []byte("other data")
+	secret.Data["unmanaged"] = []byte("unmanaged data")
+	mock := &mockKubeClient{
+		secret: secret,
+		applyFn: func(_ context.Context, secret *corev1ac.SecretApplyConfiguration) error {
+			written = secret
+			return nil
+		},
+		secretExists: true,
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+
+	if _, err := r.updateSecret(context.Background(), zap.New(), newTestConfig(), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if written == nil {
+		t.Fatal("expected the secret to be written")
+	}
+	if got := written.Annotations[secretOwnersAnnotation]; got != `{"key":"default/cfg","other":"default/other"}` {
+		t.Errorf("owners annotation = %s", got)
+	}
+	if got := written.Labels[managedSecretLabel]; got != "true" {
+		t.Errorf("managed label = %q, want true", got)
+	}
+	if written.Type != nil {
+		t.Errorf("type = %v, want it unset for existing secrets", *written.Type)
+	}
+	if _, ok := written.Data["unmanaged"]; ok {
+		t.Error("unmanaged keys should not be part of the apply")
+	}
+	if string(written.Data["other"]) != "other data" {
+		t.Errorf("Data[other] = %q, want the other managed key to be kept", written.Data["other"])
+	}
+	if _, ok := written.Data["key"]; !ok {
+		t.Error("expected the config's own key to be applied")
+	}
+}
+
+func TestUpdateSecret_ConflictSkipsWrite(t *testing.T) {
+	mock := &mockKubeClient{
+		secret:       newOwnedSecret(""),
+		secretExists: true,
+		applyFn: func(_ context.Context, _ *corev1ac.SecretApplyConfiguration) error {
+			t.Error("unexpected secret write")
+			return nil
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+
+	_, err := r.updateSecret(context.Background(), zap.New(), newTestConfig(), nil)
+	assertConflictReason(t, err, prometheusv1.ReasonUnmanagedSecret)
+}
+
+func TestUpdateSecret_SecretNamespaceNotWatched(t *testing.T) {
+	mock := &mockKubeClient{
+		applyFn: func(_ context.Context, _ *corev1ac.SecretApplyConfiguration) error {
+			t.Error("unexpected secret write")
+			return nil
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock, WatchNamespaces: []string{"default"}}
+
+	_, err := r.updateSecret(context.Background(), zap.New(), newTestConfig(), nil)
+	assertConflictReason(t, err, prometheusv1.ReasonSecretNamespaceNotWatched)
+}
+
+func TestSetConflictCondition(t *testing.T) {
+	r := &AdditionalScrapeConfigReconciler{}
+	config := newTestConfig()
+
+	if !r.setConflictCondition(&secretConflictError{reason: prometheusv1.ReasonUnmanagedSecret, message: "msg"}, config) {
+		t.Error("expected the condition to change")
+	}
+	if config.Status.Conditions[0].Status != metav1.ConditionTrue {
+		t.Errorf("condition status = %s, want True", config.Status.Conditions[0].Status)
+	}
+	if r.setConflictCondition(&secretConflictError{reason: prometheusv1.ReasonUnmanagedSecret, message: "msg"}, config) {
+		t.Error("expected the condition to stay the same")
+	}
+	if !r.setConflictCondition(nil, config) {
+		t.Error("expected the condition to change after the conflict is resolved")
+	}
+	if config.Status.Conditions[0].Reason != prometheusv1.ReasonNoConflict {
+		t.Errorf("condition reason = %s, want %s", config.Status.Conditions[0].Reason, prometheusv1.ReasonNoConflict)
+	}
+}
@@ -0,0 +1,198 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/helper"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// targetsPath is the targets API endpoint of Prometheus.
+const targetsPath = "/api/v1/targets"
+
+// targetHealthPolls tracks the last target health poll of each config. It is
+// kept in memory, so the configs are polled right after a restart.
+type targetHealthPolls struct {
+	mu   sync.Mutex
+	last map[types.NamespacedName]time.Time
+}
+
+// next returns the time the config is due to be polled at.
+func (p *targetHealthPolls) next(key types.NamespacedName, interval time.Duration) time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	last, ok := p.last[key]
+	if !ok {
+		return time.Time{}
+	}
+
+	return last.Add(interval)
+}
+
+func (p *targetHealthPolls) polled(key types.NamespacedName, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if nil == p.last {
+		p.last = make(map[types.NamespacedName]time.Time)
+	}
+	p.last[key] = now
+}
+
+func (p *targetHealthPolls) forget(key types.NamespacedName) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.last, key)
+}
+
+// updateTargetHealth polls the targets API of the Prometheus server once the
+// interval passed since the last poll, and records the health of the targets
+// in the status of the rendered ScrapeJobs. Failed polls only set the target
+// health condition of the config, which is not saved. Returns the time until
+// the next poll, or 0 if polling is disabled.
+func (r *AdditionalScrapeConfigReconciler) updateTargetHealth(ctx context.Context, logger logr.Logger, config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList, deselected []prometheusv1.ScrapeJob, discovered []string, now time.Time) (time.Duration, error) {
+	key := types.NamespacedName{Namespace: config.Namespace, Name: config.Name}
+	spec := config.Spec.TargetHealth
+	if nil == spec {
+		r.targetHealthPolls.forget(key)
+		meta.RemoveStatusCondition(&config.Status.Conditions, prometheusv1.ConditionTypeTargetHealthAvailable)
+		return 0, r.updateTargetHealthStatuses(ctx, config, targetList, deselected, nil, nil)
+	}
+
+	interval := spec.GetInterval()
+	if next := r.targetHealthPolls.next(key, interval); now.Before(next) {
+		return next.Sub(now), nil
+	}
+	r.targetHealthPolls.polled(key, now)
+
+	condition := metav1.Condition{
+		Type:               prometheusv1.ConditionTypeTargetHealthAvailable,
+		Status:             metav1.ConditionTrue,
+		Reason:             prometheusv1.ReasonTargetsPolled,
+		ObservedGeneration: config.Generation,
+	}
+
+	url := spec.GetURL(config.Namespace, targetsPath)
+	targets, err := r.getTargets(ctx, config, spec)
+	if nil != err {
+		logger.Info(fmt.Sprintf("Failed to poll the targets at %s: %s", url, err))
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = prometheusv1.ReasonTargetsPollFailed
+		condition.Message = fmt.Sprintf("Failed to poll the targets at %s: %s", url, err)
+		meta.SetStatusCondition(&config.Status.Conditions, condition)
+		return interval, nil
+	}
+
+	condition.Message = fmt.Sprintf("Polled the targets at %s", url)
+	meta.SetStatusCondition(&config.Status.Conditions, condition)
+
+	targetsByJobName := make(map[string][]prometheus.Target)
+	for _, target := range targets {
+		targetsByJobName[target.ScrapePool] = append(targetsByJobName[target.ScrapePool], target)
+	}
+
+	return interval, r.updateTargetHealthStatuses(ctx, config, targetList, deselected, discovered, targetsByJobName)
+}
+
+func (r *AdditionalScrapeConfigReconciler) getTargets(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig, spec *prometheusv1.TargetHealthSpec) ([]prometheus.Target, error) {
+	auth, err := r.getEndpointAuth(ctx, config, &spec.PrometheusEndpoint)
+	if nil != err {
+		return nil, err
+	}
+
+	return r.PrometheusClient.Targets(ctx, spec.GetURL(config.Namespace, targetsPath), auth)
+}
+
+// updateTargetHealthStatuses replaces the target health reported by the
+// config in the status of the ScrapeJobs, and saves the statuses that
+// changed. Only the discovered jobs get the targets of their job names, the
+// entries of the config are removed from every other job, including the
+// deselected ones.
+func (r *AdditionalScrapeConfigReconciler) updateTargetHealthStatuses(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig, targetList *prometheusv1.ScrapeJobList, deselected []prometheusv1.ScrapeJob, discovered []string, targetsByJobName map[string][]prometheus.Target) error {
+	owner := getConfigOwnerName(config)
+	scrapeJobs := make([]*prometheusv1.ScrapeJob, 0, len(targetList.Items)+len(deselected))
+	for i := range targetList.Items {
+		scrapeJobs = append(scrapeJobs, &targetList.Items[i])
+	}
+	for i := range deselected {
+		scrapeJobs = append(scrapeJobs, &deselected[i])
+	}
+
+	for _, scrapeJob := range scrapeJobs {
+
+		var health []prometheusv1.TargetHealth
+		for _, existing := range scrapeJob.Status.Targets {
+			if existing.Config != owner {
+				health = append(health, existing)
+			}
+		}
+		if helper.StringInStringSlice(getScrapeJobName(scrapeJob), discovered) {
+			for _, jobName := range scrapeJob.GetJobNames() {
+				for _, target := range targetsByJobName[jobName] {
+					health = append(health, newTargetHealth(owner, target))
+				}
+			}
+		}
+		sort.Slice(health, func(i, j int) bool {
+			if health[i].Config != health[j].Config {
+				return health[i].Config < health[j].Config
+			}
+			return health[i].ScrapeURL < health[j].ScrapeURL
+		})
+
+		if equality.Semantic.DeepEqual(health, scrapeJob.Status.Targets) {
+			continue
+		}
+		scrapeJob.Status.Targets = health
+		if err := r.KubeClient.UpdateScrapeJobStatus(ctx, scrapeJob); nil != err {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newTargetHealth(owner string, target prometheus.Target) prometheusv1.TargetHealth {
+	health := prometheusv1.TargetHealth{
+		Config:    owner,
+		ScrapeURL: target.ScrapeURL,
+		Health:    target.Health,
+		LastError: target.LastError,
+	}
+	// Targets not scraped yet have a zero last scrape time
+	if !target.LastScrape.IsZero() {
+		// The status is stored with second precision
+		lastScrape := metav1.NewTime(target.LastScrape.Truncate(time.Second))
+		health.LastScrape = &lastScrape
+	}
+
+	return health
+}
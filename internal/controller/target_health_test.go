@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+const testTargetsResponse = `{"status":"success","data":{"activeTargets":[
+	{"scrapePool":"web","scrapeUrl":"http://web-2:80/metrics","health":"down","lastError":"connection refused","lastScrape":"2024-01-02T03:04:05.678Z"},
+	{"scrapePool":"web","scrapeUrl":"http://web-1:80/metrics","health":"up","lastError":"","lastScrape":"2024-01-02T03:04:05Z"},
+	{"scrapePool":"other","scrapeUrl":"http://other:80/metrics","health":"up","lastError":"","lastScrape":"2024-01-02T03:04:05Z"}
+]}}`
+
+// newTargetsTestServer returns a Prometheus stand-in answering the targets
+// requests with the response, and the number of received requests.
+func newTargetsTestServer(t *testing.T, status int, response string) (*httptest.Server, *int) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestUpdateTargetHealth(t *testing.T) {
+	server, requests := newTargetsTestServer(t, http.StatusOK, testTargetsResponse)
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock, PrometheusClient: prometheus.NewHTTPClient(time.Second)}
+	config := newTestConfig()
+	config.Spec.TargetHealth = &prometheusv1.TargetHealthSpec{PrometheusEndpoint: prometheusv1.PrometheusEndpoint{URL: server.URL}}
+	targetList := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "web"}},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "suspended", Namespace: "ns"},
+				Spec:       prometheusv1.ScrapeJobSpec{JobName: "other", Suspend: true},
+				Status: prometheusv1.ScrapeJobStatus{Targets: []prometheusv1.TargetHealth{
+					{Config: "default/cfg", ScrapeURL: "http://other:80/metrics", Health: "up"},
+					{Config: "default/other-cfg", ScrapeURL: "http://other:80/metrics", Health: "up"},
+				}},
+			},
+		},
+	}
+	now := time.Now()
+
+	requeue, err := r.updateTargetHealth(context.Background(), zap.New(), config, targetList, nil, []string{"ns/web"}, now)
+	if nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requeue != prometheusv1.DefaultTargetHealthInterval || *requests != 1 {
+		t.Errorf("requeue = %v, requests = %d, want a single poll and the default interval", requeue, *requests)
+	}
+	if !meta.IsStatusConditionTrue(config.Status.Conditions, prometheusv1.ConditionTypeTargetHealthAvailable) {
+		t.Errorf("expected the %s condition to be true", prometheusv1.ConditionTypeTargetHealthAvailable)
+	}
+
+	health := targetList.Items[0].Status.Targets
+	if len(health) != 2 || health[0].ScrapeURL != "http://web-1:80/metrics" || health[1].Health != "down" || health[1].LastError != "connection refused" {
+		t.Fatalf("web targets = %+v, want both web targets sorted by URL", health)
+	}
+	if nil == health[1].LastScrape || !health[1].LastScrape.Equal(&metav1.Time{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}) {
+		t.Errorf("last scrape = %v, want it truncated to seconds", health[1].LastScrape)
+	}
+	// Jobs not rendered by the config lose its entries, but keep the others
+	suspended := targetList.Items[1].Status.Targets
+	if len(suspended) != 1 || suspended[0].Config != "default/other-cfg" {
+		t.Errorf("suspended job targets = %+v, want only the entry of the other config", suspended)
+	}
+	if len(mock.updatedScrapeJobs) != 2 {
+		t.Errorf("got %d status updates, want 2", len(mock.updatedScrapeJobs))
+	}
+
+	// No poll before the interval passed.
+	requeue, err = r.updateTargetHealth(context.Background(), zap.New(), config, targetList, nil, []string{"ns/web"}, now.Add(20*time.Second))
+	if nil != err || requeue != 40*time.Second || *requests != 1 {
+		t.Errorf("requeue = %v, requests = %d, err = %v, want no poll before the interval", requeue, *requests, err)
+	}
+
+	// An unchanged poll result doesn't update the statuses again.
+	if _, err = r.updateTargetHealth(context.Background(), zap.New(), config, targetList, nil, []string{"ns/web"}, now.Add(time.Minute)); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if *requests != 2 || len(mock.updatedScrapeJobs) != 2 {
+		t.Errorf("requests = %d, updates = %d, want a poll without status updates", *requests, len(mock.updatedScrapeJobs))
+	}
+
+	// Disabling the polling removes the entries of the config.
+	config.Spec.TargetHealth = nil
+	if _, err = r.updateTargetHealth(context.Background(), zap.New(), config, targetList, nil, []string{"ns/web"}, now.Add(time.Minute)); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targetList.Items[0].Status.Targets) != 0 || nil != meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypeTargetHealthAvailable) {
+		t.Errorf("targets = %+v, conditions = %+v, want both cleared", targetList.Items[0].Status.Targets, config.Status.Conditions)
+	}
+}
+
+func TestUpdateTargetHealth_PollFailure(t *testing.T) {
+	server, _ := newTargetsTestServer(t, http.StatusInternalServerError, "broken")
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock, PrometheusClient: prometheus.NewHTTPClient(time.Second)}
+	config := newTestConfig()
+	config.Spec.TargetHealth = &prometheusv1.TargetHealthSpec{
+		PrometheusEndpoint: prometheusv1.PrometheusEndpoint{URL: server.URL},
+		Interval:           &metav1.Duration{Duration: 5 * time.Minute},
+	}
+	targetList := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"}, Spec: prometheusv1.ScrapeJobSpec{JobName: "web"}},
+		},
+	}
+
+	requeue, err := r.updateTargetHealth(context.Background(), zap.New(), config, targetList, nil, []string{"ns/web"}, time.Now())
+	if nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if requeue != 5*time.Minute {
+		t.Errorf("requeue = %v, want the interval", requeue)
+	}
+	condition := meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypeTargetHealthAvailable)
+	if nil == condition || condition.Reason != prometheusv1.ReasonTargetsPollFailed {
+		t.Errorf("condition = %+v, want %s", condition, prometheusv1.ReasonTargetsPollFailed)
+	}
+	if len(mock.updatedScrapeJobs) != 0 {
+		t.Errorf("got %d status updates, want the statuses left alone", len(mock.updatedScrapeJobs))
+	}
+}
+
+func TestUpdateTargetHealth_RemovesEntriesOfDeselectedJobs(t *testing.T) {
+	mock := &mockKubeClient{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+	config := newTestConfig()
+	deselected := []prometheusv1.ScrapeJob{{
+		ObjectMeta: metav1.ObjectMeta{Name: "relabelled", Namespace: "ns"},
+		Spec:       prometheusv1.ScrapeJobSpec{JobName: "web"},
+		Status: prometheusv1.ScrapeJobStatus{Targets: []prometheusv1.TargetHealth{
+			{Config: "default/cfg", ScrapeURL: "http://web-1:80/metrics", Health: "up"},
+		}},
+	}}
+
+	if _, err := r.updateTargetHealth(context.Background(), zap.New(), config, &prometheusv1.ScrapeJobList{}, deselected, nil, time.Now()); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mock.updatedScrapeJobs) != 1 || len(mock.updatedScrapeJobs[0].Status.Targets) != 0 {
+		t.Errorf("updated jobs = %+v, want the entries of the config removed from the relabelled job", mock.updatedScrapeJobs)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/kubernetes"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// scrapeJobTemplates holds the settings of the templates referenced by the
+// loaded ScrapeJobs, keyed by their kubernetes.TemplateIndexField value.
+// Templates that don't exist are missing.
+type scrapeJobTemplates map[string]*prometheusv1.ScrapeSettings
+
+// loadTemplates loads the templates referenced by the ScrapeJobs.
+func (r *AdditionalScrapeConfigReconciler) loadTemplates(ctx context.Context, targetList *prometheusv1.ScrapeJobList) (scrapeJobTemplates, error) {
+	templates := make(scrapeJobTemplates)
+	for i := range targetList.Items {
+		target := &targetList.Items[i]
+		for _, key := range kubernetes.GetReferencedTemplateIndexKeys(target) {
+			if _, ok := templates[key]; ok {
+				continue
+			}
+			if isClusterTemplateRef(target) && !r.clusterTemplatesAvailable() {
+				continue
+			}
+
+			settings, err := r.loadTemplate(ctx, target)
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			if nil != err {
+				return nil, err
+			}
+			templates[key] = settings
+		}
+	}
+
+	return templates, nil
+}
+
+// clusterTemplatesAvailable returns false while the controller is restricted
+// to namespaces. The namespaced deployment only gets a Role in the watched
+// namespaces, so the cluster scoped ClusterScrapeJobTemplates are neither
+// watched nor loaded, and the ScrapeJobs referencing them are excluded.
+func (r *AdditionalScrapeConfigReconciler) clusterTemplatesAvailable() bool {
+	return len(r.WatchNamespaces) == 0
+}
+
+func isClusterTemplateRef(target *prometheusv1.ScrapeJob) bool {
+	return nil != target.Spec.TemplateRef && target.Spec.TemplateRef.GetKind() == prometheusv1.ClusterScrapeJobTemplateKind
+}
+
+func (r *AdditionalScrapeConfigReconciler) loadTemplate(ctx context.Context, target *prometheusv1.ScrapeJob) (*prometheusv1.ScrapeSettings, error) {
+	ref := target.Spec.TemplateRef
+	if isClusterTemplateRef(target) {
+		template, err := r.KubeClient.GetClusterScrapeJobTemplate(ctx, ref.Name)
+		if nil != err {
+			return nil, err
+		}
+		return &template.Spec.ScrapeSettings, nil
+	}
+
+	template, err := r.KubeClient.GetScrapeJobTemplate(ctx, target.Namespace, ref.Name)
+	if nil != err {
+		return nil, err
+	}
+
+	return &template.Spec.ScrapeSettings, nil
+}
+
+// getSettings returns the scrape settings of the job merged over the ones of
+// its template. Returns an error if the referenced template doesn't exist.
+func (t scrapeJobTemplates) getSettings(target *prometheusv1.ScrapeJob) (prometheusv1.ScrapeSettings, error) {
+	keys := kubernetes.GetReferencedTemplateIndexKeys(target)
+	if len(keys) == 0 {
+		return target.Spec.ScrapeSettings, nil
+	}
+
+	template, ok := t[keys[0]]
+	if !ok {
+		return prometheusv1.ScrapeSettings{}, fmt.Errorf("%s %s not found", target.Spec.TemplateRef.GetKind(), target.Spec.TemplateRef.Name)
+	}
+
+	return target.Spec.ScrapeSettings.Merge(template), nil
+}
+
+// findConfigsForTemplate returns the configs selecting any of the ScrapeJobs
+// referencing the template.
+func (r *AdditionalScrapeConfigReconciler) findConfigsForTemplate(ctx context.Context, template client.Object) []reconcile.Request {
+	kind := prometheusv1.ScrapeJobTemplateKind
+	if _, ok := template.(*prometheusv1.ClusterScrapeJobTemplate); ok {
+		kind = prometheusv1.ClusterScrapeJobTemplateKind
+	}
+
+	scrapeJobList, err := r.KubeClient.FindScrapeJobsForTemplate(ctx, kind, template.GetNamespace(), template.GetName())
+	if err != nil || len(scrapeJobList.Items) == 0 {
+		return []reconcile.Request{}
+	}
+
+	targets := make([]client.Object, 0, len(scrapeJobList.Items))
+	for i := range scrapeJobList.Items {
+		targets = append(targets, &scrapeJobList.Items[i])
+	}
+
+	return r.findConfigsForJobs(ctx, false, targets...)
+}
@@ -0,0 +1,174 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestProcessTargets_MergesTemplates(t *testing.T) {
+	mock := &mockKubeClient{
+		templates: map[string]*prometheusv1.ScrapeJobTemplate{
+			"ns1/tls": {
+				ObjectMeta: metav1.ObjectMeta{Name: "tls", Namespace: "ns1"},
+				Spec: prometheusv1.ScrapeJobTemplateSpec{ScrapeSettings: prometheusv1.ScrapeSettings{
+					ScrapeInterval: "30s",
+					Scheme:         "https",
+					TLSConfig:      &prometheusv1.ScrapeTLSConfig{CAFile: "/etc/prometheus/ca.crt"},
+					RelabelConfigs: []prometheusv1.RelabelConfig{{TargetLabel: "team", Replacement: "platform"}},
+				}},
+			},
+		},
+		clusterTemplates: map[string]*prometheusv1.ClusterScrapeJobTemplate{
+			"slow": {
+				ObjectMeta: metav1.ObjectMeta{Name: "slow"},
+				Spec: prometheusv1.ScrapeJobTemplateSpec{ScrapeSettings: prometheusv1.ScrapeSettings{
+					ScrapeInterval: "5m",
+					ScrapeTimeout:  "1m",
+				}},
+			},
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock, Recorder: &mockEventRecorder{}}
+	config := newTestConfig()
+	config.Spec.ScrapeJobNamespaceSelector = prometheusv1.NamespaceSelector{Any: true}
+	staticConfigs := []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"host:80"}}}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "namespaced", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{
+				JobName:        "namespaced",
+				StaticConfigs:  staticConfigs,
+				TemplateRef:    &prometheusv1.ScrapeJobTemplateReference{Name: "tls"},
+				ScrapeSettings: prometheusv1.ScrapeSettings{ScrapeInterval: "10s", RelabelConfigs: []prometheusv1.RelabelConfig{{TargetLabel: "env", Replacement: "prod"}}},
+			}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "cluster",
+				StaticConfigs: staticConfigs,
+				TemplateRef:   &prometheusv1.ScrapeJobTemplateReference{Kind: prometheusv1.ClusterScrapeJobTemplateKind, Name: "slow"},
+			}},
+			// Namespaced templates are only looked up in the namespace of the job
+			{ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: "ns2"}, Spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "missing",
+				StaticConfigs: staticConfigs,
+				TemplateRef:   &prometheusv1.ScrapeJobTemplateReference{Name: "tls"},
+			}},
+		},
+	}
+
+	templates, err := r.loadTemplates(context.Background(), targets)
+	if nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	discovered, jobs, excluded := r.processTargets(config, targets, templates, time.Now())
+
+	if len(discovered) != 2 || len(jobs) != 2 {
+		t.Fatalf("discovered = %v, want the jobs with existing templates", discovered)
+	}
+	if len(excluded) != 1 || excluded[0].Name != "ns2/missing" || excluded[0].Reason != prometheusv1.ReasonTemplateNotFound {
+		t.Errorf("excluded = %+v, want the job referencing a missing template", excluded)
+	}
+
+	// The jobs are sorted by namespace and name
+	cluster, namespaced := jobs[0], jobs[1]
+	if cluster.ScrapeInterval != "5m" || cluster.ScrapeTimeout != "1m" {
+		t.Errorf("cluster job interval = %s, timeout = %s, want the template settings", cluster.ScrapeInterval, cluster.ScrapeTimeout)
+	}
+	if namespaced.ScrapeInterval != "10s" || namespaced.Scheme != "https" || nil == namespaced.TLSConfig || namespaced.TLSConfig.CAFile != "/etc/prometheus/ca.crt" {
+		t.Errorf("namespaced job = %+v, want its own interval over the template settings", namespaced)
+	}
+	if len(namespaced.RelabelConfigs) != 2 || namespaced.RelabelConfigs[0].TargetLabel != "team" || namespaced.RelabelConfigs[1].TargetLabel != "env" {
+		t.Errorf("relabel configs = %+v, want the template rules first", namespaced.RelabelConfigs)
+	}
+}
+
+func TestFindConfigsForTemplate(t *testing.T) {
+	mock := &mockKubeClient{
+		allConfigs: &prometheusv1.AdditionalScrapeConfigList{
+			Items: []prometheusv1.AdditionalScrapeConfig{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "ns1-only", Namespace: "default"},
+					Spec:       prometheusv1.AdditionalScrapeConfigSpec{ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{MatchNames: []string{"ns1"}}},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "ns2-only", Namespace: "default"},
+					Spec:       prometheusv1.AdditionalScrapeConfigSpec{ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{MatchNames: []string{"ns2"}}},
+				},
+			},
+		},
+		scrapeJobs: &prometheusv1.ScrapeJobList{
+			Items: []prometheusv1.ScrapeJob{
+				{ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{
+					JobName:     "cluster",
+					TemplateRef: &prometheusv1.ScrapeJobTemplateReference{Kind: prometheusv1.ClusterScrapeJobTemplateKind, Name: "slow"},
+				}},
+			},
+		},
+	}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock}
+
+	requests := r.findConfigsForTemplate(context.Background(), &prometheusv1.ClusterScrapeJobTemplate{ObjectMeta: metav1.ObjectMeta{Name: "slow"}})
+	if len(requests) != 1 || requests[0].Name != "ns1-only" {
+		t.Errorf("requests = %v, want only the config selecting the dependent job", requests)
+	}
+
+	template := &prometheusv1.ScrapeJobTemplate{ObjectMeta: metav1.ObjectMeta{Name: "unused", Namespace: "ns1"}}
+	if requests = r.findConfigsForTemplate(context.Background(), template); len(requests) != 0 {
+		t.Errorf("requests = %v, want none for a template without dependent jobs", requests)
+	}
+}
+
+func TestProcessTargets_ExcludesClusterTemplatesWhenNamespaced(t *testing.T) {
+	mock := &mockKubeClient{
+		clusterTemplates: map[string]*prometheusv1.ClusterScrapeJobTemplate{
+			"slow": {
+				ObjectMeta: metav1.ObjectMeta{Name: "slow"},
+				Spec:       prometheusv1.ScrapeJobTemplateSpec{ScrapeSettings: prometheusv1.ScrapeSettings{ScrapeInterval: "5m"}},
+			},
+		},
+	}
+	recorder := &mockEventRecorder{}
+	r := &AdditionalScrapeConfigReconciler{KubeClient: mock, Recorder: recorder, WatchNamespaces: []string{"ns1"}}
+	config := &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "ns1"},
+		Spec: prometheusv1.AdditionalScrapeConfigSpec{
+			ScrapeJobNamespaceSelector: prometheusv1.NamespaceSelector{Any: true},
+		},
+	}
+	staticConfigs := []prometheusv1.ScrapeJobStaticConfig{{Targets: []string{"host:80"}}}
+	targets := &prometheusv1.ScrapeJobList{
+		Items: []prometheusv1.ScrapeJob{
+			{ObjectMeta: metav1.ObjectMeta{Name: "cluster", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "cluster",
+				StaticConfigs: staticConfigs,
+				TemplateRef:   &prometheusv1.ScrapeJobTemplateReference{Kind: prometheusv1.ClusterScrapeJobTemplateKind, Name: "slow"},
+			}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "plain", Namespace: "ns1"}, Spec: prometheusv1.ScrapeJobSpec{
+				JobName:       "plain",
+				StaticConfigs: staticConfigs,
+			}},
+		},
+	}
+
+	// The cluster scoped templates can't be read with the namespaced Role
+	templates, err := r.loadTemplates(context.Background(), targets)
+	if nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(templates) != 0 {
+		t.Errorf("templates = %v, want no ClusterScrapeJobTemplates loaded", templates)
+	}
+
+	discovered, _, excluded := r.processTargets(config, targets, templates, time.Now())
+	if len(discovered) != 1 || discovered[0] != "ns1/plain" {
+		t.Errorf("discovered = %v, want [ns1/plain]", discovered)
+	}
+	if len(excluded) != 1 || excluded[0].Name != "ns1/cluster" || excluded[0].Reason != prometheusv1.ReasonClusterTemplateUnavailable {
+		t.Errorf("excluded = %+v, want the job referencing the ClusterScrapeJobTemplate", excluded)
+	}
+	if got := len(recorder.findEvents(eventReasonClusterTemplateUnavailable)); got != 2 {
+		t.Errorf("got %d %s events, want one on the config and one on the job", got, eventReasonClusterTemplateUnavailable)
+	}
+}
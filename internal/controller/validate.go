@@ -0,0 +1,53 @@
+/*
+Copyright 2023.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// validateOutput checks the assembled jobs with the Prometheus config parser,
+// and sets the output valid condition of the config. Jobs rejected on their
+// own are already excluded while rendering, naming their ScrapeJob.
+func (r *AdditionalScrapeConfigReconciler) validateOutput(config *prometheusv1.AdditionalScrapeConfig, jobs []prometheus.Job) error {
+	err := prometheus.ValidateJobs(jobs)
+	if nil == err {
+		meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+			Type:               prometheusv1.ConditionTypeOutputValid,
+			Status:             metav1.ConditionTrue,
+			Reason:             prometheusv1.ReasonOutputValid,
+			Message:            "The rendered scrape configs are accepted by Prometheus",
+			ObservedGeneration: config.Generation,
+		})
+		return nil
+	}
+
+	meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+		Type:               prometheusv1.ConditionTypeOutputValid,
+		Status:             metav1.ConditionFalse,
+		Reason:             prometheusv1.ReasonInvalidScrapeConfig,
+		Message:            err.Error() + ". The last valid output is kept",
+		ObservedGeneration: config.Generation,
+	})
+	r.recordEvent(config, nil, corev1.EventTypeWarning, eventReasonInvalidOutput, eventActionRender, "%s", err)
+
+	return err
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateOutput(t *testing.T) {
+	recorder := &mockEventRecorder{}
+	r := &AdditionalScrapeConfigReconciler{Recorder: recorder}
+	config := &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "default"},
+	}
+	validJob := prometheus.Job{JobName: "valid", StaticConfigs: []prometheus.StaticConfig{{Targets: []string{"host:80"}}}}
+
+	if err := r.validateOutput(config, []prometheus.Job{validJob}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !meta.IsStatusConditionTrue(config.Status.Conditions, prometheusv1.ConditionTypeOutputValid) {
+		t.Errorf("expected the %s condition to be true", prometheusv1.ConditionTypeOutputValid)
+	}
+
+	if err := r.validateOutput(config, []prometheus.Job{validJob, validJob}); err == nil {
+		t.Fatal("expected error")
+	}
+	condition := meta.FindStatusCondition(config.Status.Conditions, prometheusv1.ConditionTypeOutputValid)
+	if condition == nil || condition.Status != metav1.ConditionFalse || !strings.Contains(condition.Message, "last valid output is kept") {
+		t.Errorf("condition = %v, want false keeping the last valid output", condition)
+	}
+	if got := len(recorder.findEvents(eventReasonInvalidOutput)); got != 1 {
+		t.Errorf("got %d %s events, want 1 on the config", got, eventReasonInvalidOutput)
+	}
+}
@@ -1,5 +1,7 @@
 package helper
 
+import "strings"
+
 func StringInStringSlice(needle string, haystack []string) bool {
 	for _, v := range haystack {
 		if needle == v {
@@ -9,3 +11,17 @@ func StringInStringSlice(needle string, haystack []string) bool {
 
 	return false
 }
+
+// SplitAndTrim splits the string by the separator, trims the whitespace from
+// the parts and drops the empty ones.
+func SplitAndTrim(value string, separator string) []string {
+	var parts []string
+	for _, part := range strings.Split(value, separator) {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	return parts
+}
@@ -15,3 +15,15 @@ var _ = Describe("StringInStringSlice", func() {
 		})
 	})
 })
+
+var _ = Describe("SplitAndTrim", func() {
+	It("Should split the string and trim the parts", func() {
+		Expect(SplitAndTrim(" test1, test2 ,test3", ",")).Should(Equal([]string{"test1", "test2", "test3"}))
+	})
+	It("Should drop the empty parts", func() {
+		Expect(SplitAndTrim("test1,, ,test2,", ",")).Should(Equal([]string{"test1", "test2"}))
+	})
+	It("Should return nil for an empty string", func() {
+		Expect(SplitAndTrim("", ",")).Should(BeNil())
+	})
+})
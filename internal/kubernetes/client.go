@@ -3,28 +3,114 @@ package kubernetes
 import (
 	"context"
 	prometheusv1 "github.com/szeber/kube-stager-prometheus-static-target/api/v1"
+	"github.com/szeber/kube-stager-prometheus-static-target/internal/helper"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// FieldManager is the field manager name used for the secret writes.
+const FieldManager = "prometheus-static-target"
+
+// SecretIndexField is the field index of AdditionalScrapeConfigs by the
+// namespace/name of every secret they reference.
+const SecretIndexField = ".spec.secretRefs"
+
+// GetSecretIndexKey returns the SecretIndexField value of a secret.
+func GetSecretIndexKey(namespace string, name string) string {
+	return namespace + "/" + name
+}
+
+// TemplateIndexField is the field index of ScrapeJobs by the template they
+// reference.
+const TemplateIndexField = ".spec.templateRef"
+
+// GetTemplateIndexKey returns the TemplateIndexField value of a template. The
+// namespace is empty for cluster scoped templates.
+func GetTemplateIndexKey(kind string, namespace string, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// GetReferencedTemplateIndexKeys returns the TemplateIndexField value of the
+// template referenced by the job, if any.
+func GetReferencedTemplateIndexKeys(job *prometheusv1.ScrapeJob) []string {
+	ref := job.Spec.TemplateRef
+	if nil == ref {
+		return nil
+	}
+	if ref.GetKind() == prometheusv1.ClusterScrapeJobTemplateKind {
+		return []string{GetTemplateIndexKey(ref.GetKind(), "", ref.Name)}
+	}
+
+	return []string{GetTemplateIndexKey(ref.GetKind(), job.Namespace, ref.Name)}
+}
+
+// GetReferencedSecretIndexKeys returns the SecretIndexField values of the
+// secrets referenced by the config: the output secret and the credential
+// secrets of the reload and target health endpoints.
+func GetReferencedSecretIndexKeys(config *prometheusv1.AdditionalScrapeConfig) []string {
+	keys := []string{GetSecretIndexKey(config.Spec.SecretNamespace, config.Spec.SecretName)}
+	var endpoints []*prometheusv1.PrometheusEndpoint
+	if nil != config.Spec.Reload {
+		endpoints = append(endpoints, &config.Spec.Reload.PrometheusEndpoint)
+	}
+	if nil != config.Spec.TargetHealth {
+		endpoints = append(endpoints, &config.Spec.TargetHealth.PrometheusEndpoint)
+	}
+	for _, endpoint := range endpoints {
+		for _, name := range endpoint.GetSecretNames() {
+			key := GetSecretIndexKey(config.Namespace, name)
+			if !helper.StringInStringSlice(key, keys) {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	return keys
+}
+
 type ClientInterface interface {
 	GetAdditionalScrapeConfig(ctx context.Context, namespace string, name string) (*prometheusv1.AdditionalScrapeConfig, error)
 	LoadScrapeJobs(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig) (*prometheusv1.ScrapeJobList, error)
+	GetScrapeJob(ctx context.Context, namespace string, name string) (*prometheusv1.ScrapeJob, error)
+	UpdateScrapeJobStatus(ctx context.Context, job *prometheusv1.ScrapeJob) error
+	DeleteScrapeJob(ctx context.Context, job *prometheusv1.ScrapeJob) error
 	GetSecret(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig) (*corev1.Secret, bool, error)
-	CreateOrUpdateSecret(ctx context.Context, secretExists bool, secret *corev1.Secret) error
+	GetSecretByName(ctx context.Context, namespace string, name string) (*corev1.Secret, bool, error)
+	ApplySecret(ctx context.Context, secret *corev1ac.SecretApplyConfiguration) error
+	PatchSecret(ctx context.Context, original *corev1.Secret, modified *corev1.Secret) error
+	DeleteSecret(ctx context.Context, secret *corev1.Secret) error
 	FindAdditionalScrapeConfigsForSecret(ctx context.Context, secret client.Object) (*prometheusv1.AdditionalScrapeConfigList, error)
 	GetAllAdditionalScrapeConfigs(ctx context.Context) (*prometheusv1.AdditionalScrapeConfigList, error)
+	ListConfigMaps(ctx context.Context, namespace string, labels map[string]string) (*corev1.ConfigMapList, error)
+	CreateConfigMap(ctx context.Context, configMap *corev1.ConfigMap) error
+	GetConfigMap(ctx context.Context, namespace string, name string) (*corev1.ConfigMap, error)
+	PatchConfigMap(ctx context.Context, original *corev1.ConfigMap, modified *corev1.ConfigMap) error
+	DeleteConfigMap(ctx context.Context, configMap *corev1.ConfigMap) error
+	GetWorkload(ctx context.Context, workload client.Object) error
+	PatchWorkload(ctx context.Context, original client.Object, modified client.Object) error
+	GetPrometheus(ctx context.Context, prometheus *unstructured.Unstructured) error
+	PatchPrometheus(ctx context.Context, original *unstructured.Unstructured, modified *unstructured.Unstructured) error
+	GetScrapeJobTemplate(ctx context.Context, namespace string, name string) (*prometheusv1.ScrapeJobTemplate, error)
+	GetClusterScrapeJobTemplate(ctx context.Context, name string) (*prometheusv1.ClusterScrapeJobTemplate, error)
+	FindScrapeJobsForTemplate(ctx context.Context, kind string, namespace string, name string) (*prometheusv1.ScrapeJobList, error)
 }
 
 type Client struct {
 	parentClient client.Client
+	// apiReader reads objects the manager doesn't cache: secrets that aren't
+	// managed by the operator yet, workloads and Prometheus objects. It should
+	// be a reader that is not backed by the cache.
+	apiReader client.Reader
 }
 
-func NewClient(parentClient client.Client) *Client {
+func NewClient(parentClient client.Client, apiReader client.Reader) *Client {
 	return &Client{
 		parentClient: parentClient,
+		apiReader:    apiReader,
 	}
 }
 
@@ -37,20 +123,36 @@ func (r *Client) GetAdditionalScrapeConfig(ctx context.Context, namespace string
 
 func (r *Client) LoadScrapeJobs(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig) (*prometheusv1.ScrapeJobList, error) {
 	scrapeJobList := &prometheusv1.ScrapeJobList{}
+	// An empty label set matches every ScrapeJob
 	err := r.parentClient.List(ctx, scrapeJobList, client.MatchingLabels(config.Spec.ScrapeJobLabels))
 
 	return scrapeJobList, err
 }
 
+func (r *Client) GetScrapeJob(ctx context.Context, namespace string, name string) (*prometheusv1.ScrapeJob, error) {
+	job := &prometheusv1.ScrapeJob{}
+	err := r.parentClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, job)
+
+	return job, err
+}
+
+func (r *Client) UpdateScrapeJobStatus(ctx context.Context, job *prometheusv1.ScrapeJob) error {
+	return r.parentClient.Status().Update(ctx, job)
+}
+
+func (r *Client) DeleteScrapeJob(ctx context.Context, job *prometheusv1.ScrapeJob) error {
+	return client.IgnoreNotFound(r.parentClient.Delete(ctx, job))
+}
+
 func (r *Client) GetSecret(ctx context.Context, config *prometheusv1.AdditionalScrapeConfig) (*corev1.Secret, bool, error) {
+	return r.GetSecretByName(ctx, config.Spec.SecretNamespace, config.Spec.SecretName)
+}
+
+func (r *Client) GetSecretByName(ctx context.Context, namespace string, name string) (*corev1.Secret, bool, error) {
 	secret := &corev1.Secret{}
 	secretExists := true
 
-	err := r.parentClient.Get(
-		ctx,
-		client.ObjectKey{Namespace: config.Spec.SecretNamespace, Name: config.Spec.SecretName},
-		secret,
-	)
+	err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, secret)
 
 	if nil != err {
 		statusError, ok := err.(*errors.StatusError)
@@ -58,26 +160,36 @@ func (r *Client) GetSecret(ctx context.Context, config *prometheusv1.AdditionalS
 			return nil, false, err
 		}
 		secretExists = false
-		secret.Namespace = config.Spec.SecretNamespace
-		secret.Name = config.Spec.SecretName
+		secret.Namespace = namespace
+		secret.Name = name
 		secret.Type = corev1.SecretTypeOpaque
 	}
 
 	return secret, secretExists, nil
 }
 
-func (r *Client) CreateOrUpdateSecret(ctx context.Context, secretExists bool, secret *corev1.Secret) error {
-	if secretExists {
-		return r.parentClient.Update(ctx, secret)
-	}
+// ApplySecret creates or updates the secret with server-side apply. Only the
+// fields set in the apply configuration are owned by the operator, conflicts
+// with other field managers are resolved in the operator's favour.
+func (r *Client) ApplySecret(ctx context.Context, secret *corev1ac.SecretApplyConfiguration) error {
+	return r.parentClient.Apply(ctx, secret, client.FieldOwner(FieldManager), client.ForceOwnership)
+}
+
+// PatchSecret sends the difference between the original and the modified
+// secret as a merge patch. Used to remove keys, which server-side apply can't
+// do reliably for fields that are also owned by other field managers.
+func (r *Client) PatchSecret(ctx context.Context, original *corev1.Secret, modified *corev1.Secret) error {
+	return r.parentClient.Patch(ctx, modified, client.MergeFrom(original), client.FieldOwner(FieldManager))
+}
 
-	return r.parentClient.Create(ctx, secret)
+func (r *Client) DeleteSecret(ctx context.Context, secret *corev1.Secret) error {
+	return client.IgnoreNotFound(r.parentClient.Delete(ctx, secret))
 }
 
 func (r *Client) FindAdditionalScrapeConfigsForSecret(ctx context.Context, secret client.Object) (*prometheusv1.AdditionalScrapeConfigList, error) {
 	configList := &prometheusv1.AdditionalScrapeConfigList{}
 	listOpts := &client.ListOptions{
-		FieldSelector: fields.OneTermEqualSelector(".spec.secretName", secret.GetName()),
+		FieldSelector: fields.OneTermEqualSelector(SecretIndexField, GetSecretIndexKey(secret.GetNamespace(), secret.GetName())),
 	}
 	err := r.parentClient.List(ctx, configList, listOpts)
 
@@ -90,3 +202,78 @@ func (r *Client) GetAllAdditionalScrapeConfigs(ctx context.Context) (*prometheus
 
 	return allConfigs, err
 }
+
+// ListConfigMaps lists the ConfigMaps from the API server. ConfigMaps are not
+// cached, so a ConfigMap created by the previous reconcile is always listed.
+func (r *Client) ListConfigMaps(ctx context.Context, namespace string, labels map[string]string) (*corev1.ConfigMapList, error) {
+	configMapList := &corev1.ConfigMapList{}
+	err := r.apiReader.List(ctx, configMapList, client.InNamespace(namespace), client.MatchingLabels(labels))
+
+	return configMapList, err
+}
+
+func (r *Client) CreateConfigMap(ctx context.Context, configMap *corev1.ConfigMap) error {
+	return r.parentClient.Create(ctx, configMap, client.FieldOwner(FieldManager))
+}
+
+func (r *Client) GetConfigMap(ctx context.Context, namespace string, name string) (*corev1.ConfigMap, error) {
+	configMap := &corev1.ConfigMap{}
+	err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, configMap)
+
+	return configMap, err
+}
+
+func (r *Client) PatchConfigMap(ctx context.Context, original *corev1.ConfigMap, modified *corev1.ConfigMap) error {
+	return r.parentClient.Patch(ctx, modified, client.MergeFrom(original), client.FieldOwner(FieldManager))
+}
+
+func (r *Client) DeleteConfigMap(ctx context.Context, configMap *corev1.ConfigMap) error {
+	return client.IgnoreNotFound(r.parentClient.Delete(ctx, configMap))
+}
+
+// GetWorkload loads the workload with the name and namespace set in the
+// object. Workloads are not cached, they are read from the API server.
+func (r *Client) GetWorkload(ctx context.Context, workload client.Object) error {
+	return r.apiReader.Get(ctx, client.ObjectKeyFromObject(workload), workload)
+}
+
+func (r *Client) PatchWorkload(ctx context.Context, original client.Object, modified client.Object) error {
+	return r.parentClient.Patch(ctx, modified, client.MergeFrom(original), client.FieldOwner(FieldManager))
+}
+
+// GetPrometheus loads the Prometheus Operator object with the kind, name and
+// namespace set in the object. They are read from the API server, so the
+// operator doesn't need to watch them.
+func (r *Client) GetPrometheus(ctx context.Context, prometheus *unstructured.Unstructured) error {
+	return r.apiReader.Get(ctx, client.ObjectKeyFromObject(prometheus), prometheus)
+}
+
+func (r *Client) PatchPrometheus(ctx context.Context, original *unstructured.Unstructured, modified *unstructured.Unstructured) error {
+	return r.parentClient.Patch(ctx, modified, client.MergeFrom(original), client.FieldOwner(FieldManager))
+}
+
+func (r *Client) GetScrapeJobTemplate(ctx context.Context, namespace string, name string) (*prometheusv1.ScrapeJobTemplate, error) {
+	template := &prometheusv1.ScrapeJobTemplate{}
+	err := r.parentClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, template)
+
+	return template, err
+}
+
+func (r *Client) GetClusterScrapeJobTemplate(ctx context.Context, name string) (*prometheusv1.ClusterScrapeJobTemplate, error) {
+	template := &prometheusv1.ClusterScrapeJobTemplate{}
+	err := r.parentClient.Get(ctx, client.ObjectKey{Name: name}, template)
+
+	return template, err
+}
+
+// FindScrapeJobsForTemplate lists the ScrapeJobs referencing the template.
+// The namespace is empty for cluster scoped templates.
+func (r *Client) FindScrapeJobsForTemplate(ctx context.Context, kind string, namespace string, name string) (*prometheusv1.ScrapeJobList, error) {
+	scrapeJobList := &prometheusv1.ScrapeJobList{}
+	listOpts := &client.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector(TemplateIndexField, GetTemplateIndexKey(kind, namespace, name)),
+	}
+	err := r.parentClient.List(ctx, scrapeJobList, listOpts)
+
+	return scrapeJobList, err
+}
@@ -8,6 +8,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
@@ -23,6 +24,11 @@ func newFakeClient(objs ...client.Object) client.Client {
 	return fake.NewClientBuilder().WithScheme(newScheme()).WithObjects(objs...).Build()
 }
 
+func newTestClient(objs ...client.Object) *Client {
+	c := newFakeClient(objs...)
+	return NewClient(c, c)
+}
+
 func TestGetAdditionalScrapeConfig_Exists(t *testing.T) {
 	config := &prometheusv1.AdditionalScrapeConfig{
 		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"},
@@ -30,7 +36,7 @@ func TestGetAdditionalScrapeConfig_Exists(t *testing.T) {
 			SecretName: "my-secret",
 		},
 	}
-	c := NewClient(newFakeClient(config))
+	c := newTestClient(config)
 	got, err := c.GetAdditionalScrapeConfig(context.Background(), "default", "test")
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -41,7 +47,7 @@ func TestGetAdditionalScrapeConfig_Exists(t *testing.T) {
 }
 
 func TestGetAdditionalScrapeConfig_NotFound(t *testing.T) {
-	c := NewClient(newFakeClient())
+	c := newTestClient()
 	_, err := c.GetAdditionalScrapeConfig(context.Background(), "default", "nonexistent")
 	if err == nil {
 		t.Fatal("expected error for nonexistent config")
@@ -57,7 +63,7 @@ func TestLoadScrapeJobs_MatchingLabels(t *testing.T) {
 		ObjectMeta: metav1.ObjectMeta{Name: "job2", Namespace: "ns1", Labels: map[string]string{"app": "other"}},
 		Spec:       prometheusv1.ScrapeJobSpec{JobName: "j2"},
 	}
-	c := NewClient(newFakeClient(job1, job2))
+	c := newTestClient(job1, job2)
 
 	config := &prometheusv1.AdditionalScrapeConfig{
 		Spec: prometheusv1.AdditionalScrapeConfigSpec{
@@ -80,7 +86,7 @@ func TestLoadScrapeJobs_NoMatch(t *testing.T) {
 	job := &prometheusv1.ScrapeJob{
 		ObjectMeta: metav1.ObjectMeta{Name: "job1", Namespace: "ns1", Labels: map[string]string{"app": "other"}},
 	}
-	c := NewClient(newFakeClient(job))
+	c := newTestClient(job)
 
 	config := &prometheusv1.AdditionalScrapeConfig{
 		Spec: prometheusv1.AdditionalScrapeConfigSpec{
@@ -102,7 +108,7 @@ func TestGetSecret_Exists(t *testing.T) {
 		Data:       map[string][]byte{"key": []byte("value")},
 		Type:       corev1.SecretTypeOpaque,
 	}
-	c := NewClient(newFakeClient(secret))
+	c := newTestClient(secret)
 
 	config := &prometheusv1.AdditionalScrapeConfig{
 		Spec: prometheusv1.AdditionalScrapeConfigSpec{
@@ -123,7 +129,7 @@ func TestGetSecret_Exists(t *testing.T) {
 }
 
 func TestGetSecret_NotFound(t *testing.T) {
-	c := NewClient(newFakeClient())
+	c := newTestClient()
 
 	config := &prometheusv1.AdditionalScrapeConfig{
 		Spec: prometheusv1.AdditionalScrapeConfigSpec{
@@ -149,49 +155,112 @@ func TestGetSecret_NotFound(t *testing.T) {
 	}
 }
 
-func TestCreateOrUpdateSecret_Create(t *testing.T) {
-	c := NewClient(newFakeClient())
+func TestApplySecret_Create(t *testing.T) {
+	c := newTestClient()
 
-	secret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{Name: "new-secret", Namespace: "default"},
-		Data:       map[string][]byte{"key": []byte("value")},
-		Type:       corev1.SecretTypeOpaque,
-	}
-	err := c.CreateOrUpdateSecret(context.Background(), false, secret)
+	secret := corev1ac.Secret("new-secret", "default").
+		WithType(corev1.SecretTypeOpaque).
+		WithData(map[string][]byte{"key": []byte("value")})
+	err := c.ApplySecret(context.Background(), secret)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	// Verify it was created
 	got := &corev1.Secret{}
 	err = c.parentClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "new-secret"}, got)
 	if err != nil {
-		t.Fatalf("secret not found after create: %v", err)
+		t.Fatalf("secret not found after apply: %v", err)
+	}
+	if string(got.Data["key"]) != "value" {
+		t.Errorf("Data[key] = %q, want %q", got.Data["key"], "value")
+	}
+}
+
+func TestApplySecret_KeepsUnmanagedFields(t *testing.T) {
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "existing",
+			Namespace: "default",
+			Labels:    map[string]string{"other-tool": "true"},
+		},
+		Data: map[string][]byte{"key": []byte("old"), "other": []byte("keep")},
+		Type: corev1.SecretTypeOpaque,
+	}
+	c := newTestClient(existing)
+
+	secret := corev1ac.Secret("existing", "default").
+		WithLabels(map[string]string{"managed": "true"}).
+		WithData(map[string][]byte{"key": []byte("new")})
+	if err := c.ApplySecret(context.Background(), secret); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := &corev1.Secret{}
+	_ = c.parentClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "existing"}, got)
+	if string(got.Data["key"]) != "new" {
+		t.Errorf("Data[key] = %q, want %q", got.Data["key"], "new")
+	}
+	if string(got.Data["other"]) != "keep" {
+		t.Errorf("Data[other] = %q, want %q", got.Data["other"], "keep")
+	}
+	if got.Labels["other-tool"] != "true" || got.Labels["managed"] != "true" {
+		t.Errorf("Labels = %v, want both the existing and the applied label", got.Labels)
 	}
 }
 
-func TestCreateOrUpdateSecret_Update(t *testing.T) {
+func TestPatchSecret_RemovesKey(t *testing.T) {
 	existing := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "default"},
-		Data:       map[string][]byte{"key": []byte("old")},
+		Data:       map[string][]byte{"key": []byte("old"), "other": []byte("keep")},
 		Type:       corev1.SecretTypeOpaque,
 	}
-	c := NewClient(newFakeClient(existing))
+	c := newTestClient(existing)
 
-	// Re-fetch to get resource version
 	fetched := &corev1.Secret{}
 	_ = c.parentClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "existing"}, fetched)
-	fetched.Data["key"] = []byte("new")
+	original := fetched.DeepCopy()
+	delete(fetched.Data, "key")
 
-	err := c.CreateOrUpdateSecret(context.Background(), true, fetched)
-	if err != nil {
+	if err := c.PatchSecret(context.Background(), original, fetched); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	got := &corev1.Secret{}
 	_ = c.parentClient.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "existing"}, got)
-	if string(got.Data["key"]) != "new" {
-		t.Errorf("Data[key] = %q, want %q", got.Data["key"], "new")
+	if _, ok := got.Data["key"]; ok {
+		t.Error("expected the key to be removed")
+	}
+	if string(got.Data["other"]) != "keep" {
+		t.Errorf("Data[other] = %q, want %q", got.Data["other"], "keep")
+	}
+}
+
+func TestFindAdditionalScrapeConfigsForSecret_MatchesNamespace(t *testing.T) {
+	newConfig := func(name string, secretNamespace string) *prometheusv1.AdditionalScrapeConfig {
+		return &prometheusv1.AdditionalScrapeConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: prometheusv1.AdditionalScrapeConfigSpec{
+				SecretName:      "shared",
+				SecretNamespace: secretNamespace,
+			},
+		}
+	}
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(newScheme()).
+		WithObjects(newConfig("cfg1", "ns1"), newConfig("cfg2", "ns2")).
+		WithIndex(&prometheusv1.AdditionalScrapeConfig{}, SecretIndexField, func(obj client.Object) []string {
+			return GetReferencedSecretIndexKeys(obj.(*prometheusv1.AdditionalScrapeConfig))
+		}).
+		Build()
+	c := NewClient(fakeClient, fakeClient)
+
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "ns2"}}
+	list, err := c.FindAdditionalScrapeConfigsForSecret(context.Background(), secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "cfg2" {
+		t.Errorf("got %v, want only cfg2", list.Items)
 	}
 }
 
@@ -202,7 +271,7 @@ func TestGetAllAdditionalScrapeConfigs_Populated(t *testing.T) {
 	c2 := &prometheusv1.AdditionalScrapeConfig{
 		ObjectMeta: metav1.ObjectMeta{Name: "c2", Namespace: "other"},
 	}
-	c := NewClient(newFakeClient(c1, c2))
+	c := newTestClient(c1, c2)
 	list, err := c.GetAllAdditionalScrapeConfigs(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -213,7 +282,7 @@ func TestGetAllAdditionalScrapeConfigs_Populated(t *testing.T) {
 }
 
 func TestGetAllAdditionalScrapeConfigs_Empty(t *testing.T) {
-	c := NewClient(newFakeClient())
+	c := newTestClient()
 	list, err := c.GetAllAdditionalScrapeConfigs(context.Background())
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -222,3 +291,143 @@ func TestGetAllAdditionalScrapeConfigs_Empty(t *testing.T) {
 		t.Errorf("got %d items, want 0", len(list.Items))
 	}
 }
+
+func TestGetSecret_UsesAPIReader(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "uncached", Namespace: "default"},
+		Type:       corev1.SecretTypeOpaque,
+	}
+	// The cached client doesn't see the secret, only the API reader does
+	c := NewClient(newFakeClient(), newFakeClient(secret))
+
+	_, exists, err := c.GetSecretByName(context.Background(), "default", "uncached")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists {
+		t.Error("expected the secret to be loaded through the API reader")
+	}
+}
+
+func TestListConfigMaps_UsesAPIReader(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "cfg-rev-1", Namespace: "default", Labels: map[string]string{"config": "cfg"}},
+	}
+	// A ConfigMap created by the previous reconcile may not be in the cache yet
+	c := NewClient(newFakeClient(), newFakeClient(configMap))
+
+	list, err := c.ListConfigMaps(context.Background(), "default", map[string]string{"config": "cfg"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Items) != 1 {
+		t.Errorf("got %d ConfigMaps, want the one only seen by the API reader", len(list.Items))
+	}
+}
+
+func TestGetSecretByName_NotFound(t *testing.T) {
+	c := newTestClient()
+
+	got, exists, err := c.GetSecretByName(context.Background(), "other", "missing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exists {
+		t.Error("expected secret to not exist")
+	}
+	if got.Name != "missing" || got.Namespace != "other" {
+		t.Errorf("secret = %s/%s, want other/missing", got.Namespace, got.Name)
+	}
+}
+
+func TestDeleteSecret(t *testing.T) {
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "existing", Namespace: "default"},
+		Type:       corev1.SecretTypeOpaque,
+	}
+	c := newTestClient(existing)
+
+	if err := c.DeleteSecret(context.Background(), existing); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, exists, err := c.GetSecretByName(context.Background(), "default", "existing")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exists {
+		t.Error("expected secret to be deleted")
+	}
+
+	// Deleting an already deleted secret is not an error
+	if err := c.DeleteSecret(context.Background(), existing); err != nil {
+		t.Errorf("unexpected error on repeated delete: %v", err)
+	}
+}
+
+func TestGetReferencedSecretIndexKeys_IncludesCredentialSecrets(t *testing.T) {
+	config := &prometheusv1.AdditionalScrapeConfig{
+		ObjectMeta: metav1.ObjectMeta{Name: "cfg", Namespace: "monitoring"},
+		Spec: prometheusv1.AdditionalScrapeConfigSpec{
+			SecretName:      "out",
+			SecretNamespace: "ns",
+			Reload: &prometheusv1.ReloadSpec{
+				PrometheusEndpoint: prometheusv1.PrometheusEndpoint{
+					BearerTokenSecret: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "token"}, Key: "token"},
+				},
+			},
+			TargetHealth: &prometheusv1.TargetHealthSpec{
+				PrometheusEndpoint: prometheusv1.PrometheusEndpoint{
+					BasicAuth: &prometheusv1.BasicAuth{
+						Username: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "auth"}, Key: "user"},
+						Password: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "auth"}, Key: "password"},
+					},
+				},
+			},
+		},
+	}
+
+	// Credential secrets are in the namespace of the config, and listed once
+	keys := GetReferencedSecretIndexKeys(config)
+	if len(keys) != 3 || keys[0] != "ns/out" || keys[1] != "monitoring/token" || keys[2] != "monitoring/auth" {
+		t.Errorf("keys = %v, want [ns/out monitoring/token monitoring/auth]", keys)
+	}
+}
+
+func TestFindScrapeJobsForTemplate_UsesIndex(t *testing.T) {
+	newJob := func(name string, namespace string, ref *prometheusv1.ScrapeJobTemplateReference) *prometheusv1.ScrapeJob {
+		return &prometheusv1.ScrapeJob{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       prometheusv1.ScrapeJobSpec{TemplateRef: ref},
+		}
+	}
+	fakeClient := fake.NewClientBuilder().
+		WithScheme(newScheme()).
+		WithObjects(
+			newJob("namespaced", "ns1", &prometheusv1.ScrapeJobTemplateReference{Name: "tmpl"}),
+			newJob("other-namespace", "ns2", &prometheusv1.ScrapeJobTemplateReference{Name: "tmpl"}),
+			newJob("cluster", "ns1", &prometheusv1.ScrapeJobTemplateReference{Kind: prometheusv1.ClusterScrapeJobTemplateKind, Name: "tmpl"}),
+			newJob("untemplated", "ns1", nil),
+		).
+		WithIndex(&prometheusv1.ScrapeJob{}, TemplateIndexField, func(obj client.Object) []string {
+			return GetReferencedTemplateIndexKeys(obj.(*prometheusv1.ScrapeJob))
+		}).
+		Build()
+	c := NewClient(fakeClient, fakeClient)
+
+	list, err := c.FindScrapeJobsForTemplate(context.Background(), prometheusv1.ScrapeJobTemplateKind, "ns1", "tmpl")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "namespaced" {
+		t.Errorf("got %v, want only the namespaced job", list.Items)
+	}
+
+	list, err = c.FindScrapeJobsForTemplate(context.Background(), prometheusv1.ClusterScrapeJobTemplateKind, "", "tmpl")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].Name != "cluster" {
+		t.Errorf("got %v, want only the cluster template job", list.Items)
+	}
+}
@@ -0,0 +1,127 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPAuth holds the credentials sent to a Prometheus server. Basic auth is
+// used if Username is set, a bearer token if BearerToken is set.
+type HTTPAuth struct {
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+// APIClient calls the HTTP API of Prometheus servers.
+type APIClient interface {
+	// Reload asks the server at the URL to reload its configuration.
+	Reload(ctx context.Context, url string, auth HTTPAuth) error
+	// Targets returns the active targets of the server at the URL.
+	Targets(ctx context.Context, url string, auth HTTPAuth) ([]Target, error)
+}
+
+// Target is an active target returned by the targets API.
+type Target struct {
+	// ScrapePool is the job name the target was discovered by.
+	ScrapePool string    `json:"scrapePool"`
+	ScrapeURL  string    `json:"scrapeUrl"`
+	Health     string    `json:"health"`
+	LastError  string    `json:"lastError"`
+	LastScrape time.Time `json:"lastScrape"`
+}
+
+// targetsResponse is the response of the targets API.
+type targetsResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ActiveTargets []Target `json:"activeTargets"`
+	} `json:"data"`
+}
+
+// HTTPClient is the APIClient talking to the servers over HTTP.
+type HTTPClient struct {
+	client *http.Client
+}
+
+// maxErrorBodyLength is the length of the response body included in errors.
+const maxErrorBodyLength = 256
+
+func NewHTTPClient(timeout time.Duration) *HTTPClient {
+	return &HTTPClient{
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (r *HTTPClient) Reload(ctx context.Context, url string, auth HTTPAuth) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
+	if nil != err {
+		return err
+	}
+
+	_, err = r.do(request, auth)
+
+	return err
+}
+
+func (r *HTTPClient) Targets(ctx context.Context, url string, auth HTTPAuth) ([]Target, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if nil != err {
+		return nil, err
+	}
+	query := request.URL.Query()
+	query.Set("state", "active")
+	request.URL.RawQuery = query.Encode()
+
+	body, err := r.do(request, auth)
+	if nil != err {
+		return nil, err
+	}
+
+	var response targetsResponse
+	if err = json.Unmarshal(body, &response); nil != err {
+		return nil, fmt.Errorf("failed to parse the targets of %s: %w", request.URL.Redacted(), err)
+	}
+	if response.Status != "success" {
+		return nil, fmt.Errorf("%s %s failed: %s", request.Method, request.URL.Redacted(), response.Error)
+	}
+
+	return response.Data.ActiveTargets, nil
+}
+
+// do sends the request and returns the response body. Responses other than
+// 2xx are returned as errors.
+func (r *HTTPClient) do(request *http.Request, auth HTTPAuth) ([]byte, error) {
+	if auth.Username != "" {
+		request.SetBasicAuth(auth.Username, auth.Password)
+	} else if auth.BearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+auth.BearerToken)
+	}
+
+	response, err := r.client.Do(request)
+	if nil != err {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if nil != err {
+		return nil, err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message := strings.TrimSpace(string(body))
+		if len(message) > maxErrorBodyLength {
+			message = message[:maxErrorBodyLength] + "..."
+		}
+		return nil, fmt.Errorf("%s %s returned %s: %s", request.Method, request.URL.Redacted(), response.Status, message)
+	}
+
+	return body, nil
+}
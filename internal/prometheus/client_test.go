@@ -0,0 +1,78 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPClient_Reload(t *testing.T) {
+	var method, path, authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path, authorization = r.Method, r.URL.Path, r.Header.Get("Authorization")
+	}))
+	defer server.Close()
+
+	client := NewHTTPClient(time.Second)
+	if err := client.Reload(context.Background(), server.URL+"/-/reload", HTTPAuth{BearerToken: "token"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if method != http.MethodPost || path != "/-/reload" || authorization != "Bearer token" {
+		t.Errorf("got %s %s with authorization %q", method, path, authorization)
+	}
+
+	if err := client.Reload(context.Background(), server.URL+"/-/reload", HTTPAuth{Username: "user", Password: "pass"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(authorization, "Basic ") {
+		t.Errorf("authorization = %q, want basic auth", authorization)
+	}
+}
+
+func TestHTTPClient_ReloadError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Lifecycle API is not enabled.", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	err := NewHTTPClient(time.Second).Reload(context.Background(), server.URL+"/-/reload", HTTPAuth{})
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden: Lifecycle API is not enabled.") {
+		t.Errorf("err = %v, want the status and the body", err)
+	}
+}
+
+func TestHTTPClient_Targets(t *testing.T) {
+	var path, state string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, state = r.URL.Path, r.URL.Query().Get("state")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"activeTargets":[{"scrapePool":"job","scrapeUrl":"http://x:80/metrics","health":"down","lastError":"connection refused","lastScrape":"2024-01-02T03:04:05Z"}],"droppedTargets":[]}}`))
+	}))
+	defer server.Close()
+
+	targets, err := NewHTTPClient(time.Second).Targets(context.Background(), server.URL+"/api/v1/targets", HTTPAuth{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path != "/api/v1/targets" || state != "active" {
+		t.Errorf("got %s with state %q", path, state)
+	}
+	want := Target{ScrapePool: "job", ScrapeURL: "http://x:80/metrics", Health: "down", LastError: "connection refused", LastScrape: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if len(targets) != 1 || !targets[0].LastScrape.Equal(want.LastScrape) || targets[0].ScrapePool != want.ScrapePool || targets[0].ScrapeURL != want.ScrapeURL || targets[0].Health != want.Health || targets[0].LastError != want.LastError {
+		t.Errorf("targets = %+v, want %+v", targets, want)
+	}
+}
+
+func TestHTTPClient_TargetsError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"error","errorType":"internal","error":"something broke"}`))
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPClient(time.Second).Targets(context.Background(), server.URL+"/api/v1/targets", HTTPAuth{})
+	if err == nil || !strings.Contains(err.Error(), "something broke") {
+		t.Errorf("err = %v, want the error of the response", err)
+	}
+}
@@ -0,0 +1,208 @@
+package prometheus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// JobListDiff describes the differences between two rendered job lists.
+type JobListDiff struct {
+	AddedJobs   []string
+	RemovedJobs []string
+	ChangedJobs []JobDiff
+}
+
+// JobDiff describes the differences between two versions of the same job.
+type JobDiff struct {
+	JobName        string
+	AddedTargets   []string
+	RemovedTargets []string
+	ChangedLabels  []TargetLabelsDiff
+	// SettingsChanged is true if anything but the targets changed, like the
+	// metrics path, the params or the relabel configs.
+	SettingsChanged bool
+}
+
+// TargetLabelsDiff describes a target present in both versions of a job with
+// different labels.
+type TargetLabelsDiff struct {
+	Target         string
+	PreviousLabels map[string]string
+	CurrentLabels  map[string]string
+}
+
+// DiffJobs compares the previous and the current job lists. Jobs are matched
+// by name, targets by address.
+func DiffJobs(previous []Job, current []Job) *JobListDiff {
+	previousJobs := getJobsByName(previous)
+	currentJobs := getJobsByName(current)
+
+	diff := &JobListDiff{}
+	for name, currentJob := range currentJobs {
+		previousJob, ok := previousJobs[name]
+		if !ok {
+			diff.AddedJobs = append(diff.AddedJobs, name)
+			continue
+		}
+		if jobDiff := diffJob(name, previousJob, currentJob); nil != jobDiff {
+			diff.ChangedJobs = append(diff.ChangedJobs, *jobDiff)
+		}
+	}
+	for name := range previousJobs {
+		if _, ok := currentJobs[name]; !ok {
+			diff.RemovedJobs = append(diff.RemovedJobs, name)
+		}
+	}
+
+	sort.Strings(diff.AddedJobs)
+	sort.Strings(diff.RemovedJobs)
+	sort.Slice(diff.ChangedJobs, func(i, j int) bool {
+		return diff.ChangedJobs[i].JobName < diff.ChangedJobs[j].JobName
+	})
+
+	return diff
+}
+
+// IsEmpty returns true if the job lists are equivalent.
+func (d *JobListDiff) IsEmpty() bool {
+	return len(d.AddedJobs) == 0 && len(d.RemovedJobs) == 0 && len(d.ChangedJobs) == 0
+}
+
+// Summary returns a one line description of the diff.
+func (d *JobListDiff) Summary() string {
+	if d.IsEmpty() {
+		return "no changes"
+	}
+
+	var parts []string
+	for _, part := range []struct {
+		count int
+		verb  string
+	}{
+		{len(d.AddedJobs), "added"},
+		{len(d.RemovedJobs), "removed"},
+		{len(d.ChangedJobs), "changed"},
+	} {
+		if part.count == 1 {
+			parts = append(parts, fmt.Sprintf("1 job %s", part.verb))
+		} else if part.count > 1 {
+			parts = append(parts, fmt.Sprintf("%d jobs %s", part.count, part.verb))
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Lines returns every change in the diff as a separate line.
+func (d *JobListDiff) Lines() []string {
+	var lines []string
+	for _, name := range d.AddedJobs {
+		lines = append(lines, fmt.Sprintf("added job %s", name))
+	}
+	for _, name := range d.RemovedJobs {
+		lines = append(lines, fmt.Sprintf("removed job %s", name))
+	}
+	for _, job := range d.ChangedJobs {
+		if job.SettingsChanged {
+			lines = append(lines, fmt.Sprintf("job %s: changed settings", job.JobName))
+		}
+		for _, target := range job.AddedTargets {
+			lines = append(lines, fmt.Sprintf("job %s: added target %s", job.JobName, target))
+		}
+		for _, target := range job.RemovedTargets {
+			lines = append(lines, fmt.Sprintf("job %s: removed target %s", job.JobName, target))
+		}
+		for _, labels := range job.ChangedLabels {
+			lines = append(lines, fmt.Sprintf("job %s: changed labels of target %s from {%s} to {%s}", job.JobName, labels.Target, formatLabels(labels.PreviousLabels), formatLabels(labels.CurrentLabels)))
+		}
+	}
+
+	return lines
+}
+
+func getJobsByName(jobs []Job) map[string]Job {
+	jobsByName := make(map[string]Job, len(jobs))
+	for _, job := range jobs {
+		jobsByName[job.JobName] = job
+	}
+
+	return jobsByName
+}
+
+// getTargetLabels returns the labels of every target in the job. If a target
+// is listed in multiple static configs, the labels of the last one are used.
+func getTargetLabels(job Job) map[string]map[string]string {
+	targets := make(map[string]map[string]string)
+	for _, staticConfig := range job.StaticConfigs {
+		for _, target := range staticConfig.Targets {
+			targets[target] = staticConfig.Labels
+		}
+	}
+
+	return targets
+}
+
+func diffJob(name string, previous Job, current Job) *JobDiff {
+	previousTargets := getTargetLabels(previous)
+	currentTargets := getTargetLabels(current)
+
+	diff := &JobDiff{JobName: name, SettingsChanged: !equalJobSettings(previous, current)}
+	for target, currentLabels := range currentTargets {
+		previousLabels, ok := previousTargets[target]
+		if !ok {
+			diff.AddedTargets = append(diff.AddedTargets, target)
+			continue
+		}
+		if formatLabels(previousLabels) != formatLabels(currentLabels) {
+			diff.ChangedLabels = append(diff.ChangedLabels, TargetLabelsDiff{
+				Target:         target,
+				PreviousLabels: previousLabels,
+				CurrentLabels:  currentLabels,
+			})
+		}
+	}
+	for target := range previousTargets {
+		if _, ok := currentTargets[target]; !ok {
+			diff.RemovedTargets = append(diff.RemovedTargets, target)
+		}
+	}
+
+	if !diff.SettingsChanged && len(diff.AddedTargets) == 0 && len(diff.RemovedTargets) == 0 && len(diff.ChangedLabels) == 0 {
+		return nil
+	}
+
+	sort.Strings(diff.AddedTargets)
+	sort.Strings(diff.RemovedTargets)
+	sort.Slice(diff.ChangedLabels, func(i, j int) bool {
+		return diff.ChangedLabels[i].Target < diff.ChangedLabels[j].Target
+	})
+
+	return diff
+}
+
+// equalJobSettings returns true if the jobs only differ in their static
+// configs.
+func equalJobSettings(previous Job, current Job) bool {
+	previous.StaticConfigs = nil
+	current.StaticConfigs = nil
+
+	previousData, previousErr := yaml.Marshal(previous)
+	currentData, currentErr := yaml.Marshal(current)
+
+	return nil == previousErr && nil == currentErr && string(previousData) == string(currentData)
+}
+
+// formatLabels returns the labels as comma separated name=value pairs, sorted
+// by name.
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ", ")
+}
@@ -0,0 +1,71 @@
+package prometheus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffJobs(t *testing.T) {
+	previous := []Job{
+		{JobName: "removed", StaticConfigs: []StaticConfig{{Targets: []string{"a:80"}}}},
+		{JobName: "unchanged", StaticConfigs: []StaticConfig{{Targets: []string{"b:80"}, Labels: map[string]string{"env": "prod"}}}},
+		{JobName: "changed", StaticConfigs: []StaticConfig{
+			{Targets: []string{"kept:80", "gone:80"}, Labels: map[string]string{"env": "dev"}},
+		}},
+	}
+	current := []Job{
+		{JobName: "added", StaticConfigs: []StaticConfig{{Targets: []string{"c:80"}}}},
+		{JobName: "unchanged", StaticConfigs: []StaticConfig{{Targets: []string{"b:80"}, Labels: map[string]string{"env": "prod"}}}},
+		{JobName: "changed", StaticConfigs: []StaticConfig{
+			{Targets: []string{"kept:80", "new:80"}, Labels: map[string]string{"env": "prod"}},
+		}},
+	}
+
+	diff := DiffJobs(previous, current)
+
+	if got := diff.Summary(); got != "1 job added, 1 job removed, 1 job changed" {
+		t.Errorf("summary = %q", got)
+	}
+	want := []string{
+		"added job added",
+		"removed job removed",
+		"job changed: added target new:80",
+		"job changed: removed target gone:80",
+		"job changed: changed labels of target kept:80 from {env=dev} to {env=prod}",
+	}
+	if got := diff.Lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+}
+
+func TestDiffJobs_NoChanges(t *testing.T) {
+	jobs := []Job{{JobName: "job", StaticConfigs: []StaticConfig{{Targets: []string{"a:80"}}}}}
+
+	diff := DiffJobs(jobs, jobs)
+
+	if !diff.IsEmpty() {
+		t.Errorf("expected an empty diff, got %+v", diff)
+	}
+	if got := diff.Summary(); got != "no changes" {
+		t.Errorf("summary = %q", got)
+	}
+	if got := diff.Lines(); len(got) != 0 {
+		t.Errorf("lines = %q, want none", got)
+	}
+}
+
+func TestDiffJobs_SettingsChanged(t *testing.T) {
+	staticConfigs := []StaticConfig{{Targets: []string{"https://example.com"}}}
+	previous := []Job{NewMultiTargetJob("probe", "blackbox:9115", "/probe", map[string][]string{"module": {"http_2xx"}}, staticConfigs)}
+	current := []Job{NewMultiTargetJob("probe", "blackbox:9115", "/probe", map[string][]string{"module": {"tcp_connect"}}, staticConfigs)}
+
+	diff := DiffJobs(previous, current)
+
+	want := []string{"job probe: changed settings"}
+	if got := diff.Lines(); !reflect.DeepEqual(got, want) {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+	if !DiffJobs(previous, previous).IsEmpty() {
+		t.Error("expected no changes between the same jobs")
+	}
+}
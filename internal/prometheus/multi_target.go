@@ -0,0 +1,19 @@
+package prometheus
+
+// NewMultiTargetJob returns a job scraping the targets through a multi-target
+// exporter, like the blackbox exporter. The address of each target is passed
+// to the exporter in the target parameter and kept as the instance label,
+// while the exporter itself is scraped.
+func NewMultiTargetJob(jobName string, exporterAddress string, metricsPath string, params map[string][]string, staticConfigs []StaticConfig) Job {
+	return Job{
+		JobName:       jobName,
+		MetricsPath:   metricsPath,
+		Params:        params,
+		StaticConfigs: staticConfigs,
+		RelabelConfigs: []RelabelConfig{
+			{SourceLabels: []string{"__address__"}, TargetLabel: "__param_target"},
+			{SourceLabels: []string{"__param_target"}, TargetLabel: "instance"},
+			{TargetLabel: "__address__", Replacement: exporterAddress},
+		},
+	}
+}
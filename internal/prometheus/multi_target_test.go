@@ -0,0 +1,46 @@
+package prometheus
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestNewMultiTargetJob(t *testing.T) {
+	job := NewMultiTargetJob("probe", "blackbox:9115", "/probe", map[string][]string{"module": {"http_2xx"}}, []StaticConfig{
+		{Targets: []string{"https://example.com/health"}, Labels: map[string]string{"env": "prod"}},
+	})
+
+	// URLs are only accepted as targets if they are relabeled
+	if err := ValidateJob(job); nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := yaml.Marshal(job)
+	if nil != err {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := `job_name: probe
+metrics_path: /probe
+params:
+  module:
+  - http_2xx
+static_configs:
+- targets:
+  - https://example.com/health
+  labels:
+    env: prod
+relabel_configs:
+- source_labels:
+  - __address__
+  target_label: __param_target
+- source_labels:
+  - __param_target
+  target_label: instance
+- target_label: __address__
+  replacement: blackbox:9115
+`
+	if string(data) != want {
+		t.Errorf("rendered job =\n%s\nwant\n%s", data, want)
+	}
+}
@@ -1,11 +1,49 @@
 package prometheus
 
 type Job struct {
-	JobName       string         `yaml:"job_name"`
-	StaticConfigs []StaticConfig `yaml:"static_configs"`
+	JobName              string              `yaml:"job_name"`
+	ScrapeInterval       string              `yaml:"scrape_interval,omitempty"`
+	ScrapeTimeout        string              `yaml:"scrape_timeout,omitempty"`
+	MetricsPath          string              `yaml:"metrics_path,omitempty"`
+	Scheme               string              `yaml:"scheme,omitempty"`
+	Params               map[string][]string `yaml:"params,omitempty"`
+	BasicAuth            *BasicAuth          `yaml:"basic_auth,omitempty"`
+	Authorization        *Authorization      `yaml:"authorization,omitempty"`
+	TLSConfig            *TLSConfig          `yaml:"tls_config,omitempty"`
+	StaticConfigs        []StaticConfig      `yaml:"static_configs"`
+	RelabelConfigs       []RelabelConfig     `yaml:"relabel_configs,omitempty"`
+	MetricRelabelConfigs []RelabelConfig     `yaml:"metric_relabel_configs,omitempty"`
 }
 
 type StaticConfig struct {
 	Targets []string          `yaml:"targets"`
 	Labels  map[string]string `yaml:"labels"`
 }
+
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,omitempty"`
+	Separator    string   `yaml:"separator,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	Modulus      int64    `yaml:"modulus,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action,omitempty"`
+}
+
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+type Authorization struct {
+	Type            string `yaml:"type,omitempty"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
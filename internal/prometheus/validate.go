@@ -0,0 +1,59 @@
+package prometheus
+
+import (
+	"fmt"
+	"log/slog"
+
+	promconfig "github.com/prometheus/prometheus/config"
+	"gopkg.in/yaml.v2"
+)
+
+// JobValidationError is returned when a job is rejected by the Prometheus
+// config parser.
+type JobValidationError struct {
+	JobName string
+	Err     error
+}
+
+func (e *JobValidationError) Error() string {
+	return fmt.Sprintf("job %s is rejected by Prometheus: %s", e.JobName, e.Err)
+}
+
+func (e *JobValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateJob parses the job with the Prometheus config parser, the same way
+// Prometheus loads the additional scrape configs.
+func ValidateJob(job Job) error {
+	if err := loadScrapeConfigs([]Job{job}); nil != err {
+		return &JobValidationError{JobName: job.JobName, Err: err}
+	}
+
+	return nil
+}
+
+// ValidateJobs parses the assembled jobs with the Prometheus config parser in
+// one pass. The jobs are expected to be validated separately with ValidateJob
+// while rendering, so this only catches errors spanning multiple jobs, like
+// duplicate job names.
+func ValidateJobs(jobs []Job) error {
+	if err := loadScrapeConfigs(jobs); nil != err {
+		return fmt.Errorf("the scrape configs are rejected by Prometheus: %w", err)
+	}
+
+	return nil
+}
+
+func loadScrapeConfigs(jobs []Job) error {
+	data, err := yaml.Marshal(struct {
+		ScrapeConfigs []Job `yaml:"scrape_configs"`
+	}{jobs})
+	if nil != err {
+		return err
+	}
+
+	_, err = promconfig.Load(string(data), slog.New(slog.DiscardHandler))
+
+	return err
+}
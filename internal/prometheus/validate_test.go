@@ -0,0 +1,46 @@
+package prometheus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateJob(t *testing.T) {
+	valid := Job{JobName: "valid", StaticConfigs: []StaticConfig{{Targets: []string{"host:80"}, Labels: map[string]string{"env": "prod"}}}}
+	if err := ValidateJob(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err := ValidateJob(Job{JobName: "invalid", StaticConfigs: []StaticConfig{{Targets: []string{"http://host:80/path"}}}})
+	var jobErr *JobValidationError
+	if !errors.As(err, &jobErr) || jobErr.JobName != "invalid" {
+		t.Errorf("err = %v, want a JobValidationError naming the invalid job", err)
+	}
+}
+
+func TestValidateJobs(t *testing.T) {
+	valid := Job{JobName: "valid", StaticConfigs: []StaticConfig{{Targets: []string{"host:80"}, Labels: map[string]string{"env": "prod"}}}}
+	other := Job{JobName: "other", StaticConfigs: []StaticConfig{{Targets: []string{"host:81"}}}}
+
+	tests := map[string]struct {
+		jobs    []Job
+		wantErr bool
+	}{
+		"valid": {
+			jobs: []Job{valid, other},
+		},
+		"no jobs": {},
+		"duplicate job names": {
+			jobs:    []Job{valid, valid},
+			wantErr: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := ValidateJobs(tt.jobs); (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}